@@ -7,7 +7,14 @@ import (
 	"github.com/dzendmitry/rating-service/lib/mongo"
 	"fmt"
 	"github.com/dzendmitry/rating-service/lib/general"
+	"github.com/dzendmitry/rating-service/lib/auth"
+	"github.com/dzendmitry/rating-service/lib/mail"
 	"os"
+	"strconv"
+	"strings"
+	"time"
+	"github.com/dzendmitry/rating-service/lib/redis"
+	"golang.org/x/crypto/bcrypt"
 )
 
 var (
@@ -15,6 +22,60 @@ var (
 	mongoDb        = os.Getenv("MONGO_DB")
 	regJsonSchema  = os.Getenv("REG_JSON_SCHEMA")
 	authJsonSchema = os.Getenv("AUTH_JSON_SCHEMA")
+	sessionJsonSchema = os.Getenv("SESSION_JSON_SCHEMA")
+	changePasswordJsonSchema = os.Getenv("CHANGE_PASSWORD_JSON_SCHEMA")
+	resetRequestJsonSchema = os.Getenv("RESET_REQUEST_JSON_SCHEMA")
+	resetPasswordJsonSchema = os.Getenv("RESET_PASSWORD_JSON_SCHEMA")
+	mailer   = os.Getenv("MAILER")
+	smtpHost = os.Getenv("SMTP_HOST")
+	smtpPort = os.Getenv("SMTP_PORT")
+	smtpUser = os.Getenv("SMTP_USER")
+	smtpPass = os.Getenv("SMTP_PASS")
+	mailFrom = os.Getenv("MAIL_FROM")
+	resetPasswordLink = os.Getenv("RESET_PASSWORD_LINK")
+	verifyEmailLink = os.Getenv("VERIFY_EMAIL_LINK")
+	verifySecret = os.Getenv("VERIFY_SECRET")
+	serviceSecret = os.Getenv("SERVICE_SECRET")
+	passwordCost   = os.Getenv("PASSWORD_HASH_COST")
+	refreshJsonSchema = os.Getenv("REFRESH_JSON_SCHEMA")
+	introspectJsonSchema = os.Getenv("INTROSPECT_JSON_SCHEMA")
+	unlockJsonSchema = os.Getenv("UNLOCK_JSON_SCHEMA")
+	totpCodeJsonSchema = os.Getenv("TOTP_CODE_JSON_SCHEMA")
+	totpDisableJsonSchema = os.Getenv("TOTP_DISABLE_JSON_SCHEMA")
+	userIdJsonSchema = os.Getenv("USER_ID_JSON_SCHEMA")
+	apiKeyJsonSchema = os.Getenv("API_KEY_JSON_SCHEMA")
+	apiKeyIdJsonSchema = os.Getenv("API_KEY_ID_JSON_SCHEMA")
+	profileJsonSchema = os.Getenv("PROFILE_JSON_SCHEMA")
+	inviteJsonSchema = os.Getenv("INVITE_JSON_SCHEMA")
+	inviteIdJsonSchema = os.Getenv("INVITE_ID_JSON_SCHEMA")
+	admins     = os.Getenv("ADMINS")
+	sentinel1  = os.Getenv("REDIS_SENTINEL_1")
+	sentinel2  = os.Getenv("REDIS_SENTINEL_2")
+	sentinel3  = os.Getenv("REDIS_SENTINEL_3")
+	authMode   = os.Getenv("AUTH_MODE")
+	tokenKeys  = os.Getenv("TOKEN_KEYS")
+	tokenKeyId = os.Getenv("TOKEN_KEY_ID")
+	oidcProviders = os.Getenv("OIDC_PROVIDERS")
+	deletionGrace = os.Getenv("DELETION_GRACE_DAYS")
+	cookieSecure   = os.Getenv("COOKIE_SECURE")
+	cookieHttpOnly = os.Getenv("COOKIE_HTTP_ONLY")
+	cookieSameSite = os.Getenv("COOKIE_SAME_SITE")
+	cookieDomain   = os.Getenv("COOKIE_DOMAIN")
+	auditRetention = os.Getenv("AUDIT_RETENTION_DAYS")
+	sessionIdle      = os.Getenv("SESSION_IDLE_TIMEOUT")
+	sessionShortIdle = os.Getenv("SESSION_SHORT_IDLE_TIMEOUT")
+	sessionMaxAge    = os.Getenv("SESSION_MAX_AGE")
+	registrationMode = os.Getenv("REGISTRATION_MODE")
+	passwordMinLength = os.Getenv("PASSWORD_MIN_LENGTH")
+	passwordClasses = os.Getenv("PASSWORD_CHARACTER_CLASSES")
+	passwordForbidName = os.Getenv("PASSWORD_FORBID_NAME")
+	breachedPasswords = os.Getenv("BREACHED_PASSWORDS_FILE")
+
+	passwordHashCost = bcrypt.DefaultCost
+	deletionGraceDays = auth.DELETION_GRACE_DAYS
+	cookieConfig = auth.CookieConfig{HttpOnly: true, SameSite: auth.SAME_SITE_LAX}
+	auditRetentionDays = auth.AUDIT_RETENTION_DAYS
+	passwordPolicy = &auth.PasswordPolicy{MinLength: auth.PASSWORD_MIN_LENGTH, ForbidName: true}
 )
 
 func init() {
@@ -30,6 +91,181 @@ func init() {
 	if authJsonSchema == "" {
 		panic("env AUTH_JSON_SCHEMA is empty")
 	}
+	if sessionJsonSchema == "" {
+		panic("env SESSION_JSON_SCHEMA is empty")
+	}
+	if changePasswordJsonSchema == "" {
+		panic("env CHANGE_PASSWORD_JSON_SCHEMA is empty")
+	}
+	if resetRequestJsonSchema == "" {
+		panic("env RESET_REQUEST_JSON_SCHEMA is empty")
+	}
+	if resetPasswordJsonSchema == "" {
+		panic("env RESET_PASSWORD_JSON_SCHEMA is empty")
+	}
+	if verifySecret == "" {
+		panic("env VERIFY_SECRET is empty")
+	}
+	if serviceSecret == "" {
+		panic("env SERVICE_SECRET is empty")
+	}
+	if refreshJsonSchema == "" {
+		panic("env REFRESH_JSON_SCHEMA is empty")
+	}
+	if introspectJsonSchema == "" {
+		panic("env INTROSPECT_JSON_SCHEMA is empty")
+	}
+	if unlockJsonSchema == "" {
+		panic("env UNLOCK_JSON_SCHEMA is empty")
+	}
+	if totpCodeJsonSchema == "" {
+		panic("env TOTP_CODE_JSON_SCHEMA is empty")
+	}
+	if totpDisableJsonSchema == "" {
+		panic("env TOTP_DISABLE_JSON_SCHEMA is empty")
+	}
+	if userIdJsonSchema == "" {
+		panic("env USER_ID_JSON_SCHEMA is empty")
+	}
+	if apiKeyJsonSchema == "" {
+		panic("env API_KEY_JSON_SCHEMA is empty")
+	}
+	if apiKeyIdJsonSchema == "" {
+		panic("env API_KEY_ID_JSON_SCHEMA is empty")
+	}
+	if profileJsonSchema == "" {
+		panic("env PROFILE_JSON_SCHEMA is empty")
+	}
+	if inviteJsonSchema == "" {
+		panic("env INVITE_JSON_SCHEMA is empty")
+	}
+	if inviteIdJsonSchema == "" {
+		panic("env INVITE_ID_JSON_SCHEMA is empty")
+	}
+	switch registrationMode {
+	case "":
+		registrationMode = auth.REG_MODE_OPEN
+	case auth.REG_MODE_OPEN, auth.REG_MODE_CLOSED, auth.REG_MODE_INVITE:
+	default:
+		panic(fmt.Sprintf("env REGISTRATION_MODE must be %s, %s or %s", auth.REG_MODE_OPEN, auth.REG_MODE_CLOSED, auth.REG_MODE_INVITE))
+	}
+	switch authMode {
+	case "":
+		authMode = auth.AUTH_MODE_COOKIE
+	case auth.AUTH_MODE_COOKIE, auth.AUTH_MODE_TOKEN:
+	default:
+		panic(fmt.Sprintf("env AUTH_MODE must be %s or %s", auth.AUTH_MODE_COOKIE, auth.AUTH_MODE_TOKEN))
+	}
+	if authMode == auth.AUTH_MODE_TOKEN {
+		if tokenKeys == "" {
+			panic("env TOKEN_KEYS is empty")
+		}
+		keys, err := auth.ParseTokenKeys(tokenKeys)
+		if err != nil {
+			panic(fmt.Sprintf("env TOKEN_KEYS is invalid: %+v", err))
+		}
+		if err := auth.InitTokens(keys, tokenKeyId); err != nil {
+			panic(fmt.Sprintf("env TOKEN_KEY_ID is invalid: %+v", err))
+		}
+	}
+	if passwordCost != "" {
+		cost, err := strconv.Atoi(passwordCost)
+		if err != nil || cost < bcrypt.MinCost || cost > bcrypt.MaxCost {
+			panic(fmt.Sprintf("env PASSWORD_HASH_COST must be an integer in [%d, %d]", bcrypt.MinCost, bcrypt.MaxCost))
+		}
+		passwordHashCost = cost
+	}
+	if deletionGrace != "" {
+		days, err := strconv.Atoi(deletionGrace)
+		if err != nil || days < 0 {
+			panic("env DELETION_GRACE_DAYS must be a non-negative integer")
+		}
+		deletionGraceDays = days
+	}
+	if cookieSecure != "" {
+		secure, err := strconv.ParseBool(cookieSecure)
+		if err != nil {
+			panic("env COOKIE_SECURE must be a boolean")
+		}
+		cookieConfig.Secure = secure
+	}
+	if cookieHttpOnly != "" {
+		httpOnly, err := strconv.ParseBool(cookieHttpOnly)
+		if err != nil {
+			panic("env COOKIE_HTTP_ONLY must be a boolean")
+		}
+		cookieConfig.HttpOnly = httpOnly
+	}
+	if cookieSameSite != "" {
+		sameSite, ok := auth.ParseSameSite(cookieSameSite)
+		if !ok {
+			panic(fmt.Sprintf("env COOKIE_SAME_SITE must be %s, %s or %s", auth.SAME_SITE_LAX, auth.SAME_SITE_STRICT, auth.SAME_SITE_NONE))
+		}
+		// Browsers reject SameSite=None cookies without Secure
+		if sameSite == auth.SAME_SITE_NONE && !cookieConfig.Secure {
+			panic("env COOKIE_SAME_SITE=None requires COOKIE_SECURE=true")
+		}
+		cookieConfig.SameSite = sameSite
+	}
+	cookieConfig.Domain = cookieDomain
+	if auditRetention != "" {
+		days, err := strconv.Atoi(auditRetention)
+		if err != nil || days <= 0 {
+			panic("env AUDIT_RETENTION_DAYS must be a positive integer")
+		}
+		auditRetentionDays = days
+	}
+	idle, err := envSeconds("SESSION_IDLE_TIMEOUT", sessionIdle, auth.SESSION_IDLE_TIMEOUT)
+	if err != nil {
+		panic(err.Error())
+	}
+	shortIdle, err := envSeconds("SESSION_SHORT_IDLE_TIMEOUT", sessionShortIdle, auth.SESSION_SHORT_IDLE_TIMEOUT)
+	if err != nil {
+		panic(err.Error())
+	}
+	maxAge, err := envSeconds("SESSION_MAX_AGE", sessionMaxAge, auth.SESSION_MAX_AGE)
+	if err != nil {
+		panic(err.Error())
+	}
+	if err := auth.InitSessionTimeouts(idle, shortIdle, maxAge); err != nil {
+		panic(fmt.Sprintf("Session timeouts are invalid: %+v", err))
+	}
+	if passwordMinLength != "" {
+		n, err := strconv.Atoi(passwordMinLength)
+		if err != nil || n < 1 {
+			panic("env PASSWORD_MIN_LENGTH must be a positive integer")
+		}
+		passwordPolicy.MinLength = n
+	}
+	classes, err := auth.ParsePasswordClasses(passwordClasses)
+	if err != nil {
+		panic(fmt.Sprintf("env PASSWORD_CHARACTER_CLASSES is invalid: %+v", err))
+	}
+	passwordPolicy.Classes = classes
+	if passwordForbidName != "" {
+		forbid, err := strconv.ParseBool(passwordForbidName)
+		if err != nil {
+			panic("env PASSWORD_FORBID_NAME must be a boolean")
+		}
+		passwordPolicy.ForbidName = forbid
+	}
+	if breachedPasswords != "" {
+		if _, err := passwordPolicy.LoadBreached(breachedPasswords); err != nil {
+			panic(fmt.Sprintf("Loading breached passwords failed: %+v", err))
+		}
+	}
+}
+
+// envSeconds parses the duration in seconds, the default is used when the env is empty
+func envSeconds(name, value string, def int) (time.Duration, error) {
+	if value == "" {
+		return time.Duration(def) * time.Second, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("env %s must be a positive number of seconds", name)
+	}
+	return time.Duration(n) * time.Second, nil
 }
 
 func main() {
@@ -43,17 +279,137 @@ func main() {
 
 	regSchemaLoader := gojsonschema.NewReferenceLoader(regJsonSchema)
 	authSchemaLoader := gojsonschema.NewReferenceLoader(authJsonSchema)
+	sessionSchemaLoader := gojsonschema.NewReferenceLoader(sessionJsonSchema)
+	changePasswordSchemaLoader := gojsonschema.NewReferenceLoader(changePasswordJsonSchema)
+	resetRequestSchemaLoader := gojsonschema.NewReferenceLoader(resetRequestJsonSchema)
+	resetPasswordSchemaLoader := gojsonschema.NewReferenceLoader(resetPasswordJsonSchema)
+	refreshSchemaLoader := gojsonschema.NewReferenceLoader(refreshJsonSchema)
+	introspectSchemaLoader := gojsonschema.NewReferenceLoader(introspectJsonSchema)
+	unlockSchemaLoader := gojsonschema.NewReferenceLoader(unlockJsonSchema)
+	totpCodeSchemaLoader := gojsonschema.NewReferenceLoader(totpCodeJsonSchema)
+	totpDisableSchemaLoader := gojsonschema.NewReferenceLoader(totpDisableJsonSchema)
+	userIdSchemaLoader := gojsonschema.NewReferenceLoader(userIdJsonSchema)
+	apiKeySchemaLoader := gojsonschema.NewReferenceLoader(apiKeyJsonSchema)
+	apiKeyIdSchemaLoader := gojsonschema.NewReferenceLoader(apiKeyIdJsonSchema)
+	profileSchemaLoader := gojsonschema.NewReferenceLoader(profileJsonSchema)
+	inviteSchemaLoader := gojsonschema.NewReferenceLoader(inviteJsonSchema)
+	inviteIdSchemaLoader := gojsonschema.NewReferenceLoader(inviteIdJsonSchema)
 	schemaLoaders := map[string]gojsonschema.JSONLoader{
-		"reg": regSchemaLoader,
-		"auth": authSchemaLoader,
+		auth.REG_VALIDATE: regSchemaLoader,
+		auth.AUTH_VALIDATE: authSchemaLoader,
+		auth.SESSION_VALIDATE: sessionSchemaLoader,
+		auth.CHANGE_PASSWORD_VALIDATE: changePasswordSchemaLoader,
+		auth.RESET_REQUEST_VALIDATE: resetRequestSchemaLoader,
+		auth.RESET_PASSWORD_VALIDATE: resetPasswordSchemaLoader,
+		auth.REFRESH_VALIDATE: refreshSchemaLoader,
+		auth.INTROSPECT_VALIDATE: introspectSchemaLoader,
+		auth.UNLOCK_VALIDATE: unlockSchemaLoader,
+		auth.TOTP_CODE_VALIDATE: totpCodeSchemaLoader,
+		auth.TOTP_DISABLE_VALIDATE: totpDisableSchemaLoader,
+		auth.USER_ID_VALIDATE: userIdSchemaLoader,
+		auth.API_KEY_VALIDATE: apiKeySchemaLoader,
+		auth.API_KEY_ID_VALIDATE: apiKeyIdSchemaLoader,
+		auth.PROFILE_VALIDATE: profileSchemaLoader,
+		auth.INVITE_VALIDATE: inviteSchemaLoader,
+		auth.INVITE_ID_VALIDATE: inviteIdSchemaLoader,
+	}
+
+	m, err := mail.New(mailer, smtpHost, smtpPort, smtpUser, smtpPass, mailFrom, log)
+	if err != nil {
+		panic(fmt.Sprintf("Mailer initialization failed: %+v", err))
+	}
+
+	// Login attempts are counted in redis when sentinels are configured, single-node setups count them in memory
+	var attempts auth.IAttemptsStore = auth.NewMemoryAttemptsStore()
+	sentinels := make([]string, 0, 3)
+	for _, s := range []string{sentinel1, sentinel2, sentinel3} {
+		if s != "" {
+			sentinels = append(sentinels, s)
+		}
+	}
+	if len(sentinels) > 0 {
+		if err := redis.Start(sentinels, []string{redis.CACHE_EVICT}); err != nil {
+			log.Warnf("Redis starting error, login attempts are counted in memory: %+v", err)
+		} else {
+			defer redis.Close()
+			attempts = auth.NewFallbackAttemptsStore(auth.NewRedisAttemptsStore(redis.CACHE_EVICT), attempts, log)
+		}
 	}
 
-	h := NewHandlers(general.NewValidator(schemaLoaders, log), log)
+
+	// Login with identity providers is enabled by the providers file
+	var oidc *auth.Oidc
+	if oidcProviders != "" {
+		providers, err := auth.LoadOidcProviders(oidcProviders)
+		if err != nil {
+			panic(fmt.Sprintf("Loading oidc providers failed: %+v", err))
+		}
+		oidc = auth.NewOidc(providers, registrationMode == auth.REG_MODE_OPEN, log)
+	}
+
+	h := NewHandlers(general.NewValidator(schemaLoaders, log), m, attempts, oidc, Config{
+		PasswordCost: passwordHashCost,
+		VerifySecret: []byte(verifySecret),
+		ResetLink: resetPasswordLink,
+		VerifyLink: verifyEmailLink,
+		AuthMode: authMode,
+		DeletionGrace: time.Duration(deletionGraceDays) * 24 * time.Hour,
+		Cookie: cookieConfig,
+		AuditRetention: time.Duration(auditRetentionDays) * 24 * time.Hour,
+		RegistrationMode: registrationMode,
+		PasswordPolicy: passwordPolicy,
+		ServiceSecret: []byte(serviceSecret),
+	}, log)
 	defer h.Close()
+	go h.purge()
+
+	// Users listed in ADMINS get the admin role, other admins are managed in the storage
+	adminNames := make([]string, 0)
+	for _, name := range strings.Split(admins, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			adminNames = append(adminNames, name)
+		}
+	}
+	if err := h.auth.GrantAdmins(mongo.Users, adminNames); err != nil {
+		log.Warnf("Error while granting admin role to %v: %+v", adminNames, err)
+	}
 
 	http.HandleFunc(regUrl(), h.regHandler)
 	http.HandleFunc(unregUrl(), h.unregHandler)
 	http.HandleFunc(authUrl(), h.authHandler)
 	http.HandleFunc(exitUrl(), h.exitHandler)
+	http.HandleFunc(sessionsUrl(), h.sessionsHandler)
+	http.HandleFunc(revokeSessionUrl(), h.revokeSessionHandler)
+	http.HandleFunc(revokeOtherSessionsUrl(), h.revokeOtherSessionsHandler)
+	http.HandleFunc(changePasswordUrl(), h.changePasswordHandler)
+	http.HandleFunc(resetRequestUrl(), h.resetRequestHandler)
+	http.HandleFunc(resetPasswordUrl(), h.resetPasswordHandler)
+	http.HandleFunc(verifyEmailUrl(), h.verifyEmailHandler)
+	http.HandleFunc(resendVerificationUrl(), h.resendVerificationHandler)
+	http.HandleFunc(refreshTokenUrl(), h.refreshHandler)
+	http.HandleFunc(auth.IntrospectUri(), h.introspectHandler)
+	http.HandleFunc(auth.UserStatusUri(), h.userStatusHandler)
+	http.HandleFunc(unlockUrl(), h.unlockHandler)
+	http.HandleFunc(adminUsersUrl(), h.adminUsersHandler)
+	http.HandleFunc(adminDisableUrl(), h.adminDisableHandler)
+	http.HandleFunc(adminEnableUrl(), h.adminEnableHandler)
+	http.HandleFunc(adminLogoutUrl(), h.adminLogoutHandler)
+	http.HandleFunc(adminDeleteUrl(), h.adminDeleteHandler)
+	http.HandleFunc(adminAuditUrl(), h.adminAuditHandler)
+	http.HandleFunc(apiKeysUrl(), h.apiKeysHandler)
+	http.HandleFunc(createApiKeyUrl(), h.createApiKeyHandler)
+	http.HandleFunc(revokeApiKeyUrl(), h.revokeApiKeyHandler)
+	http.HandleFunc(totpEnrollUrl(), h.totpEnrollHandler)
+	http.HandleFunc(totpConfirmUrl(), h.totpConfirmHandler)
+	http.HandleFunc(totpDisableUrl(), h.totpDisableHandler)
+	http.HandleFunc(recoveryCodesUrl(), h.recoveryCodesHandler)
+	http.HandleFunc(oidcLoginUrl(), h.oidcLoginHandler)
+	http.HandleFunc(oidcCallbackUrl(), h.oidcCallbackHandler)
+	http.HandleFunc(profileUrl(), h.profileHandler)
+	http.HandleFunc(exportUrl(), h.exportHandler)
+	http.HandleFunc(csrfUrl(), h.csrfHandler)
+	http.HandleFunc(invitesUrl(), h.invitesHandler)
+	http.HandleFunc(createInviteUrl(), h.createInviteHandler)
+	http.HandleFunc(revokeInviteUrl(), h.revokeInviteHandler)
 	log.Panicf("%v", http.ListenAndServe(":8090", nil))
 }
\ No newline at end of file
@@ -4,21 +4,51 @@ import (
 	"net/http"
 	"encoding/json"
 	"time"
+	"fmt"
+	"strconv"
 	"github.com/dzendmitry/rating-service/lib/general"
 	"github.com/dzendmitry/rating-service/lib/mongo"
 	"github.com/dzendmitry/logger"
 	"github.com/dzendmitry/rating-service/lib/auth"
+	"github.com/dzendmitry/rating-service/lib/mail"
+	"gopkg.in/mgo.v2/bson"
 )
 
+type Config struct {
+	PasswordCost int
+	VerifySecret []byte
+	ResetLink string
+	VerifyLink string
+	AuthMode string
+	DeletionGrace time.Duration
+	Cookie auth.CookieConfig
+	AuditRetention time.Duration
+	RegistrationMode string
+	PasswordPolicy *auth.PasswordPolicy
+	ServiceSecret []byte
+}
+
+// userCollections contain documents of users by uid, they are removed along with the user
+var userCollections = []auth.IAuthDataSource{mongo.Sessions, mongo.Units, mongo.Answers, mongo.ApiKeys,
+	mongo.Invites, mongo.VerifyTokens, mongo.ResetTokens, mongo.Lists, mongo.Diary, mongo.History}
+
 type Handlers struct {
 	log logger.ILogger
 	auth *auth.Auth
+	mailer mail.IMailer
+	config Config
+	// nil when no identity providers are configured
+	oidc *auth.Oidc
 }
 
-func NewHandlers(validator *general.Validator, log logger.ILogger) *Handlers {
+func NewHandlers(validator *general.Validator, mailer mail.IMailer, attempts auth.IAttemptsStore, oidc *auth.Oidc, config Config, log logger.ILogger) *Handlers {
 	return &Handlers{
 		log: log,
-		auth: auth.New(validator),
+		auth: auth.New(validator, config.PasswordCost, config.VerifySecret, attempts, mongo.LockEvents,
+			mongo.AuditEvents, config.AuditRetention, config.PasswordPolicy),
+		mailer: mailer,
+		config: config,
+		oidc: oidc,
 	}
 }
 
@@ -33,26 +63,13 @@ func (h *Handlers) regHandler(w http.ResponseWriter, req *http.Request) {
 		w.WriteHeader(status)
 		return
 	}
-
-	err, errs := h.auth.Validator.Validate(body, auth.REG_VALIDATE)
-	if errs != nil {
-		if err != nil {
-			h.log.Warnf("%+v", err.Error())
-		}
-		h.log.Warnf("The document is not valid. see errors :\n")
-		h.log.Warnf("%+v", errs)
-		w.WriteHeader(http.StatusBadRequest)
-		w.Header().Set("Content-Type", "application/json")
-		if err := json.NewEncoder(w).Encode(errs); err != nil {
-			h.log.Warn("Error while encoding validation errors: %s", err.Error())
-			w.WriteHeader(http.StatusInternalServerError)
-			w.Header().Set("Content-Type", "text/plain")
-		}
+	if h.config.RegistrationMode == auth.REG_MODE_CLOSED {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(auth.ErrorRegistrationClosed{}.Error()))
 		return
 	}
-	if err != nil {
-		h.log.Warnf("%+v", err.Error())
-		w.WriteHeader(http.StatusBadRequest)
+
+	if !h.validate(w, body, auth.REG_VALIDATE, h.passwordViolations(body, auth.PASSWORD_FIELD, "")...) {
 		return
 	}
 
@@ -63,78 +80,115 @@ func (h *Handlers) regHandler(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	regObj.Password = general.GetHash(regObj.Password)
+	regObj.Password, err = h.auth.HashPassword(regObj.Password)
+	if err != nil {
+		h.log.Warnf("Error while hashing password: %s", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	regObj.Id = bson.NewObjectId()
+	regObj.Unverified = true
 
-	if err := h.auth.Register(mongo.Users, regObj); err != nil {
+	if h.config.RegistrationMode == auth.REG_MODE_INVITE {
+		err = h.auth.RegisterInvited(mongo.Users, mongo.Invites, &regObj, regObj.Invite)
+	} else {
+		err = h.auth.Register(mongo.Users, regObj)
+	}
+	h.auth.Audit.Record(auth.AUDIT_REGISTER, regObj.Id, regObj.Name, auth.NewClientInfo(req), err)
+	if err != nil {
 		h.log.Warnf("Error during the registration process: %s", err.Error())
 		if _, ok := err.(auth.ErrorUserExists); ok {
 			w.WriteHeader(http.StatusBadRequest)
+		} else if _, ok := err.(auth.ErrorInvalidInvite); ok {
+			w.WriteHeader(http.StatusForbidden)
 		} else {
 			w.WriteHeader(http.StatusInternalServerError)
 		}
 		w.Write([]byte(err.Error()))
 		return
 	}
-}
 
-func (h *Handlers) unregHandler(w http.ResponseWriter, req *http.Request) {
-	cookie, err := req.Cookie(auth.SidKey)
+	token, err := h.auth.NewVerifyToken(mongo.VerifyTokens, &regObj)
 	if err != nil {
-		h.log.Warnf("Cookie not round in request: %s", req.RequestURI)
-		w.WriteHeader(http.StatusBadRequest)
+		// The account is created anyway, verification email can be requested again
+		h.log.Warnf("Error while creating verification token for %s: %s", regObj.Name, err.Error())
 		return
 	}
+	h.sendVerification(&regObj, token)
+}
 
-	sid, err := h.auth.Exit(mongo.Sessions, cookie)
+func (h *Handlers) unregHandler(w http.ResponseWriter, req *http.Request) {
+	session, err := h.exit(req)
 	if err != nil {
 		h.log.Warnf("Error during the exit process: %s", err.Error())
-		w.WriteHeader(http.StatusInternalServerError)
+		if _, ok := err.(auth.ErrorNotAuthorized); ok {
+			w.WriteHeader(http.StatusBadRequest)
+		} else {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
 		w.Write([]byte(err.Error()))
 		return
 	}
 
-	if err := h.auth.Unregister(mongo.Units, mongo.Users, sid); err != nil {
+	deleteAt, err := h.auth.Unregister(mongo.Users, mongo.Sessions, session, h.config.DeletionGrace)
+	h.audit(req, auth.AUDIT_UNREGISTER, session, err)
+	if err != nil {
 		h.log.Warnf("Error during the unregistration process: %s", err.Error())
 		w.WriteHeader(http.StatusInternalServerError)
 		w.Write([]byte(err.Error()))
 		return
 	}
 
-	http.SetCookie(w, &http.Cookie{
-		Name: auth.SidKey,
-		Value: "",
-		Path: "/",
-		MaxAge: -1,
-	})
+	h.clearCookies(w)
+	h.writeJson(w, auth.DeletionInfo{DeleteAt: deleteAt})
 }
 
-func (h *Handlers) authHandler(w http.ResponseWriter, req *http.Request) {
-	body, err, status := general.ValidateRequest(req, http.MethodPost, true)
+func (h *Handlers) exportHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		h.log.Warnf("Wrong http export request method: %s", req.Method)
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	a, session := auth.Is(req, mongo.Sessions, h.log)
+	if !a {
+		h.log.Warnf("Non authorized request: %+v", req.RequestURI)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	export, err := h.auth.Export(mongo.Users, mongo.Sessions, mongo.ApiKeys, mongo.Invites, mongo.Units,
+		mongo.Answers, mongo.Lists, mongo.Diary, mongo.History, session)
 	if err != nil {
-		h.log.Warn(err.Error())
-		w.WriteHeader(status)
+		h.log.Warnf("Error during the account export: %s", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"account-%s.json\"", session.Name))
+	h.writeJson(w, export)
+}
 
-	err, errs := h.auth.Validator.Validate(body, auth.AUTH_VALIDATE)
-	if errs != nil {
+// purge removes accounts which deletion grace period is over, it runs until the service stops
+func (h *Handlers) purge() {
+	for range time.Tick(auth.DELETION_PURGE_INTERVAL * time.Second) {
+		n, err := h.auth.PurgeDeleted(mongo.Users, userCollections...)
 		if err != nil {
-			h.log.Warnf("%+v", err.Error())
+			h.log.Warnf("Error while purging deleted accounts: %s", err.Error())
 		}
-		h.log.Warnf("The document is not valid. see errors :\n")
-		h.log.Warnf("%+v", errs)
-		w.WriteHeader(http.StatusBadRequest)
-		w.Header().Set("Content-Type", "application/json")
-		if err := json.NewEncoder(w).Encode(errs); err != nil {
-			h.log.Warn("Error while encoding validation errors: %s", err.Error())
-			w.WriteHeader(http.StatusInternalServerError)
-			w.Header().Set("Content-Type", "text/plain")
+		if n > 0 {
+			h.log.Infof("Purged %d deleted accounts", n)
 		}
-		return
 	}
+}
+
+func (h *Handlers) authHandler(w http.ResponseWriter, req *http.Request) {
+	body, err, status := general.ValidateRequest(req, http.MethodPost, true)
 	if err != nil {
-		h.log.Warnf("%+v", err.Error())
-		w.WriteHeader(http.StatusBadRequest)
+		h.log.Warn(err.Error())
+		w.WriteHeader(status)
+		return
+	}
+
+	if !h.validate(w, body, auth.AUTH_VALIDATE) {
 		return
 	}
 
@@ -151,13 +205,22 @@ func (h *Handlers) authHandler(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	sid, err := h.auth.Auth(mongo.Users, mongo.Sessions, &authObj)
+	sid, err := h.auth.Auth(mongo.Users, mongo.Sessions, &authObj, auth.NewClientInfo(req))
 	if err != nil {
 		h.log.Warnf("Error during the auth process: %s", err.Error())
 		if _, ok := err.(auth.ErrorInvalidLogin); ok {
 			w.WriteHeader(http.StatusBadRequest)
 		} else if _, ok := err.(auth.ErrorInvalidPassword); ok {
 			w.WriteHeader(http.StatusBadRequest)
+		} else if _, ok := err.(auth.ErrorInvalidTotp); ok {
+			w.WriteHeader(http.StatusBadRequest)
+		} else if _, ok := err.(auth.ErrorUserDisabled); ok {
+			w.WriteHeader(http.StatusForbidden)
+		} else if _, ok := err.(auth.ErrorTotpRequired); ok {
+			w.WriteHeader(http.StatusUnauthorized)
+		} else if e, ok := err.(auth.ErrorLoginThrottled); ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(time.Until(e.Until).Seconds()) + 1))
+			w.WriteHeader(http.StatusTooManyRequests)
 		} else {
 			w.WriteHeader(http.StatusInternalServerError)
 		}
@@ -165,33 +228,1265 @@ func (h *Handlers) authHandler(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	http.SetCookie(w, &http.Cookie{
+	h.writeSession(w, sid)
+}
+
+// writeSession returns the new session either as tokens or as the sid cookie depending on the auth mode
+func (h *Handlers) writeSession(w http.ResponseWriter, sid string) {
+	if h.config.AuthMode == auth.AUTH_MODE_TOKEN {
+		h.writeTokens(w, sid)
+		return
+	}
+	session, err := h.auth.FindSession(mongo.Sessions, sid)
+	if err != nil {
+		h.log.Warnf("Error while reading the new session: %s", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	h.config.Cookie.SetCookie(w, &http.Cookie{
 		Name: auth.SidKey,
 		Value: sid,
 		Path: "/",
-		Expires: time.Now().Add(auth.COOKIE_EXPIRES * time.Second),
+		Expires: session.CookieExpires(),
+		HttpOnly: h.config.Cookie.HttpOnly,
+	})
+	h.writeCsrf(w, session.Csrf, session.CookieExpires())
+}
+
+// writeCsrf sends the token in the header and in the cookie readable by scripts.
+// The cookie lives as long as the sid cookie, zero expires makes it a browser session cookie.
+func (h *Handlers) writeCsrf(w http.ResponseWriter, csrf string, expires time.Time) {
+	h.config.Cookie.SetCookie(w, &http.Cookie{
+		Name: auth.CSRF_COOKIE,
+		Value: csrf,
+		Path: "/",
+		Expires: expires,
 	})
+	w.Header().Set(auth.CSRF_HEADER, csrf)
+}
+
+func (h *Handlers) clearCookies(w http.ResponseWriter) {
+	for _, name := range []string{auth.SidKey, auth.CSRF_COOKIE} {
+		h.config.Cookie.SetCookie(w, &http.Cookie{
+			Name: name,
+			Value: "",
+			Path: "/",
+			MaxAge: -1,
+		})
+	}
+}
+
+func (h *Handlers) writeTokens(w http.ResponseWriter, sid string) {
+	tokens, err := h.auth.IssueTokens(mongo.Sessions, sid)
+	if err != nil {
+		h.log.Warnf("Error while issuing tokens: %s", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := json.NewEncoder(w).Encode(tokens); err != nil {
+		h.log.Warnf("Error while encoding tokens: %s", err.Error())
+	}
+}
+
+// exit removes the current session, found either by the sid cookie or by the access token
+func (h *Handlers) exit(req *http.Request) (*auth.Session, error) {
+	a, session := auth.Is(req, mongo.Sessions, h.log)
+	if !a {
+		return nil, auth.ErrorNotAuthorized{}
+	}
+	if err := h.auth.RevokeSession(mongo.Sessions, session, session.Id); err != nil {
+		if _, ok := err.(auth.ErrorSessionNotFound); ok {
+			return nil, auth.ErrorNotAuthorized{}
+		}
+		return nil, err
+	}
+	return session, nil
 }
 
 func (h *Handlers) exitHandler(w http.ResponseWriter, req *http.Request) {
-	cookie, err := req.Cookie(auth.SidKey)
+	session, err := h.exit(req)
+	if session != nil {
+		h.audit(req, auth.AUDIT_LOGOUT, session, err)
+	}
+	if err != nil {
+		h.log.Warnf("Error during the exit process: %s", err.Error())
+		if _, ok := err.(auth.ErrorNotAuthorized); ok {
+			w.WriteHeader(http.StatusBadRequest)
+		} else {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	h.clearCookies(w)
+}
+
+// validate checks the body against the schema. Extra errors, e.g. password policy violations, are reported together
+// with the schema errors.
+func (h *Handlers) validate(w http.ResponseWriter, body []byte, validateLoaderName string, extra ...string) bool {
+	err, errs := h.auth.Validator.Validate(body, validateLoaderName)
+	if len(extra) > 0 {
+		errs = append(errs, extra...)
+	}
+	if errs != nil {
+		if err != nil {
+			h.log.Warnf("%+v", err.Error())
+		}
+		h.log.Warnf("The document is not valid. see errors :\n")
+		h.log.Warnf("%+v", errs)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		if err := json.NewEncoder(w).Encode(errs); err != nil {
+			h.log.Warnf("Error while encoding validation errors: %s", err.Error())
+		}
+		return false
+	}
+	if err != nil {
+		h.log.Warnf("%+v", err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// passwordViolations checks the password field of the body against the policy. Fields of wrong types are
+// reported by the schema validation.
+func (h *Handlers) passwordViolations(body []byte, field, name string) []string {
+	var doc map[string]interface{}
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return nil
+	}
+	password, ok := doc[field].(string)
+	if !ok {
+		return nil
+	}
+	if name == "" {
+		name, _ = doc["name"].(string)
+	}
+	return h.auth.Policy.Check(field, password, name)
+}
+
+func (h *Handlers) sessionsHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		h.log.Warnf("Wrong http sessions request method: %s", req.Method)
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	a, session := auth.Is(req, mongo.Sessions, h.log)
+	if !a {
+		h.log.Warnf("Non authorized request: %+v", req.RequestURI)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	sessions, err := h.auth.Sessions(mongo.Sessions, session)
+	if err != nil {
+		h.log.Warnf("Error getting sessions: %s", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(sessions); err != nil {
+		h.log.Warnf("Error while encoding sessions: %s", err.Error())
+	}
+}
+
+func (h *Handlers) revokeSessionHandler(w http.ResponseWriter, req *http.Request) {
+	a, session := auth.Is(req, mongo.Sessions, h.log)
+	if !a {
+		h.log.Warnf("Non authorized request: %+v", req.RequestURI)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	body, err, status := general.ValidateRequest(req, http.MethodPost, true)
 	if err != nil {
-		h.log.Warnf("Cookie not found in request: %s", req.RequestURI)
+		h.log.Warn(err.Error())
+		w.WriteHeader(status)
+		return
+	}
+	if !h.validate(w, body, auth.SESSION_VALIDATE) {
+		return
+	}
+
+	var sessionId auth.SessionId
+	if err := json.Unmarshal(body, &sessionId); err != nil {
+		h.log.Warnf("Error while unmarshalling json for request %s: %s", req.RequestURI, err.Error())
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 
-	if _, err := h.auth.Exit(mongo.Sessions, cookie); err != nil {
-		h.log.Warnf("Error during the exit process: %s", err.Error())
+	if err := h.auth.RevokeSession(mongo.Sessions, session, sessionId.Id); err != nil {
+		h.log.Warnf("Error during the session revoke: %s", err.Error())
+		if _, ok := err.(auth.ErrorSessionNotFound); ok {
+			w.WriteHeader(http.StatusNotFound)
+		} else {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		w.Write([]byte(err.Error()))
+		return
+	}
+}
+
+func (h *Handlers) revokeOtherSessionsHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		h.log.Warnf("Wrong http revoke others request method: %s", req.Method)
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	a, session := auth.Is(req, mongo.Sessions, h.log)
+	if !a {
+		h.log.Warnf("Non authorized request: %+v", req.RequestURI)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	if err := h.auth.RevokeOtherSessions(mongo.Sessions, session); err != nil {
+		h.log.Warnf("Error during the sessions revoke: %s", err.Error())
 		w.WriteHeader(http.StatusInternalServerError)
 		w.Write([]byte(err.Error()))
 		return
 	}
+}
 
-	http.SetCookie(w, &http.Cookie{
-		Name: auth.SidKey,
-		Value: "",
-		Path: "/",
-		MaxAge: -1,
-	})
-}
\ No newline at end of file
+func (h *Handlers) changePasswordHandler(w http.ResponseWriter, req *http.Request) {
+	a, session := auth.Is(req, mongo.Sessions, h.log)
+	if !a {
+		h.log.Warnf("Non authorized request: %+v", req.RequestURI)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	body, err, status := general.ValidateRequest(req, http.MethodPost, true)
+	if err != nil {
+		h.log.Warn(err.Error())
+		w.WriteHeader(status)
+		return
+	}
+	if !h.validate(w, body, auth.CHANGE_PASSWORD_VALIDATE, h.passwordViolations(body, "new_password", session.Name)...) {
+		return
+	}
+
+	var data auth.ChangePasswordData
+	if err := json.Unmarshal(body, &data); err != nil {
+		h.log.Warnf("Error while unmarshalling json for request %s: %s", req.RequestURI, err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	err = h.auth.ChangePassword(mongo.Users, mongo.Sessions, session, &data)
+	h.audit(req, auth.AUDIT_CHANGE_PASSWORD, session, err)
+	if err != nil {
+		h.log.Warnf("Error during the password change: %s", err.Error())
+		if _, ok := err.(auth.ErrorInvalidPassword); ok {
+			w.WriteHeader(http.StatusBadRequest)
+		} else {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		w.Write([]byte(err.Error()))
+		return
+	}
+}
+
+func (h *Handlers) resetRequestHandler(w http.ResponseWriter, req *http.Request) {
+	body, err, status := general.ValidateRequest(req, http.MethodPost, true)
+	if err != nil {
+		h.log.Warn(err.Error())
+		w.WriteHeader(status)
+		return
+	}
+	if !h.validate(w, body, auth.RESET_REQUEST_VALIDATE) {
+		return
+	}
+
+	var data auth.ResetRequestData
+	if err := json.Unmarshal(body, &data); err != nil {
+		h.log.Warnf("Error while unmarshalling json for request %s: %s", req.RequestURI, err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	token, user, err := h.auth.RequestPasswordReset(mongo.Users, mongo.ResetTokens, &data)
+	if err != nil {
+		h.log.Warnf("Error during the password reset request: %s", err.Error())
+		// Unknown emails are answered the same way as known ones, so accounts can't be enumerated
+		if _, ok := err.(auth.ErrorUsersDoesntExist); !ok {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		return
+	}
+
+	text := fmt.Sprintf("Hello, %s!\n\nYour password reset token: %s\n", user.Name, token)
+	if h.config.ResetLink != "" {
+		text += fmt.Sprintf("Follow the link to set a new password: %s?token=%s\n", h.config.ResetLink, token)
+	}
+	text += fmt.Sprintf("The token expires in %d minutes.\n", auth.RESET_TOKEN_EXPIRES / 60)
+	go func() {
+		if err := h.mailer.Send(user.Email, "Password reset", text); err != nil {
+			h.log.Warnf("Error while sending password reset email to %s: %s", user.Email, err.Error())
+		}
+	}()
+}
+
+func (h *Handlers) resetPasswordHandler(w http.ResponseWriter, req *http.Request) {
+	body, err, status := general.ValidateRequest(req, http.MethodPost, true)
+	if err != nil {
+		h.log.Warn(err.Error())
+		w.WriteHeader(status)
+		return
+	}
+	if !h.validate(w, body, auth.RESET_PASSWORD_VALIDATE, h.passwordViolations(body, auth.PASSWORD_FIELD, "")...) {
+		return
+	}
+
+	var data auth.ResetPasswordData
+	if err := json.Unmarshal(body, &data); err != nil {
+		h.log.Warnf("Error while unmarshalling json for request %s: %s", req.RequestURI, err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	uid, err := h.auth.ResetPassword(mongo.Users, mongo.Sessions, mongo.ResetTokens, &data)
+	h.auth.Audit.Record(auth.AUDIT_RESET_PASSWORD, uid, "", auth.NewClientInfo(req), err)
+	if err != nil {
+		h.log.Warnf("Error during the password reset: %s", err.Error())
+		if e, ok := err.(auth.ErrorPasswordPolicy); ok {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			if err := json.NewEncoder(w).Encode(e.Violations); err != nil {
+				h.log.Warnf("Error while encoding validation errors: %s", err.Error())
+			}
+			return
+		}
+		if _, ok := err.(auth.ErrorInvalidToken); ok {
+			w.WriteHeader(http.StatusBadRequest)
+		} else {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		w.Write([]byte(err.Error()))
+		return
+	}
+}
+
+func (h *Handlers) sendVerification(user *auth.RegData, token string) {
+	text := fmt.Sprintf("Hello, %s!\n\nYour email verification token: %s\n", user.Name, token)
+	if h.config.VerifyLink != "" {
+		text += fmt.Sprintf("Follow the link to verify your email: %s?token=%s\n", h.config.VerifyLink, token)
+	}
+	text += fmt.Sprintf("The token expires in %d hours.\n", auth.VERIFY_TOKEN_EXPIRES / 3600)
+	go func() {
+		if err := h.mailer.Send(user.Email, "Email verification", text); err != nil {
+			h.log.Warnf("Error while sending verification email to %s: %s", user.Email, err.Error())
+		}
+	}()
+}
+
+func (h *Handlers) verifyEmailHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		h.log.Warnf("Wrong http verify email request method: %s", req.Method)
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	uid, err := h.auth.VerifyEmail(mongo.Users, mongo.Sessions, mongo.VerifyTokens, req.FormValue("token"))
+	h.auth.Audit.Record(auth.AUDIT_VERIFY_EMAIL, uid, "", auth.NewClientInfo(req), err)
+	if err != nil {
+		h.log.Warnf("Error during the email verification: %s", err.Error())
+		if _, ok := err.(auth.ErrorInvalidToken); ok {
+			w.WriteHeader(http.StatusBadRequest)
+		} else {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		w.Write([]byte(err.Error()))
+		return
+	}
+}
+
+func (h *Handlers) resendVerificationHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		h.log.Warnf("Wrong http resend verification request method: %s", req.Method)
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	a, session := auth.Is(req, mongo.Sessions, h.log)
+	if !a {
+		h.log.Warnf("Non authorized request: %+v", req.RequestURI)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	token, user, err := h.auth.ResendVerification(mongo.Users, mongo.VerifyTokens, session)
+	if err != nil {
+		h.log.Warnf("Error during the verification resend: %s", err.Error())
+		switch err.(type) {
+		case auth.ErrorAlreadyVerified:
+			w.WriteHeader(http.StatusBadRequest)
+		case auth.ErrorTooManyRequests:
+			w.WriteHeader(http.StatusTooManyRequests)
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		w.Write([]byte(err.Error()))
+		return
+	}
+	h.sendVerification(user, token)
+}
+
+func (h *Handlers) refreshHandler(w http.ResponseWriter, req *http.Request) {
+	body, err, status := general.ValidateRequest(req, http.MethodPost, true)
+	if err != nil {
+		h.log.Warn(err.Error())
+		w.WriteHeader(status)
+		return
+	}
+	if !h.validate(w, body, auth.REFRESH_VALIDATE) {
+		return
+	}
+
+	var data auth.RefreshData
+	if err := json.Unmarshal(body, &data); err != nil {
+		h.log.Warnf("Error while unmarshalling json for request %s: %s", req.RequestURI, err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	tokens, err := h.auth.RefreshTokens(mongo.Sessions, data.RefreshToken)
+	if err != nil {
+		h.log.Warnf("Error during the tokens refresh: %s", err.Error())
+		if _, ok := err.(auth.ErrorInvalidToken); ok {
+			w.WriteHeader(http.StatusUnauthorized)
+		} else {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := json.NewEncoder(w).Encode(tokens); err != nil {
+		h.log.Warnf("Error while encoding tokens: %s", err.Error())
+	}
+}
+
+func (h *Handlers) introspectHandler(w http.ResponseWriter, req *http.Request) {
+	// Only services may check credentials, answers contain user data and csrf tokens
+	if !auth.CheckServiceSecret(req, h.config.ServiceSecret) {
+		h.log.Warnf("Introspection request without the service secret from %s", req.RemoteAddr)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	body, err, status := general.ValidateRequest(req, http.MethodPost, true)
+	if err != nil {
+		h.log.Warn(err.Error())
+		w.WriteHeader(status)
+		return
+	}
+	if !h.validate(w, body, auth.INTROSPECT_VALIDATE) {
+		return
+	}
+
+	var data auth.IntrospectData
+	if err := json.Unmarshal(body, &data); err != nil {
+		h.log.Warnf("Error while unmarshalling json for request %s: %s", req.RequestURI, err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	introspection, err := h.auth.Introspect(mongo.Users, mongo.Sessions, mongo.ApiKeys, data.Token)
+	if err != nil {
+		h.log.Warnf("Error during the introspection: %s", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(introspection); err != nil {
+		h.log.Warnf("Error while encoding introspection: %s", err.Error())
+	}
+}
+
+func (h *Handlers) userStatusHandler(w http.ResponseWriter, req *http.Request) {
+	if !auth.CheckServiceSecret(req, h.config.ServiceSecret) {
+		h.log.Warnf("User status request without the service secret from %s", req.RemoteAddr)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	body, err, status := general.ValidateRequest(req, http.MethodPost, true)
+	if err != nil {
+		h.log.Warn(err.Error())
+		w.WriteHeader(status)
+		return
+	}
+	if !h.validate(w, body, auth.USER_ID_VALIDATE) {
+		return
+	}
+
+	var data auth.UserId
+	if err := json.Unmarshal(body, &data); err != nil {
+		h.log.Warnf("Error while unmarshalling json for request %s: %s", req.RequestURI, err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	userStatus, err := h.auth.UserStatus(mongo.Users, data.Id)
+	if err != nil {
+		h.log.Warnf("Error getting status of user %s: %s", data.Id.Hex(), err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	h.writeJson(w, userStatus)
+}
+
+func (h *Handlers) requireAdmin(w http.ResponseWriter, req *http.Request) (*auth.Session, bool) {
+	a, session := auth.Is(req, mongo.Sessions, h.log)
+	if !a {
+		h.log.Warnf("Non authorized request: %+v", req.RequestURI)
+		w.WriteHeader(http.StatusUnauthorized)
+		return nil, false
+	}
+	admin, err := h.auth.IsAdmin(mongo.Users, session)
+	if err != nil {
+		h.log.Warnf("Error while checking admin role: %s", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return nil, false
+	}
+	if !admin {
+		h.log.Warnf("Non admin request: %+v from user: %s", req.RequestURI, session.Uid.Hex())
+		w.WriteHeader(http.StatusForbidden)
+		return nil, false
+	}
+	return session, true
+}
+
+func (h *Handlers) unlockHandler(w http.ResponseWriter, req *http.Request) {
+	session, ok := h.requireAdmin(w, req)
+	if !ok {
+		return
+	}
+	body, err, status := general.ValidateRequest(req, http.MethodPost, true)
+	if err != nil {
+		h.log.Warn(err.Error())
+		w.WriteHeader(status)
+		return
+	}
+	if !h.validate(w, body, auth.UNLOCK_VALIDATE) {
+		return
+	}
+
+	var data auth.UnlockData
+	if err := json.Unmarshal(body, &data); err != nil {
+		h.log.Warnf("Error while unmarshalling json for request %s: %s", req.RequestURI, err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	err = h.auth.Throttle.Unlock(&data, session.Uid)
+	h.auth.Audit.RecordAdmin(auth.AUDIT_ADMIN_UNLOCK, session, "", auth.NewClientInfo(req), err)
+	if err != nil {
+		h.log.Warnf("Error during the unlock: %s", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+}
+
+func (h *Handlers) totpErrorStatus(err error) int {
+	switch err.(type) {
+	case auth.ErrorInvalidTotp, auth.ErrorInvalidPassword, auth.ErrorTotpEnabled, auth.ErrorTotpNotEnabled:
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// audit records the event of the current user
+func (h *Handlers) audit(req *http.Request, eventType string, session *auth.Session, err error) {
+	h.auth.Audit.Record(eventType, session.Uid, session.Name, auth.NewClientInfo(req), err)
+}
+
+func (h *Handlers) writeJson(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		h.log.Warnf("Error while encoding response: %s", err.Error())
+	}
+}
+
+func (h *Handlers) totpEnrollHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		h.log.Warnf("Wrong http totp enroll request method: %s", req.Method)
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	a, session := auth.Is(req, mongo.Sessions, h.log)
+	if !a {
+		h.log.Warnf("Non authorized request: %+v", req.RequestURI)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	enrollment, err := h.auth.EnrollTotp(mongo.Users, session)
+	if err != nil {
+		h.log.Warnf("Error during the totp enrollment: %s", err.Error())
+		w.WriteHeader(h.totpErrorStatus(err))
+		w.Write([]byte(err.Error()))
+		return
+	}
+	h.writeJson(w, enrollment)
+}
+
+func (h *Handlers) totpConfirmHandler(w http.ResponseWriter, req *http.Request) {
+	a, session := auth.Is(req, mongo.Sessions, h.log)
+	if !a {
+		h.log.Warnf("Non authorized request: %+v", req.RequestURI)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	body, err, status := general.ValidateRequest(req, http.MethodPost, true)
+	if err != nil {
+		h.log.Warn(err.Error())
+		w.WriteHeader(status)
+		return
+	}
+	if !h.validate(w, body, auth.TOTP_CODE_VALIDATE) {
+		return
+	}
+
+	var data auth.TotpCodeData
+	if err := json.Unmarshal(body, &data); err != nil {
+		h.log.Warnf("Error while unmarshalling json for request %s: %s", req.RequestURI, err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	codes, err := h.auth.ConfirmTotp(mongo.Users, session, &data)
+	h.audit(req, auth.AUDIT_TOTP_ENABLE, session, err)
+	if err != nil {
+		h.log.Warnf("Error during the totp confirmation: %s", err.Error())
+		w.WriteHeader(h.totpErrorStatus(err))
+		w.Write([]byte(err.Error()))
+		return
+	}
+	h.writeJson(w, codes)
+}
+
+func (h *Handlers) totpDisableHandler(w http.ResponseWriter, req *http.Request) {
+	a, session := auth.Is(req, mongo.Sessions, h.log)
+	if !a {
+		h.log.Warnf("Non authorized request: %+v", req.RequestURI)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	body, err, status := general.ValidateRequest(req, http.MethodPost, true)
+	if err != nil {
+		h.log.Warn(err.Error())
+		w.WriteHeader(status)
+		return
+	}
+	if !h.validate(w, body, auth.TOTP_DISABLE_VALIDATE) {
+		return
+	}
+
+	var data auth.TotpDisableData
+	if err := json.Unmarshal(body, &data); err != nil {
+		h.log.Warnf("Error while unmarshalling json for request %s: %s", req.RequestURI, err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	err = h.auth.DisableTotp(mongo.Users, session, &data)
+	h.audit(req, auth.AUDIT_TOTP_DISABLE, session, err)
+	if err != nil {
+		h.log.Warnf("Error during the totp disabling: %s", err.Error())
+		w.WriteHeader(h.totpErrorStatus(err))
+		w.Write([]byte(err.Error()))
+		return
+	}
+}
+
+func (h *Handlers) recoveryCodesHandler(w http.ResponseWriter, req *http.Request) {
+	a, session := auth.Is(req, mongo.Sessions, h.log)
+	if !a {
+		h.log.Warnf("Non authorized request: %+v", req.RequestURI)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	body, err, status := general.ValidateRequest(req, http.MethodPost, true)
+	if err != nil {
+		h.log.Warn(err.Error())
+		w.WriteHeader(status)
+		return
+	}
+	if !h.validate(w, body, auth.TOTP_CODE_VALIDATE) {
+		return
+	}
+
+	var data auth.TotpCodeData
+	if err := json.Unmarshal(body, &data); err != nil {
+		h.log.Warnf("Error while unmarshalling json for request %s: %s", req.RequestURI, err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	codes, err := h.auth.RegenerateRecoveryCodes(mongo.Users, session, &data)
+	if err != nil {
+		h.log.Warnf("Error during the recovery codes regeneration: %s", err.Error())
+		w.WriteHeader(h.totpErrorStatus(err))
+		w.Write([]byte(err.Error()))
+		return
+	}
+	h.writeJson(w, codes)
+}
+
+func (h *Handlers) adminUsersHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		h.log.Warnf("Wrong http admin users request method: %s", req.Method)
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if _, ok := h.requireAdmin(w, req); !ok {
+		return
+	}
+
+	skip, _ := strconv.Atoi(req.FormValue("skip"))
+	limit, _ := strconv.Atoi(req.FormValue("limit"))
+	users, err := h.auth.Users(mongo.Users, req.FormValue("q"), skip, limit)
+	if err != nil {
+		h.log.Warnf("Error getting users: %s", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	h.writeJson(w, users)
+}
+
+// adminUserAction reads user id from the request and applies the action to the user
+func (h *Handlers) adminUserAction(w http.ResponseWriter, req *http.Request, eventType string, action func(uid bson.ObjectId) error) {
+	session, ok := h.requireAdmin(w, req)
+	if !ok {
+		return
+	}
+	body, err, status := general.ValidateRequest(req, http.MethodPost, true)
+	if err != nil {
+		h.log.Warn(err.Error())
+		w.WriteHeader(status)
+		return
+	}
+	if !h.validate(w, body, auth.USER_ID_VALIDATE) {
+		return
+	}
+
+	var data auth.UserId
+	if err := json.Unmarshal(body, &data); err != nil {
+		h.log.Warnf("Error while unmarshalling json for request %s: %s", req.RequestURI, err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	err = action(data.Id)
+	h.auth.Audit.RecordAdmin(eventType, session, data.Id, auth.NewClientInfo(req), err)
+	if err != nil {
+		h.log.Warnf("Error during the admin action %s on user %s: %s", req.RequestURI, data.Id.Hex(), err.Error())
+		if _, ok := err.(auth.ErrorUsersDoesntExist); ok {
+			w.WriteHeader(http.StatusNotFound)
+		} else {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		w.Write([]byte(err.Error()))
+		return
+	}
+	h.log.Infof("Admin %s applied %s to user %s", session.Uid.Hex(), req.RequestURI, data.Id.Hex())
+}
+
+func (h *Handlers) adminDisableHandler(w http.ResponseWriter, req *http.Request) {
+	h.adminUserAction(w, req, auth.AUDIT_ADMIN_DISABLE, func(uid bson.ObjectId) error {
+		return h.auth.SetDisabled(mongo.Users, mongo.Sessions, uid, true)
+	})
+}
+
+func (h *Handlers) adminEnableHandler(w http.ResponseWriter, req *http.Request) {
+	h.adminUserAction(w, req, auth.AUDIT_ADMIN_ENABLE, func(uid bson.ObjectId) error {
+		return h.auth.SetDisabled(mongo.Users, mongo.Sessions, uid, false)
+	})
+}
+
+func (h *Handlers) adminLogoutHandler(w http.ResponseWriter, req *http.Request) {
+	h.adminUserAction(w, req, auth.AUDIT_ADMIN_LOGOUT, func(uid bson.ObjectId) error {
+		return h.auth.Logout(mongo.Sessions, uid)
+	})
+}
+
+func (h *Handlers) adminDeleteHandler(w http.ResponseWriter, req *http.Request) {
+	h.adminUserAction(w, req, auth.AUDIT_ADMIN_DELETE, func(uid bson.ObjectId) error {
+		return h.auth.DeleteUser(mongo.Users, uid, userCollections...)
+	})
+}
+
+func (h *Handlers) apiKeysHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		h.log.Warnf("Wrong http api keys request method: %s", req.Method)
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	a, session := auth.Is(req, mongo.Sessions, h.log)
+	if !a {
+		h.log.Warnf("Non authorized request: %+v", req.RequestURI)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	keys, err := h.auth.ApiKeys(mongo.ApiKeys, session)
+	if err != nil {
+		h.log.Warnf("Error getting api keys: %s", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	h.writeJson(w, keys)
+}
+
+func (h *Handlers) createApiKeyHandler(w http.ResponseWriter, req *http.Request) {
+	a, session := auth.Is(req, mongo.Sessions, h.log)
+	if !a {
+		h.log.Warnf("Non authorized request: %+v", req.RequestURI)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	body, err, status := general.ValidateRequest(req, http.MethodPost, true)
+	if err != nil {
+		h.log.Warn(err.Error())
+		w.WriteHeader(status)
+		return
+	}
+	if !h.validate(w, body, auth.API_KEY_VALIDATE) {
+		return
+	}
+
+	var data auth.ApiKeyData
+	if err := json.Unmarshal(body, &data); err != nil {
+		h.log.Warnf("Error while unmarshalling json for request %s: %s", req.RequestURI, err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	key, err := h.auth.CreateApiKey(mongo.ApiKeys, session, &data)
+	h.audit(req, auth.AUDIT_API_KEY_CREATE, session, err)
+	if err != nil {
+		h.log.Warnf("Error during the api key creation: %s", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	h.writeJson(w, key)
+}
+
+func (h *Handlers) revokeApiKeyHandler(w http.ResponseWriter, req *http.Request) {
+	a, session := auth.Is(req, mongo.Sessions, h.log)
+	if !a {
+		h.log.Warnf("Non authorized request: %+v", req.RequestURI)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	body, err, status := general.ValidateRequest(req, http.MethodPost, true)
+	if err != nil {
+		h.log.Warn(err.Error())
+		w.WriteHeader(status)
+		return
+	}
+	if !h.validate(w, body, auth.API_KEY_ID_VALIDATE) {
+		return
+	}
+
+	var data auth.ApiKeyId
+	if err := json.Unmarshal(body, &data); err != nil {
+		h.log.Warnf("Error while unmarshalling json for request %s: %s", req.RequestURI, err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	err = h.auth.RevokeApiKey(mongo.ApiKeys, session, data.Id)
+	h.audit(req, auth.AUDIT_API_KEY_REVOKE, session, err)
+	if err != nil {
+		h.log.Warnf("Error during the api key revoke: %s", err.Error())
+		if _, ok := err.(auth.ErrorApiKeyNotFound); ok {
+			w.WriteHeader(http.StatusNotFound)
+		} else {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		w.Write([]byte(err.Error()))
+		return
+	}
+}
+func (h *Handlers) oidcLoginHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		h.log.Warnf("Wrong http oidc login request method: %s", req.Method)
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if h.oidc == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	// Logged in user links the identity to the current account
+	var current *auth.Session
+	if a, session := auth.Is(req, mongo.Sessions, h.log); a {
+		current = session
+	}
+
+	location, binding, err := h.oidc.AuthUrl(mongo.OidcStates, req.FormValue("provider"), current,
+		req.FormValue("remember") == "true")
+	if err != nil {
+		h.log.Warnf("Error during the oidc login: %s", err.Error())
+		if _, ok := err.(auth.ErrorUnknownProvider); ok {
+			w.WriteHeader(http.StatusBadRequest)
+		} else {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+		w.Write([]byte(err.Error()))
+		return
+	}
+	// Strict cookies aren't sent on the redirect back from the provider
+	cookie := h.config.Cookie
+	if cookie.SameSite == auth.SAME_SITE_STRICT {
+		cookie.SameSite = auth.SAME_SITE_LAX
+	}
+	cookie.SetCookie(w, &http.Cookie{
+		Name: auth.OIDC_BINDING_COOKIE,
+		Value: binding,
+		Path: "/",
+		MaxAge: auth.OIDC_STATE_EXPIRES,
+		HttpOnly: true,
+	})
+	http.Redirect(w, req, location, http.StatusFound)
+}
+
+func (h *Handlers) oidcCallbackHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		h.log.Warnf("Wrong http oidc callback request method: %s", req.Method)
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if h.oidc == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if e := req.FormValue("error"); e != "" {
+		h.log.Warnf("Identity provider returned error: %s %s", e, req.FormValue("error_description"))
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(e))
+		return
+	}
+	state, code := req.FormValue("state"), req.FormValue("code")
+	if state == "" || code == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	binding := ""
+	if c, err := req.Cookie(auth.OIDC_BINDING_COOKIE); err == nil {
+		binding = c.Value
+	}
+	h.config.Cookie.SetCookie(w, &http.Cookie{
+		Name: auth.OIDC_BINDING_COOKIE,
+		Value: "",
+		Path: "/",
+		MaxAge: -1,
+	})
+
+	sid, err := h.oidc.Callback(h.auth, mongo.Users, mongo.Sessions, mongo.OidcStates, state, binding, code,
+		auth.NewClientInfo(req))
+	if err != nil {
+		h.log.Warnf("Error during the oidc callback: %s", err.Error())
+		switch err.(type) {
+		case auth.ErrorInvalidToken, auth.ErrorUnknownProvider, auth.ErrorEmailRequired:
+			w.WriteHeader(http.StatusBadRequest)
+		case auth.ErrorUserExists, auth.ErrorIdentityLinked:
+			w.WriteHeader(http.StatusConflict)
+		case auth.ErrorUserDisabled, auth.ErrorRegistrationClosed:
+			w.WriteHeader(http.StatusForbidden)
+		case auth.ErrorUsersDoesntExist:
+			w.WriteHeader(http.StatusUnauthorized)
+		default:
+			w.WriteHeader(http.StatusBadGateway)
+		}
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	h.writeSession(w, sid)
+}
+
+func (h *Handlers) profileHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet && req.Method != http.MethodPut {
+		h.log.Warnf("Wrong http profile request method: %s", req.Method)
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	a, session := auth.Is(req, mongo.Sessions, h.log)
+	if !a {
+		h.log.Warnf("Non authorized request: %+v", req.RequestURI)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	if req.Method == http.MethodPut {
+		body, err, status := general.ValidateRequest(req, http.MethodPut, true)
+		if err != nil {
+			h.log.Warn(err.Error())
+			w.WriteHeader(status)
+			return
+		}
+		if !h.validate(w, body, auth.PROFILE_VALIDATE) {
+			return
+		}
+
+		var data auth.ProfileData
+		if err := json.Unmarshal(body, &data); err != nil {
+			h.log.Warnf("Error while unmarshalling json for request %s: %s", req.RequestURI, err.Error())
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		user, emailChanged, err := h.auth.UpdateProfile(mongo.Users, mongo.Sessions, mongo.VerifyTokens, session, &data)
+		h.audit(req, auth.AUDIT_PROFILE_UPDATE, session, err)
+		if err != nil {
+			h.log.Warnf("Error during the profile update: %s", err.Error())
+			if e, ok := err.(auth.ErrorConflict); ok {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusConflict)
+				if err := json.NewEncoder(w).Encode(e); err != nil {
+					h.log.Warnf("Error while encoding conflict error: %s", err.Error())
+				}
+				return
+			}
+			if _, ok := err.(auth.ErrorUserExists); ok {
+				w.WriteHeader(http.StatusConflict)
+			} else if _, ok := err.(auth.ErrorUsersDoesntExist); ok {
+				w.WriteHeader(http.StatusUnauthorized)
+			} else {
+				w.WriteHeader(http.StatusInternalServerError)
+			}
+			w.Write([]byte(err.Error()))
+			return
+		}
+		if emailChanged {
+			token, err := h.auth.NewVerifyToken(mongo.VerifyTokens, user)
+			if err != nil {
+				h.log.Warnf("Error while creating verification token for %s: %s", user.Name, err.Error())
+			} else {
+				h.sendVerification(user, token)
+			}
+		}
+	}
+
+	profile, err := h.auth.Profile(mongo.Users, session)
+	if err != nil {
+		h.log.Warnf("Error getting profile: %s", err.Error())
+		if _, ok := err.(auth.ErrorUsersDoesntExist); ok {
+			w.WriteHeader(http.StatusUnauthorized)
+		} else {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		return
+	}
+	h.writeJson(w, profile)
+}
+
+func (h *Handlers) csrfHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		h.log.Warnf("Wrong http csrf request method: %s", req.Method)
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	a, session := auth.Is(req, mongo.Sessions, h.log)
+	if !a {
+		h.log.Warnf("Non authorized request: %+v", req.RequestURI)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	csrf, err := h.auth.CsrfToken(mongo.Sessions, session)
+	if err != nil {
+		h.log.Warnf("Error getting csrf token: %s", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	h.writeCsrf(w, csrf, session.CookieExpires())
+	h.writeJson(w, auth.CsrfToken{Token: csrf})
+}
+
+func (h *Handlers) adminAuditHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		h.log.Warnf("Wrong http admin audit request method: %s", req.Method)
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if _, ok := h.requireAdmin(w, req); !ok {
+		return
+	}
+
+	filter := auth.AuditFilter{Type: req.FormValue("type")}
+	if uid := req.FormValue("uid"); uid != "" {
+		if !bson.IsObjectIdHex(uid) {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Invalid uid"))
+			return
+		}
+		filter.Uid = bson.ObjectIdHex(uid)
+	}
+	for _, p := range []struct {
+		name string
+		t *time.Time
+	}{{"from", &filter.From}, {"to", &filter.To}} {
+		if v := req.FormValue(p.name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(fmt.Sprintf("Invalid %s, RFC 3339 time is expected", p.name)))
+				return
+			}
+			*p.t = t
+		}
+	}
+
+	skip, _ := strconv.Atoi(req.FormValue("skip"))
+	limit, _ := strconv.Atoi(req.FormValue("limit"))
+	events, err := h.auth.Audit.Events(&filter, skip, limit)
+	if err != nil {
+		h.log.Warnf("Error getting audit events: %s", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	h.writeJson(w, events)
+}
+
+func (h *Handlers) invitesHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		h.log.Warnf("Wrong http invites request method: %s", req.Method)
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	a, session := auth.Is(req, mongo.Sessions, h.log)
+	if !a {
+		h.log.Warnf("Non authorized request: %+v", req.RequestURI)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	all := false
+	if req.FormValue("all") == "true" {
+		admin, err := h.auth.IsAdmin(mongo.Users, session)
+		if err != nil {
+			h.log.Warnf("Error while checking admin role: %s", err.Error())
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if !admin {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		all = true
+	}
+	invites, err := h.auth.Invites(mongo.Invites, session, all)
+	if err != nil {
+		h.log.Warnf("Error getting invites: %s", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	h.writeJson(w, invites)
+}
+
+func (h *Handlers) createInviteHandler(w http.ResponseWriter, req *http.Request) {
+	a, session := auth.Is(req, mongo.Sessions, h.log)
+	if !a {
+		h.log.Warnf("Non authorized request: %+v", req.RequestURI)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if session.Unverified {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(auth.ErrorEmailNotVerified{}.Error()))
+		return
+	}
+	body, err, status := general.ValidateRequest(req, http.MethodPost, true)
+	if err != nil {
+		h.log.Warn(err.Error())
+		w.WriteHeader(status)
+		return
+	}
+	if !h.validate(w, body, auth.INVITE_VALIDATE) {
+		return
+	}
+
+	var data auth.InviteData
+	if err := json.Unmarshal(body, &data); err != nil {
+		h.log.Warnf("Error while unmarshalling json for request %s: %s", req.RequestURI, err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	admin, err := h.auth.IsAdmin(mongo.Users, session)
+	if err != nil {
+		h.log.Warnf("Error while checking admin role: %s", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	invite, err := h.auth.CreateInvite(mongo.Invites, session, admin, &data)
+	h.audit(req, auth.AUDIT_INVITE_CREATE, session, err)
+	if err != nil {
+		h.log.Warnf("Error during the invite creation: %s", err.Error())
+		if _, ok := err.(auth.ErrorTooManyRequests); ok {
+			w.WriteHeader(http.StatusTooManyRequests)
+		} else {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		w.Write([]byte(err.Error()))
+		return
+	}
+	h.writeJson(w, invite)
+}
+
+func (h *Handlers) revokeInviteHandler(w http.ResponseWriter, req *http.Request) {
+	a, session := auth.Is(req, mongo.Sessions, h.log)
+	if !a {
+		h.log.Warnf("Non authorized request: %+v", req.RequestURI)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	body, err, status := general.ValidateRequest(req, http.MethodPost, true)
+	if err != nil {
+		h.log.Warn(err.Error())
+		w.WriteHeader(status)
+		return
+	}
+	if !h.validate(w, body, auth.INVITE_ID_VALIDATE) {
+		return
+	}
+
+	var data auth.InviteId
+	if err := json.Unmarshal(body, &data); err != nil {
+		h.log.Warnf("Error while unmarshalling json for request %s: %s", req.RequestURI, err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	admin, err := h.auth.IsAdmin(mongo.Users, session)
+	if err != nil {
+		h.log.Warnf("Error while checking admin role: %s", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	err = h.auth.RevokeInvite(mongo.Invites, session, admin, data.Id)
+	h.audit(req, auth.AUDIT_INVITE_REVOKE, session, err)
+	if err != nil {
+		h.log.Warnf("Error during the invite revoke: %s", err.Error())
+		if _, ok := err.(auth.ErrorInviteNotFound); ok {
+			w.WriteHeader(http.StatusNotFound)
+		} else {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		w.Write([]byte(err.Error()))
+		return
+	}
+}
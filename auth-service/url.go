@@ -7,6 +7,49 @@ const (
 	REG   = "register"
 	UNREG = "unregister"
 	EXIT  = "exit"
+
+	SESSIONS      = "sessions"
+	REVOKE        = "revoke"
+	REVOKE_OTHERS = "revoke-others"
+
+	CHANGE_PASSWORD = "change-password"
+	RESET_REQUEST   = "forgot-password"
+	RESET_PASSWORD  = "reset-password"
+
+	VERIFY_EMAIL = "verify-email"
+	RESEND       = "resend"
+
+	TOKEN   = "token"
+	REFRESH = "refresh"
+
+	ADMIN  = "admin"
+	UNLOCK = "unlock"
+	USERS  = "users"
+	ADMIN_DISABLE = "disable"
+	ADMIN_ENABLE  = "enable"
+	ADMIN_LOGOUT  = "logout"
+	ADMIN_DELETE  = "delete"
+	AUDIT         = "audit"
+
+	TOTP           = "2fa"
+	ENROLL         = "enroll"
+	CONFIRM        = "confirm"
+	DISABLE        = "disable"
+	RECOVERY_CODES = "recovery-codes"
+
+	API_KEYS = "api-keys"
+	CREATE   = "create"
+
+	OIDC     = "oidc"
+	LOGIN    = "login"
+	CALLBACK = "callback"
+
+	PROFILE = "profile"
+	EXPORT  = "export"
+
+	CSRF = "csrf"
+
+	INVITES = "invites"
 )
 
 func authUrl() string {
@@ -23,4 +66,140 @@ func regUrl() string {
 
 func unregUrl() string {
 	return general.BASE_URL_V1 + UNREG
+}
+
+func sessionsUrl() string {
+	return general.BASE_URL_V1 + SESSIONS
+}
+
+func revokeSessionUrl() string {
+	return sessionsUrl() + "/" + REVOKE
+}
+
+func revokeOtherSessionsUrl() string {
+	return sessionsUrl() + "/" + REVOKE_OTHERS
+}
+
+func changePasswordUrl() string {
+	return general.BASE_URL_V1 + CHANGE_PASSWORD
+}
+
+func resetRequestUrl() string {
+	return general.BASE_URL_V1 + RESET_REQUEST
+}
+
+func resetPasswordUrl() string {
+	return general.BASE_URL_V1 + RESET_PASSWORD
+}
+
+func verifyEmailUrl() string {
+	return general.BASE_URL_V1 + VERIFY_EMAIL
+}
+
+func resendVerificationUrl() string {
+	return verifyEmailUrl() + "/" + RESEND
+}
+
+func refreshTokenUrl() string {
+	return general.BASE_URL_V1 + TOKEN + "/" + REFRESH
+}
+
+func adminUrl() string {
+	return general.BASE_URL_V1 + ADMIN
+}
+
+func unlockUrl() string {
+	return adminUrl() + "/" + UNLOCK
+}
+
+func totpUrl() string {
+	return general.BASE_URL_V1 + TOTP
+}
+
+func totpEnrollUrl() string {
+	return totpUrl() + "/" + ENROLL
+}
+
+func totpConfirmUrl() string {
+	return totpUrl() + "/" + CONFIRM
+}
+
+func totpDisableUrl() string {
+	return totpUrl() + "/" + DISABLE
+}
+
+func recoveryCodesUrl() string {
+	return totpUrl() + "/" + RECOVERY_CODES
+}
+
+func adminUsersUrl() string {
+	return adminUrl() + "/" + USERS
+}
+
+func adminDisableUrl() string {
+	return adminUsersUrl() + "/" + ADMIN_DISABLE
+}
+
+func adminEnableUrl() string {
+	return adminUsersUrl() + "/" + ADMIN_ENABLE
+}
+
+func adminLogoutUrl() string {
+	return adminUsersUrl() + "/" + ADMIN_LOGOUT
+}
+
+func adminDeleteUrl() string {
+	return adminUsersUrl() + "/" + ADMIN_DELETE
+}
+
+func apiKeysUrl() string {
+	return general.BASE_URL_V1 + API_KEYS
+}
+
+func createApiKeyUrl() string {
+	return apiKeysUrl() + "/" + CREATE
+}
+
+func revokeApiKeyUrl() string {
+	return apiKeysUrl() + "/" + REVOKE
+}
+
+func oidcUrl() string {
+	return general.BASE_URL_V1 + OIDC
+}
+
+func oidcLoginUrl() string {
+	return oidcUrl() + "/" + LOGIN
+}
+
+func oidcCallbackUrl() string {
+	return oidcUrl() + "/" + CALLBACK
+}
+
+func profileUrl() string {
+	return general.BASE_URL_V1 + PROFILE
+}
+
+func exportUrl() string {
+	return profileUrl() + "/" + EXPORT
+}
+
+func csrfUrl() string {
+	return general.BASE_URL_V1 + CSRF
+}
+
+func adminAuditUrl() string {
+	return adminUrl() + "/" + AUDIT
+}
+
+func invitesUrl() string {
+	return general.BASE_URL_V1 + INVITES
+}
+
+func createInviteUrl() string {
+	return invitesUrl() + "/" + CREATE
+}
+
+func revokeInviteUrl() string {
+	return invitesUrl() + "/" + REVOKE
 }
\ No newline at end of file
@@ -0,0 +1,161 @@
+package auth
+
+import (
+	"errors"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	DELETION_GRACE_DAYS = 14
+	// Interval between runs of the purge of accounts which grace period is over (seconds)
+	DELETION_PURGE_INTERVAL = 3600
+)
+
+// Export returns everything stored about the current user. Sids and hashes of keys are secrets and never exported.
+func (a *Auth) Export(users IAuthDataSource, sessions IAuthDataSource, apiKeys IAuthDataSource,
+	invites IAuthDataSource, units IAuthDataSource, answers IAuthDataSource, lists IAuthDataSource,
+	diary IAuthDataSource, history IAuthDataSource, current *Session) (*AccountExport, error) {
+	user, err := a.findUser(users, current.Uid)
+	if err != nil {
+		return nil, err
+	}
+	profile, err := a.Profile(users, current)
+	if err != nil {
+		return nil, err
+	}
+	list, err := a.Sessions(sessions, current)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := a.ApiKeys(apiKeys, current)
+	if err != nil {
+		return nil, err
+	}
+	created, err := a.Invites(invites, current, false)
+	if err != nil {
+		return nil, err
+	}
+	events, err := a.Audit.UserEvents(current.Uid)
+	if err != nil {
+		return nil, err
+	}
+	identities := user.Identities
+	if identities == nil {
+		identities = make([]Identity, 0)
+	}
+	export := &AccountExport{
+		Exported: time.Now(),
+		Profile: profile,
+		Role: roleOf(user),
+		Totp: user.Totp != "",
+		Identities: identities,
+		Sessions: list,
+		ApiKeys: keys,
+		Invites: created,
+		AuditEvents: events,
+		Units: make([]bson.M, 0),
+		Answers: make([]bson.M, 0),
+		Lists: make([]bson.M, 0),
+		Diary: make([]bson.M, 0),
+		History: make([]bson.M, 0),
+	}
+	if err := units.FindAll(bson.M{"uid": current.Uid}, &export.Units); err != nil {
+		return nil, err
+	}
+	if err := answers.FindAll(bson.M{"uid": current.Uid}, &export.Answers); err != nil {
+		return nil, err
+	}
+	if err := lists.FindAll(bson.M{"uid": current.Uid}, &export.Lists); err != nil {
+		return nil, err
+	}
+	if err := diary.FindAll(bson.M{"uid": current.Uid}, &export.Diary); err != nil {
+		return nil, err
+	}
+	if err := history.FindAll(bson.M{"uid": current.Uid}, &export.History); err != nil {
+		return nil, err
+	}
+	for _, docs := range [][]bson.M{export.Units, export.Answers} {
+		for _, d := range docs {
+			delete(d, SidKey)
+		}
+	}
+	return export, nil
+}
+
+// Unregister schedules deletion of the user after the grace period and removes all its sessions.
+// Login during the grace period cancels the deletion.
+func (a *Auth) Unregister(users IAuthDataSource, sessions IAuthDataSource, query interface{}, grace time.Duration) (time.Time, error) {
+	switch o := query.(type) {
+	case *Session:
+		deleteAt := time.Now().Add(grace)
+		if err := users.Update(bson.M{"_id": o.Uid}, bson.M{"$set": bson.M{"deleteat": deleteAt}}); err != nil {
+			if err.Error() == "not found" {
+				return time.Time{}, ErrorUsersDoesntExist{}
+			}
+			return time.Time{}, err
+		}
+		return deleteAt, a.Logout(sessions, o.Uid)
+	default:
+		return time.Time{}, errors.New("Invalid data")
+	}
+}
+
+// cancelDeletion keeps the account unless the purge has already claimed it
+func (a *Auth) cancelDeletion(users IAuthDataSource, user *RegData) error {
+	if user.Purging {
+		return ErrorUsersDoesntExist{}
+	}
+	if user.DeleteAt.IsZero() {
+		return nil
+	}
+	if err := users.Update(bson.M{"_id": user.Id, "purging": bson.M{"$ne": true}}, bson.M{"$unset": bson.M{"deleteat": ""}}); err != nil {
+		if err.Error() == "not found" {
+			return ErrorUsersDoesntExist{}
+		}
+		return err
+	}
+	a.log.Infof("Deletion of user %s is cancelled by login", user.Name)
+	user.DeleteAt = time.Time{}
+	return nil
+}
+
+// PurgeDeleted removes users which grace period is over together with their data
+// in the related collections, documents of which have the uid field. Users are claimed
+// for the purge first and removed last, so users which purge failed are purged by the next run.
+func (a *Auth) PurgeDeleted(users IAuthDataSource, related ...IAuthDataSource) (int, error) {
+	now := time.Now()
+	var due []RegData
+	if err := users.FindAll(bson.M{"deleteat": bson.M{"$lte": now}}, &due); err != nil {
+		return 0, err
+	}
+	for i := range due {
+		// The user is claimed only while the deletion is still scheduled, so a concurrent login wins
+		if err := users.Update(bson.M{"_id": due[i].Id, "deleteat": bson.M{"$lte": now}},
+			bson.M{"$set": bson.M{"purging": true}, "$unset": bson.M{"deleteat": ""}}); err != nil {
+			if err.Error() == "not found" {
+				continue
+			}
+			return 0, err
+		}
+	}
+
+	var claimed []RegData
+	if err := users.FindAll(bson.M{"purging": true}, &claimed); err != nil {
+		return 0, err
+	}
+	purged := 0
+	for i := range claimed {
+		for _, c := range related {
+			if err := c.RemoveAll(bson.M{"uid": claimed[i].Id}); err != nil && err.Error() != "not found" {
+				return purged, err
+			}
+		}
+		if err := users.Remove(bson.M{"_id": claimed[i].Id}); err != nil && err.Error() != "not found" {
+			return purged, err
+		}
+		purged++
+	}
+	return purged, nil
+}
@@ -0,0 +1,125 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"gopkg.in/mgo.v2/bson"
+	"github.com/dzendmitry/logger"
+)
+
+// failingSource fails removals while fail is set
+type failingSource struct {
+	*memSource
+	fail bool
+}
+
+func (s *failingSource) RemoveAll(selector interface{}) error {
+	if s.fail {
+		return errors.New("connection lost")
+	}
+	return s.memSource.RemoveAll(selector)
+}
+
+func TestPurgeDeleted(t *testing.T) {
+	a := &Auth{}
+	users := newMemSource()
+	// Invites and tokens are related collections like any other, documents of users are found by uid
+	sessions, invites, verifyTokens, resetTokens := newMemSource(), newMemSource(), newMemSource(), newMemSource()
+	related := []*memSource{sessions, invites, verifyTokens, resetTokens}
+
+	due := RegData{Id: bson.NewObjectId(), Name: "alice", DeleteAt: time.Now().Add(-time.Minute)}
+	pending := RegData{Id: bson.NewObjectId(), Name: "bob", DeleteAt: time.Now().Add(time.Hour)}
+	active := RegData{Id: bson.NewObjectId(), Name: "carol"}
+	for _, u := range []RegData{due, pending, active} {
+		if err := users.Insert(u); err != nil {
+			t.Fatal(err)
+		}
+		for _, c := range related {
+			if err := c.Insert(bson.M{"uid": u.Id}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	n, err := a.PurgeDeleted(users, sessions, invites, verifyTokens, resetTokens)
+	if err != nil || n != 1 {
+		t.Fatalf("Expected 1 purged user, got %d %v", n, err)
+	}
+	for _, c := range []struct {
+		user RegData
+		purged bool
+	}{{due, true}, {pending, false}, {active, false}} {
+		if n, _ := users.Count(bson.M{"_id": c.user.Id}); (n == 0) != c.purged {
+			t.Fatalf("Unexpected state of user %s", c.user.Name)
+		}
+		for i, r := range related {
+			if n, _ := r.Count(bson.M{"uid": c.user.Id}); (n == 0) != c.purged {
+				t.Fatalf("Unexpected state of related collection %d of user %s", i, c.user.Name)
+			}
+		}
+	}
+}
+
+func TestPurgeDeletedAfterFailure(t *testing.T) {
+	a := &Auth{}
+	users, sessions := newMemSource(), newMemSource()
+	units := &failingSource{memSource: newMemSource(), fail: true}
+	user := RegData{Id: bson.NewObjectId(), Name: "alice", DeleteAt: time.Now().Add(-time.Minute)}
+	if err := users.Insert(user); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []*memSource{sessions, units.memSource} {
+		if err := c.Insert(bson.M{"uid": user.Id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := a.PurgeDeleted(users, sessions, units); err == nil {
+		t.Fatal("The failure isn't reported")
+	}
+	// The user stays claimed, so the data isn't orphaned
+	var stored RegData
+	if err := users.FindOne(bson.M{"_id": user.Id}, &stored); err != nil {
+		t.Fatalf("The user is removed before its data: %v", err)
+	}
+	if !stored.Purging || !stored.DeleteAt.IsZero() {
+		t.Fatalf("The user isn't claimed for the purge: %+v", stored)
+	}
+
+	units.fail = false
+	n, err := a.PurgeDeleted(users, sessions, units)
+	if err != nil || n != 1 {
+		t.Fatalf("Expected 1 purged user on retry, got %d %v", n, err)
+	}
+	for i, c := range []*memSource{users, sessions, units.memSource} {
+		if n, _ := c.Count(bson.M{}); n != 0 {
+			t.Fatalf("Collection %d isn't purged", i)
+		}
+	}
+}
+
+func TestLoginDuringPurge(t *testing.T) {
+	log := logger.InitFileLogger("AUTH-TEST", "")
+	a := &Auth{
+		passwordCost: bcrypt.MinCost,
+		Throttle: NewThrottle(NewMemoryAttemptsStore(), newMemSource(), log),
+		Audit: NewAudit(newMemSource(), time.Hour, log),
+		log: log,
+	}
+	users := newMemSource("name")
+	user := RegData{Id: bson.NewObjectId(), Name: "alice", Password: bcryptHash(t, "secret", bcrypt.MinCost),
+		DeleteAt: time.Now().Add(-time.Minute)}
+	if err := users.Insert(user); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.PurgeDeleted(users, &failingSource{memSource: newMemSource(), fail: true}); err == nil {
+		t.Fatal("The failure isn't reported")
+	}
+	_, err := a.Auth(users, newMemSource(), &AuthData{Name: "alice", Password: "secret"}, ClientInfo{})
+	if _, ok := err.(ErrorInvalidLogin); !ok {
+		t.Fatalf("Expected ErrorInvalidLogin for the claimed user, got %#v", err)
+	}
+}
@@ -0,0 +1,115 @@
+package auth
+
+import (
+	"regexp"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	ROLE_USER = "user"
+	ROLE_ADMIN = "admin"
+
+	USERS_PAGE_LIMIT = 50
+	USERS_MAX_LIMIT = 500
+)
+
+func roleOf(user *RegData) string {
+	if user.Role == "" {
+		return ROLE_USER
+	}
+	return user.Role
+}
+
+// IsAdmin checks the role in the storage, so revoked role takes effect immediately
+func (a *Auth) IsAdmin(users IAuthDataSource, current *Session) (bool, error) {
+	user, err := a.findUser(users, current.Uid)
+	if err != nil {
+		if _, ok := err.(ErrorUsersDoesntExist); ok {
+			return false, nil
+		}
+		return false, err
+	}
+	return roleOf(user) == ROLE_ADMIN && !user.Disabled, nil
+}
+
+// GrantAdmins gives admin role to the users with given names. It's used to bootstrap admins from the config.
+func (a *Auth) GrantAdmins(users IAuthDataSource, names []string) error {
+	if len(names) == 0 {
+		return nil
+	}
+	return users.UpdateAll(bson.M{"name": bson.M{"$in": names}}, bson.M{"$set": bson.M{"role": ROLE_ADMIN}})
+}
+
+// Users returns users which name or email contains the query
+func (a *Auth) Users(users IAuthDataSource, query string, skip, limit int) ([]UserInfo, error) {
+	if limit <= 0 {
+		limit = USERS_PAGE_LIMIT
+	}
+	if limit > USERS_MAX_LIMIT {
+		limit = USERS_MAX_LIMIT
+	}
+	if skip < 0 {
+		skip = 0
+	}
+	selector := bson.M{}
+	if query != "" {
+		re := bson.RegEx{Pattern: regexp.QuoteMeta(query), Options: "i"}
+		selector["$or"] = []bson.M{{"name": re}, {"email": re}}
+	}
+	var found []RegData
+	if err := users.FindRange(selector, []string{"name"}, skip, limit, &found); err != nil {
+		return nil, err
+	}
+	list := make([]UserInfo, 0, len(found))
+	for i := range found {
+		list = append(list, UserInfo{
+			Id: found[i].Id,
+			Name: found[i].Name,
+			Email: found[i].Email,
+			Role: roleOf(&found[i]),
+			Disabled: found[i].Disabled,
+			Unverified: found[i].Unverified,
+			Totp: found[i].Totp != "",
+		})
+	}
+	return list, nil
+}
+
+// SetDisabled disables or enables the account. Disabling also removes all sessions of the user.
+func (a *Auth) SetDisabled(users IAuthDataSource, sessions IAuthDataSource, uid bson.ObjectId, disabled bool) error {
+	update := bson.M{"$unset": bson.M{"disabled": ""}}
+	if disabled {
+		update = bson.M{"$set": bson.M{"disabled": true}}
+	}
+	if err := users.Update(bson.M{"_id": uid}, update); err != nil {
+		if err.Error() == "not found" {
+			return ErrorUsersDoesntExist{}
+		}
+		return err
+	}
+	if disabled {
+		return a.Logout(sessions, uid)
+	}
+	return nil
+}
+
+func (a *Auth) Logout(sessions IAuthDataSource, uid bson.ObjectId) error {
+	return sessions.RemoveAll(bson.M{"uid": uid})
+}
+
+// DeleteUser removes the user and documents of the user from the related collections, sessions among them
+func (a *Auth) DeleteUser(users IAuthDataSource, uid bson.ObjectId, related ...IAuthDataSource) error {
+	for _, c := range related {
+		if err := c.RemoveAll(bson.M{"uid": uid}); err != nil && err.Error() != "not found" {
+			return err
+		}
+	}
+	if err := users.Remove(bson.M{"_id": uid}); err != nil {
+		if err.Error() == "not found" {
+			return ErrorUsersDoesntExist{}
+		}
+		return err
+	}
+	return nil
+}
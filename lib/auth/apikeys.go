@@ -0,0 +1,118 @@
+package auth
+
+import (
+	"strings"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+	"github.com/dzendmitry/rating-service/lib/general"
+)
+
+const (
+	API_KEY_PREFIX = "rsk_"
+	API_KEY_SIZE = 32
+	// Length of the key beginning which is stored as is to help users to recognize their keys
+	API_KEY_SHOWN_PREFIX = 12
+
+	SCOPE_READ = "read"
+	SCOPE_READ_WRITE = "read-write"
+)
+
+func isApiKey(token string) bool {
+	return strings.HasPrefix(token, API_KEY_PREFIX)
+}
+
+// CanWrite reports whether the session is allowed to change data. Only read-only api keys can't.
+func (s *Session) CanWrite() bool {
+	return s.Scope != SCOPE_READ
+}
+
+func (a *Auth) CreateApiKey(apiKeys IAuthDataSource, current *Session, data *ApiKeyData) (*NewApiKey, error) {
+	random, err := general.GetRandomToken(API_KEY_SIZE)
+	if err != nil {
+		return nil, err
+	}
+	key := API_KEY_PREFIX + random
+	scope := data.Scope
+	if scope == "" {
+		scope = SCOPE_READ
+	}
+	now := time.Now()
+	apiKey := ApiKey{
+		Id: bson.NewObjectId(),
+		Uid: current.Uid,
+		Name: data.Name,
+		Prefix: key[:API_KEY_SHOWN_PREFIX],
+		Hash: hashToken(key),
+		Scope: scope,
+		Created: now,
+	}
+	if data.ExpiresIn > 0 {
+		apiKey.Expires = now.Add(time.Duration(data.ExpiresIn) * 24 * time.Hour)
+	}
+	if err := apiKeys.Insert(apiKey); err != nil {
+		return nil, err
+	}
+	return &NewApiKey{ApiKey: apiKey, Key: key}, nil
+}
+
+func (a *Auth) ApiKeys(apiKeys IAuthDataSource, current *Session) ([]ApiKey, error) {
+	list := make([]ApiKey, 0)
+	if err := apiKeys.FindAll(bson.M{"uid": current.Uid}, &list); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+func (a *Auth) RevokeApiKey(apiKeys IAuthDataSource, current *Session, id bson.ObjectId) error {
+	if err := apiKeys.Remove(bson.M{"_id": id, "uid": current.Uid}); err != nil {
+		if err.Error() == "not found" {
+			return ErrorApiKeyNotFound{}
+		}
+		return err
+	}
+	return nil
+}
+
+// introspectApiKey checks the key and the state of its owner, keys of disabled users are inactive
+func (a *Auth) introspectApiKey(users IAuthDataSource, apiKeys IAuthDataSource, token string) (*Introspection, error) {
+	var key ApiKey
+	if err := apiKeys.FindOne(bson.M{"hash": hashToken(token)}, &key); err != nil {
+		if err.Error() == "not found" {
+			return &Introspection{}, nil
+		}
+		return nil, err
+	}
+	now := time.Now()
+	if !key.Expires.IsZero() && !now.Before(key.Expires) {
+		return &Introspection{}, nil
+	}
+	user, err := a.findUser(users, key.Uid)
+	if err != nil {
+		if _, ok := err.(ErrorUsersDoesntExist); ok {
+			return &Introspection{}, nil
+		}
+		return nil, err
+	}
+	// Keys stop working while the account is disabled or scheduled for deletion, like sessions do
+	if user.Disabled || !user.DeleteAt.IsZero() || user.Purging {
+		return &Introspection{}, nil
+	}
+	if now.Sub(key.LastUsed) >= LAST_SEEN_INTERVAL * time.Second {
+		// lastUsed is informational, failed update must not break the request
+		apiKeys.Update(bson.M{"_id": key.Id}, bson.M{"$set": bson.M{"lastused": now}})
+	}
+	expires := key.Expires
+	if expires.IsZero() {
+		expires = now.Add(COOKIE_EXPIRES * time.Second)
+	}
+	return &Introspection{
+		Active: true,
+		Uid: user.Id,
+		SessionId: key.Id,
+		Name: user.Name,
+		Unverified: user.Unverified,
+		Scope: key.Scope,
+		Expires: expires,
+	}, nil
+}
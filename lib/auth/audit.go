@@ -0,0 +1,146 @@
+package auth
+
+import (
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+	"github.com/dzendmitry/logger"
+)
+
+const (
+	AUDIT_RETENTION_DAYS = 90
+	AUDIT_PAGE_LIMIT = 100
+	AUDIT_MAX_LIMIT = 1000
+
+	AUDIT_OUTCOME_SUCCESS = "success"
+	AUDIT_OUTCOME_FAILURE = "failure"
+
+	AUDIT_REGISTER = "register"
+	AUDIT_UNREGISTER = "unregister"
+	AUDIT_LOGIN = "login"
+	AUDIT_LOGIN_OIDC = "login-oidc"
+	AUDIT_LOGOUT = "logout"
+	AUDIT_CHANGE_PASSWORD = "change-password"
+	AUDIT_RESET_PASSWORD = "reset-password"
+	AUDIT_VERIFY_EMAIL = "verify-email"
+	AUDIT_PROFILE_UPDATE = "profile-update"
+	AUDIT_TOTP_ENABLE = "totp-enable"
+	AUDIT_TOTP_DISABLE = "totp-disable"
+	AUDIT_API_KEY_CREATE = "api-key-create"
+	AUDIT_API_KEY_REVOKE = "api-key-revoke"
+	AUDIT_INVITE_CREATE = "invite-create"
+	AUDIT_INVITE_REVOKE = "invite-revoke"
+	AUDIT_ADMIN_UNLOCK = "admin-unlock"
+	AUDIT_ADMIN_DISABLE = "admin-disable"
+	AUDIT_ADMIN_ENABLE = "admin-enable"
+	AUDIT_ADMIN_LOGOUT = "admin-logout"
+	AUDIT_ADMIN_DELETE = "admin-delete"
+)
+
+// Audit writes security events to a collection which is only appended to.
+// Events expire after the retention period by the TTL index on the expires field.
+type Audit struct {
+	events IAuthDataSource
+	retention time.Duration
+	log logger.ILogger
+}
+
+func NewAudit(events IAuthDataSource, retention time.Duration, log logger.ILogger) *Audit {
+	return &Audit{events: events, retention: retention, log: log}
+}
+
+// Record never fails the audited action, errors are only logged.
+// Name identifies the account when the user is unknown, e.g. for failed logins.
+func (au *Audit) Record(eventType string, uid bson.ObjectId, name string, client ClientInfo, err error) {
+	now := time.Now()
+	event := AuditEvent{
+		Id: bson.NewObjectId(),
+		Type: eventType,
+		Uid: uid,
+		Name: name,
+		Ip: client.Ip,
+		UserAgent: client.UserAgent,
+		Outcome: AUDIT_OUTCOME_SUCCESS,
+		Created: now,
+		Expires: now.Add(au.retention),
+	}
+	if err != nil {
+		event.Outcome = AUDIT_OUTCOME_FAILURE
+		event.Reason = err.Error()
+	}
+	au.insert(event)
+}
+
+// RecordAdmin records the action of the admin on the target user
+func (au *Audit) RecordAdmin(eventType string, admin *Session, target bson.ObjectId, client ClientInfo, err error) {
+	now := time.Now()
+	event := AuditEvent{
+		Id: bson.NewObjectId(),
+		Type: eventType,
+		Uid: admin.Uid,
+		Name: admin.Name,
+		Target: target,
+		Ip: client.Ip,
+		UserAgent: client.UserAgent,
+		Outcome: AUDIT_OUTCOME_SUCCESS,
+		Created: now,
+		Expires: now.Add(au.retention),
+	}
+	if err != nil {
+		event.Outcome = AUDIT_OUTCOME_FAILURE
+		event.Reason = err.Error()
+	}
+	au.insert(event)
+}
+
+func (au *Audit) insert(event AuditEvent) {
+	if err := au.events.Insert(event); err != nil {
+		au.log.Warnf("Error while recording audit event %s of %s: %s", event.Type, event.Name, err.Error())
+	}
+}
+
+// UserEvents returns all events made by the user and admin actions on it, the latest go first
+func (au *Audit) UserEvents(uid bson.ObjectId) ([]AuditEvent, error) {
+	events := make([]AuditEvent, 0)
+	selector := bson.M{"$or": []bson.M{{"uid": uid}, {"target": uid}}}
+	if err := au.events.FindRange(selector, []string{"-created"}, 0, 0, &events); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// Events returns the newest events which match the filter
+func (au *Audit) Events(filter *AuditFilter, skip, limit int) ([]AuditEvent, error) {
+	if limit <= 0 {
+		limit = AUDIT_PAGE_LIMIT
+	}
+	if limit > AUDIT_MAX_LIMIT {
+		limit = AUDIT_MAX_LIMIT
+	}
+	if skip < 0 {
+		skip = 0
+	}
+	selector := bson.M{}
+	if filter.Uid != "" {
+		// Events made by the user and admin actions on it
+		selector["$or"] = []bson.M{{"uid": filter.Uid}, {"target": filter.Uid}}
+	}
+	if filter.Type != "" {
+		selector["type"] = filter.Type
+	}
+	created := bson.M{}
+	if !filter.From.IsZero() {
+		created["$gte"] = filter.From
+	}
+	if !filter.To.IsZero() {
+		created["$lt"] = filter.To
+	}
+	if len(created) > 0 {
+		selector["created"] = created
+	}
+	list := make([]AuditEvent, 0)
+	if err := au.events.FindRange(selector, []string{"-created"}, skip, limit, &list); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
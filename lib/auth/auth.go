@@ -7,14 +7,28 @@ import (
 	"gopkg.in/mgo.v2/bson"
 	"strings"
 	"github.com/dzendmitry/rating-service/lib/general"
-	"time"
-	"strconv"
 	"net/http"
+	"time"
 )
 
 const (
 	AUTH_VALIDATE = "auth"
 	REG_VALIDATE = "reg"
+	SESSION_VALIDATE = "session"
+	CHANGE_PASSWORD_VALIDATE = "change-password"
+	RESET_REQUEST_VALIDATE = "reset-request"
+	RESET_PASSWORD_VALIDATE = "reset-password"
+	REFRESH_VALIDATE = "refresh"
+	INTROSPECT_VALIDATE = "introspect"
+	UNLOCK_VALIDATE = "unlock"
+	TOTP_CODE_VALIDATE = "totp-code"
+	TOTP_DISABLE_VALIDATE = "totp-disable"
+	USER_ID_VALIDATE = "user-id"
+	API_KEY_VALIDATE = "api-key"
+	API_KEY_ID_VALIDATE = "api-key-id"
+	PROFILE_VALIDATE = "profile"
+	INVITE_VALIDATE = "invite"
+	INVITE_ID_VALIDATE = "invite-id"
 
 	COOKIE_EXPIRES = 1209600
 	SidKey = "sid"
@@ -24,17 +38,35 @@ type IAuthDataSource interface {
 	Insert(query interface{}) error
 	FindOne(query interface{}, result interface{}) error
 	Remove(selector interface{}) error
+	Update(selector, update interface{}) error
+	FindAll(query interface{}, result interface{}) error
+	FindRange(query interface{}, sort []string, skip, limit int, result interface{}) error
+	RemoveAll(selector interface{}) error
+	UpdateAll(selector, update interface{}) error
+	Count(query interface{}) (int, error)
 }
 
 type Auth struct {
 	Validator *general.Validator
+	passwordCost int
+	verifySecret []byte
+	Throttle *Throttle
+	Audit *Audit
+	Policy *PasswordPolicy
 	log logger.ILogger
 }
 
-func New(validator *general.Validator) *Auth {
+func New(validator *general.Validator, passwordCost int, verifySecret []byte, store IAttemptsStore, lockEvents IAuthDataSource,
+	auditEvents IAuthDataSource, auditRetention time.Duration, policy *PasswordPolicy) *Auth {
+	log := logger.InitFileLogger("AUTH", "")
 	return &Auth{
 		Validator: validator,
-		log: logger.InitFileLogger("AUTH", ""),
+		passwordCost: passwordCost,
+		verifySecret: verifySecret,
+		Throttle: NewThrottle(store, lockEvents, log),
+		Audit: NewAudit(auditEvents, auditRetention, log),
+		Policy: policy,
+		log: log,
 	}
 }
 
@@ -47,80 +79,91 @@ func (a *Auth) Register(users IAuthDataSource, query interface{}) error {
 		if strings.Contains(err.Error(), "dup key") {
 			return ErrorUserExists{}
 		}
+		return err
 	}
 	return nil
 }
 
-func (a *Auth) Unregister(units IAuthDataSource, users IAuthDataSource, query interface{}) error {
+func (a *Auth) Auth(users IAuthDataSource, sessions IAuthDataSource, query interface{}, client ClientInfo) (string, error) {
 	switch o := query.(type) {
-	case *Session:
-		if err := units.Remove(bson.M{"uid": o.Uid}); err != nil {
-			if err.Error() != "not found" {
-				return err
-			}
-		}
-		if err := users.Remove(bson.M{"_id": o.Uid}); err != nil {
-			if err.Error() == "not found" {
-				return ErrorUsersDoesntExist{}
-			}
-			return err
-		}
+	case *AuthData:
+		sid, uid, err := a.login(users, sessions, o, client)
+		a.Audit.Record(AUDIT_LOGIN, uid, o.Name, client, err)
+		return sid, err
 	default:
-		return errors.New("Invalid data")
+		return "", errors.New("Invalid data")
 	}
-	return nil
 }
 
-func (a *Auth) Auth(users IAuthDataSource, sessions IAuthDataSource, query interface{}) (string, error) {
-	switch o := query.(type) {
-	case *AuthData:
-		var user RegData
-		if err := users.FindOne(bson.M{"name": o.Name}, &user); err != nil {
-			if err.Error() == "not found" {
-				return "", ErrorInvalidLogin{}
-			}
-			return "", err
+// login returns id of the found user also on failures, so they can be audited
+func (a *Auth) login(users IAuthDataSource, sessions IAuthDataSource, data *AuthData, client ClientInfo) (string, bson.ObjectId, error) {
+	var user RegData
+	if err := a.Throttle.Check(data.Name, client.Ip); err != nil {
+		return "", user.Id, err
+	}
+	if err := users.FindOne(bson.M{"name": data.Name}, &user); err != nil {
+		if err.Error() == "not found" {
+			a.Throttle.Failure(data.Name, client.Ip)
+			return "", user.Id, ErrorInvalidLogin{}
 		}
-		if user.Name != o.Name {
-			return "", ErrorInvalidLogin{}
+		return "", user.Id, err
+	}
+	if user.Name != data.Name {
+		a.Throttle.Failure(data.Name, client.Ip)
+		return "", user.Id, ErrorInvalidLogin{}
+	}
+	ok, rehash := a.CheckPassword(user.Password, data.Password)
+	if !ok {
+		a.Throttle.Failure(data.Name, client.Ip)
+		return "", user.Id, ErrorInvalidPassword{}
+	}
+	// The state of the account is disclosed only to the ones who know the password
+	if user.Disabled {
+		return "", user.Id, ErrorUserDisabled{}
+	}
+	if user.Totp != "" {
+		if err := a.checkSecondFactor(users, &user, data.Code); err != nil {
+			if _, ok := err.(ErrorInvalidTotp); ok {
+				a.Throttle.Failure(data.Name, client.Ip)
+			}
+			return "", user.Id, err
 		}
-		if user.Password != general.GetHash(o.Password) {
-			return "", ErrorInvalidPassword{}
+	}
+	a.Throttle.Success(data.Name)
+	if err := a.cancelDeletion(users, &user); err != nil {
+		// The account is being purged
+		if _, ok := err.(ErrorUsersDoesntExist); ok {
+			return "", user.Id, ErrorInvalidLogin{}
 		}
-		sid := general.GetHash(
-			user.Name,
-			user.Email,
-			string(user.Id),
-			strconv.FormatInt(time.Now().UnixNano(), 10))
-		if err := sessions.Insert(bson.M{
-			SidKey: sid,
-			"uid": user.Id,
-			"created": time.Now(),
-			}); err != nil {
-			return "", err
+		return "", user.Id, err
+	}
+	if rehash {
+		if hash, err := a.HashPassword(data.Password); err != nil {
+			a.log.Warnf("Error while rehashing password of user %s: %s", user.Name, err.Error())
+		} else if err := users.Update(bson.M{"_id": user.Id}, bson.M{"$set": bson.M{"password": hash}}); err != nil {
+			a.log.Warnf("Error while saving rehashed password of user %s: %s", user.Name, err.Error())
 		}
-		return sid, nil
-	default:
-		return "", errors.New("Invalid data")
 	}
-	return "", nil
+	sid, err := a.newSession(sessions, &user, client, data.Remember)
+	return sid, user.Id, err
 }
 
-func (a *Auth) Exit(sessions IAuthDataSource, cookie *http.Cookie) (*Session, error) {
-	var session Session
-	if err := sessions.FindOne(bson.M{SidKey: cookie.Value}, &session); err != nil {
-		if err.Error() == "not found" {
-			return nil, ErrorNotAuthorized{}
-		}
-		return nil, err
+func bearerToken(req *http.Request) string {
+	h := req.Header.Get("Authorization")
+	if len(h) > len(TOKEN_TYPE) + 1 && strings.EqualFold(h[:len(TOKEN_TYPE)], TOKEN_TYPE) && h[len(TOKEN_TYPE)] == ' ' {
+		return strings.TrimSpace(h[len(TOKEN_TYPE) + 1:])
 	}
-	if err := sessions.Remove(bson.M{"_id": session.Id}); err != nil {
-		return nil, err
-	}
-	return &session, nil
+	return ""
 }
 
 func isAuth(req *http.Request, sessions IAuthDataSource) (bool, *Session, error) {
+	if token := bearerToken(req); token != "" && tokensEnabled() {
+		claims, err := VerifyAccessToken(token)
+		if err != nil {
+			return false, nil, err
+		}
+		return true, sessionFromClaims(claims), nil
+	}
 	cookie, err := req.Cookie(SidKey)
 	if err != nil {
 		return false, nil, errors.New(fmt.Sprintf("Cookie not round in request: %s", req.RequestURI))
@@ -129,9 +172,18 @@ func isAuth(req *http.Request, sessions IAuthDataSource) (bool, *Session, error)
 	if err := sessions.FindOne(bson.M{SidKey: cookie.Value}, &session); err != nil {
 		return false, nil, ErrorNotAuthorized{}
 	}
+	if !session.active(time.Now()) {
+		return false, nil, ErrorNotAuthorized{}
+	}
+	if err := checkCsrf(req, session.Csrf); err != nil {
+		return false, nil, err
+	}
+	touchSession(sessions, &session)
 	return true, &session, nil
 }
 
+// Is checks the sid cookie or the access token. Api keys are accepted only by Client,
+// they can't be used to manage the account. Mutating requests with the cookie need the CSRF token.
 func Is(req *http.Request, sessions IAuthDataSource, log logger.ILogger) (bool, *Session) {
 	a, s, err := isAuth(req, sessions)
 	if err != nil {
@@ -0,0 +1,183 @@
+package auth
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+	"github.com/dzendmitry/logger"
+	"github.com/dzendmitry/rating-service/lib/general"
+)
+
+const (
+	// Answers of auth-service are cached for a short time, so revoked sessions stay valid no longer than that (seconds)
+	CLIENT_CACHE_TTL = 10
+	CLIENT_NEGATIVE_CACHE_TTL = 2
+	CLIENT_CACHE_SIZE = 10000
+)
+
+type clientCacheEntry struct {
+	introspection *Introspection
+	expires time.Time
+}
+
+// Client checks requests credentials with auth-service introspection endpoint instead of reading sessions storage,
+// statuses of users are asked from auth-service as well.
+// Credentials are taken from the sid cookie or from the Authorization header (sid, access token or api key).
+// Access tokens are verified locally when token keys are initialized.
+type Client struct {
+	url string
+	// Shared secret of services sent to internal endpoints of auth-service
+	secret string
+	httpClient *http.Client
+	mu sync.Mutex
+	cache map[string]clientCacheEntry
+	log logger.ILogger
+}
+
+func NewClient(authUrl string, secret string, log logger.ILogger) *Client {
+	return &Client{
+		url: authUrl,
+		secret: secret,
+		httpClient: &http.Client{Timeout: general.ONE_REQUEST_TIMEOUT * time.Millisecond},
+		cache: make(map[string]clientCacheEntry),
+		log: log,
+	}
+}
+
+func (c *Client) Is(req *http.Request) (bool, *Session) {
+	a, s, err := c.isAuth(req)
+	if err != nil {
+		c.log.Warn(err.Error())
+	}
+	return a, s
+}
+
+func (c *Client) isAuth(req *http.Request) (bool, *Session, error) {
+	token := bearerToken(req)
+	if token != "" && !isApiKey(token) && tokensEnabled() {
+		claims, err := VerifyAccessToken(token)
+		if err != nil {
+			return false, nil, err
+		}
+		return true, sessionFromClaims(claims), nil
+	}
+	sid := token
+	if sid == "" {
+		cookie, err := req.Cookie(SidKey)
+		if err != nil {
+			return false, nil, errors.New(fmt.Sprintf("Cookie not round in request: %s", req.RequestURI))
+		}
+		sid = cookie.Value
+	}
+	i, err := c.introspect(sid)
+	if err != nil {
+		return false, nil, err
+	}
+	if !i.Active {
+		return false, nil, ErrorNotAuthorized{}
+	}
+	if token == "" {
+		if err := checkCsrf(req, i.Csrf); err != nil {
+			return false, nil, err
+		}
+	}
+	if isApiKey(sid) {
+		// The key itself must not be stored with user data
+		sid = i.SessionId.Hex()
+	}
+	return true, &Session{
+		Id: i.SessionId,
+		Sid: sid,
+		Uid: i.Uid,
+		Name: i.Name,
+		Unverified: i.Unverified,
+		Scope: i.Scope,
+	}, nil
+}
+
+func (c *Client) introspect(token string) (*Introspection, error) {
+	key := hashToken(token)
+	now := time.Now()
+	c.mu.Lock()
+	entry, ok := c.cache[key]
+	c.mu.Unlock()
+	if ok && now.Before(entry.expires) {
+		return entry.introspection, nil
+	}
+
+	i, err := c.request(token)
+	if err != nil {
+		return nil, err
+	}
+	expires := now.Add(CLIENT_NEGATIVE_CACHE_TTL * time.Second)
+	if i.Active {
+		expires = now.Add(CLIENT_CACHE_TTL * time.Second)
+		if i.Expires.Before(expires) {
+			expires = i.Expires
+		}
+	}
+	c.mu.Lock()
+	if len(c.cache) >= CLIENT_CACHE_SIZE {
+		for k, e := range c.cache {
+			if !now.Before(e.expires) {
+				delete(c.cache, k)
+			}
+		}
+		if len(c.cache) >= CLIENT_CACHE_SIZE {
+			c.cache = make(map[string]clientCacheEntry)
+		}
+	}
+	c.cache[key] = clientCacheEntry{introspection: i, expires: expires}
+	c.mu.Unlock()
+	return i, nil
+}
+
+func (c *Client) request(token string) (*Introspection, error) {
+	var i Introspection
+	if err := c.post(IntrospectUri(), IntrospectData{Token: token}, &i); err != nil {
+		return nil, errors.New(fmt.Sprintf("Introspection %s", err.Error()))
+	}
+	return &i, nil
+}
+
+// UserActive asks auth-service whether the user is active, e.g. to hide public data of disabled users
+func (c *Client) UserActive(uid bson.ObjectId) (bool, error) {
+	var status UserStatus
+	if err := c.post(UserStatusUri(), UserId{Id: uid}, &status); err != nil {
+		return false, errors.New(fmt.Sprintf("User status %s", err.Error()))
+	}
+	return status.Active, nil
+}
+
+// post sends the data to the internal endpoint of auth-service with the service secret
+func (c *Client) post(uri string, data interface{}, result interface{}) error {
+	body, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, c.url + uri, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(SERVICE_SECRET_HEADER, c.secret)
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		return errors.New(fmt.Sprintf("request error: %s", err.Error()))
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return errors.New(fmt.Sprintf("invalid status code: %s", res.Status))
+	}
+	if err := json.NewDecoder(io.LimitReader(res.Body, general.BODY_BUFFER)).Decode(result); err != nil {
+		return errors.New(fmt.Sprintf("unmarshalling answer: %s", err.Error()))
+	}
+	return nil
+}
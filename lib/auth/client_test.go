@@ -0,0 +1,103 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestCheckServiceSecret(t *testing.T) {
+	for _, c := range []struct {
+		name string
+		secret string
+		header string
+		ok bool
+	}{
+		{"same secret", "secret", "secret", true},
+		{"another secret", "secret", "other", false},
+		{"prefix of the secret", "secret", "sec", false},
+		{"no header", "secret", "", false},
+		{"no secret configured", "", "", false},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, IntrospectUri(), nil)
+			if c.header != "" {
+				req.Header.Set(SERVICE_SECRET_HEADER, c.header)
+			}
+			if ok := CheckServiceSecret(req, []byte(c.secret)); ok != c.ok {
+				t.Fatalf("Expected %v, got %v", c.ok, ok)
+			}
+		})
+	}
+}
+
+func TestClientSendsServiceSecret(t *testing.T) {
+	uid := bson.NewObjectId()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if !CheckServiceSecret(req, []byte("secret")) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(Introspection{Active: true, Uid: uid, Expires: time.Now().Add(time.Hour)})
+	}))
+	defer srv.Close()
+
+	i, err := NewClient(srv.URL, "secret", nil).introspect("sid")
+	if err != nil || !i.Active || i.Uid != uid {
+		t.Fatalf("Unexpected introspection %+v %v", i, err)
+	}
+	if _, err := NewClient(srv.URL, "other", nil).introspect("sid"); err == nil {
+		t.Fatal("Introspection with another secret succeeded")
+	}
+}
+
+func TestUserStatus(t *testing.T) {
+	a := &Auth{}
+	users := newMemSource()
+	active := RegData{Id: bson.NewObjectId(), Name: "alice"}
+	disabled := RegData{Id: bson.NewObjectId(), Name: "bob", Disabled: true}
+	deleted := RegData{Id: bson.NewObjectId(), Name: "carol", DeleteAt: time.Now().Add(time.Hour)}
+	for _, u := range []RegData{active, disabled, deleted} {
+		if err := users.Insert(u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var data UserId
+		if !CheckServiceSecret(req, []byte("secret")) || req.URL.Path != UserStatusUri() ||
+			json.NewDecoder(req.Body).Decode(&data) != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		status, err := a.UserStatus(users, data.Id)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(status)
+	}))
+	defer srv.Close()
+
+	client := NewClient(srv.URL, "secret", nil)
+	for _, c := range []struct {
+		name string
+		uid bson.ObjectId
+		active bool
+	}{
+		{"active", active.Id, true},
+		{"disabled", disabled.Id, false},
+		{"scheduled for deletion", deleted.Id, false},
+		{"unknown", bson.NewObjectId(), false},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			ok, err := client.UserActive(c.uid)
+			if err != nil || ok != c.active {
+				t.Fatalf("Expected %v, got %v %v", c.active, ok, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,110 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"gopkg.in/mgo.v2/bson"
+	"github.com/dzendmitry/rating-service/lib/general"
+)
+
+const (
+	CSRF_TOKEN_SIZE = 32
+	// The token is sent back by clients in the header, the cookie lets scripts read it after reload
+	CSRF_HEADER = "X-CSRF-Token"
+	CSRF_COOKIE = "csrf"
+
+	SAME_SITE_LAX = "Lax"
+	SAME_SITE_STRICT = "Strict"
+	SAME_SITE_NONE = "None"
+)
+
+// CookieConfig holds attributes of cookies set by auth-service
+type CookieConfig struct {
+	Secure bool
+	HttpOnly bool
+	SameSite string
+	Domain string
+}
+
+func ParseSameSite(s string) (string, bool) {
+	for _, v := range []string{SAME_SITE_LAX, SAME_SITE_STRICT, SAME_SITE_NONE} {
+		if strings.EqualFold(s, v) {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+// SetCookie applies configured attributes to the cookie. SameSite is appended to the header,
+// net/http of the used go version has no field for it.
+func (c CookieConfig) SetCookie(w http.ResponseWriter, cookie *http.Cookie) {
+	cookie.Secure = c.Secure
+	cookie.Domain = c.Domain
+	v := cookie.String()
+	if v == "" {
+		return
+	}
+	if c.SameSite != "" {
+		v += "; SameSite=" + c.SameSite
+	}
+	w.Header().Add("Set-Cookie", v)
+}
+
+func newCsrfToken() (string, error) {
+	return general.GetRandomToken(CSRF_TOKEN_SIZE)
+}
+
+func isSafeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+// checkCsrf is called for requests authenticated by the sid cookie only,
+// browsers never attach bearer tokens and api keys by themselves
+func checkCsrf(req *http.Request, csrf string) error {
+	if isSafeMethod(req.Method) {
+		return nil
+	}
+	token := req.Header.Get(CSRF_HEADER)
+	if csrf == "" || token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(csrf)) != 1 {
+		return ErrorInvalidCsrf{}
+	}
+	return nil
+}
+
+func (a *Auth) FindSession(sessions IAuthDataSource, sid string) (*Session, error) {
+	var session Session
+	if err := sessions.FindOne(bson.M{SidKey: sid}, &session); err != nil {
+		if err.Error() == "not found" {
+			return nil, ErrorNotAuthorized{}
+		}
+		return nil, err
+	}
+	return &session, nil
+}
+
+// CsrfToken returns the token of the session. Sessions created before tokens were introduced get a new one.
+func (a *Auth) CsrfToken(sessions IAuthDataSource, current *Session) (string, error) {
+	if current.Csrf != "" {
+		return current.Csrf, nil
+	}
+	csrf, err := newCsrfToken()
+	if err != nil {
+		return "", err
+	}
+	if err := sessions.Update(bson.M{"_id": current.Id, "csrf": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"csrf": csrf}}); err != nil {
+		if err.Error() != "not found" {
+			return "", err
+		}
+		// Concurrent request has already set the token
+		session, err := a.FindSession(sessions, current.Sid)
+		if err != nil {
+			return "", err
+		}
+		return session.Csrf, nil
+	}
+	current.Csrf = csrf
+	return csrf, nil
+}
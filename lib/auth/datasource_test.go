@@ -0,0 +1,343 @@
+package auth
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// memSource is an in-memory IAuthDataSource which understands the subset of mongo queries used by the package
+type memSource struct {
+	mu sync.Mutex
+	docs []bson.M
+	unique []string
+}
+
+func newMemSource(unique ...string) *memSource {
+	return &memSource{unique: unique}
+}
+
+// toDoc normalizes values the same way they are stored by mongo
+func toDoc(v interface{}) bson.M {
+	data, err := bson.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	var m bson.M
+	if err := bson.Unmarshal(data, &m); err != nil {
+		panic(err)
+	}
+	return m
+}
+
+func fromDoc(m bson.M, result interface{}) error {
+	data, err := bson.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return bson.Unmarshal(data, result)
+}
+
+func compare(a, b interface{}) (int, bool) {
+	switch x := a.(type) {
+	case time.Time:
+		y, ok := b.(time.Time)
+		if !ok {
+			return 0, false
+		}
+		switch {
+		case x.Before(y):
+			return -1, true
+		case x.After(y):
+			return 1, true
+		}
+		return 0, true
+	case int, int32, int64:
+		xi, yi := reflect.ValueOf(a).Int(), int64(0)
+		switch y := b.(type) {
+		case int, int32, int64:
+			yi = reflect.ValueOf(y).Int()
+		default:
+			return 0, false
+		}
+		switch {
+		case xi < yi:
+			return -1, true
+		case xi > yi:
+			return 1, true
+		}
+		return 0, true
+	case string:
+		y, ok := b.(string)
+		if !ok {
+			return 0, false
+		}
+		return strings.Compare(x, y), true
+	}
+	return 0, false
+}
+
+func equalValue(field, v interface{}) bool {
+	if reflect.DeepEqual(field, v) {
+		return true
+	}
+	if c, ok := compare(field, v); ok && c == 0 {
+		return true
+	}
+	// Scalars match elements of arrays
+	if arr, ok := field.([]interface{}); ok {
+		for _, e := range arr {
+			if equalValue(e, v) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func matchOperators(field interface{}, exists bool, ops bson.M) bool {
+	for op, v := range ops {
+		switch op {
+		case "$ne":
+			if exists && equalValue(field, v) {
+				return false
+			}
+		case "$exists":
+			if exists != v.(bool) {
+				return false
+			}
+		case "$in":
+			found := false
+			for _, e := range v.([]interface{}) {
+				found = found || (exists && equalValue(field, e))
+			}
+			if !found {
+				return false
+			}
+		case "$lt", "$lte", "$gt", "$gte":
+			c, ok := compare(field, v)
+			if !exists || !ok {
+				return false
+			}
+			if (op == "$lt" && c >= 0) || (op == "$lte" && c > 0) || (op == "$gt" && c <= 0) || (op == "$gte" && c < 0) {
+				return false
+			}
+		case "$elemMatch":
+			arr, _ := field.([]interface{})
+			found := false
+			for _, e := range arr {
+				if d, ok := e.(bson.M); ok && matches(d, v.(bson.M)) {
+					found = true
+				}
+			}
+			if !found {
+				return false
+			}
+		default:
+			panic("memSource doesn't support " + op)
+		}
+	}
+	return true
+}
+
+func matches(doc bson.M, selector bson.M) bool {
+	for k, v := range selector {
+		field, exists := doc[k]
+		if ops, ok := v.(bson.M); ok && len(ops) > 0 && strings.HasPrefix(firstKey(ops), "$") {
+			if !matchOperators(field, exists, ops) {
+				return false
+			}
+			continue
+		}
+		if !exists || !equalValue(field, v) {
+			return false
+		}
+	}
+	return true
+}
+
+func firstKey(m bson.M) string {
+	for k := range m {
+		return k
+	}
+	return ""
+}
+
+func apply(doc bson.M, update bson.M) {
+	for op, v := range update {
+		fields := v.(bson.M)
+		for k, value := range fields {
+			switch op {
+			case "$set":
+				doc[k] = value
+			case "$unset":
+				delete(doc, k)
+			case "$push":
+				arr, _ := doc[k].([]interface{})
+				if each, ok := value.(bson.M); ok && each["$each"] != nil {
+					arr = append(arr, each["$each"].([]interface{})...)
+				} else {
+					arr = append(arr, value)
+				}
+				doc[k] = arr
+			case "$pull":
+				arr, _ := doc[k].([]interface{})
+				kept := make([]interface{}, 0, len(arr))
+				for _, e := range arr {
+					if !equalValue(e, value) {
+						kept = append(kept, e)
+					}
+				}
+				doc[k] = kept
+			case "$inc":
+				n, _ := doc[k].(int)
+				doc[k] = n + value.(int)
+			default:
+				panic("memSource doesn't support " + op)
+			}
+		}
+	}
+}
+
+func (s *memSource) Insert(query interface{}) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	doc := toDoc(query)
+	if _, ok := doc["_id"]; !ok {
+		doc["_id"] = bson.NewObjectId()
+	}
+	for _, d := range s.docs {
+		for _, k := range append([]string{"_id"}, s.unique...) {
+			if v, ok := doc[k]; ok && reflect.DeepEqual(d[k], v) {
+				return errors.New("E11000 duplicate key error index: " + k + " dup key")
+			}
+		}
+	}
+	s.docs = append(s.docs, doc)
+	return nil
+}
+
+func (s *memSource) find(query interface{}) []bson.M {
+	selector := bson.M{}
+	if query != nil {
+		selector = toDoc(query)
+	}
+	found := make([]bson.M, 0)
+	for _, d := range s.docs {
+		if matches(d, selector) {
+			found = append(found, d)
+		}
+	}
+	return found
+}
+
+func (s *memSource) FindOne(query interface{}, result interface{}) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	found := s.find(query)
+	if len(found) == 0 {
+		return errors.New("not found")
+	}
+	return fromDoc(found[0], result)
+}
+
+func (s *memSource) FindAll(query interface{}, result interface{}) error {
+	return s.FindRange(query, nil, 0, 0, result)
+}
+
+func (s *memSource) FindRange(query interface{}, order []string, skip, limit int, result interface{}) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	found := s.find(query)
+	sort.SliceStable(found, func(i, j int) bool {
+		for _, k := range order {
+			desc := strings.HasPrefix(k, "-")
+			k = strings.TrimPrefix(k, "-")
+			c, _ := compare(found[i][k], found[j][k])
+			if c != 0 {
+				return (c < 0) != desc
+			}
+		}
+		return false
+	})
+	if skip > len(found) {
+		skip = len(found)
+	}
+	found = found[skip:]
+	if limit > 0 && limit < len(found) {
+		found = found[:limit]
+	}
+	slice := reflect.ValueOf(result).Elem()
+	slice.Set(reflect.MakeSlice(slice.Type(), 0, len(found)))
+	for _, d := range found {
+		e := reflect.New(slice.Type().Elem())
+		if err := fromDoc(d, e.Interface()); err != nil {
+			return err
+		}
+		slice.Set(reflect.Append(slice, e.Elem()))
+	}
+	return nil
+}
+
+func (s *memSource) Remove(selector interface{}) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sel := toDoc(selector)
+	for i, d := range s.docs {
+		if matches(d, sel) {
+			s.docs = append(s.docs[:i], s.docs[i + 1:]...)
+			return nil
+		}
+	}
+	return errors.New("not found")
+}
+
+func (s *memSource) RemoveAll(selector interface{}) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sel := toDoc(selector)
+	kept := make([]bson.M, 0, len(s.docs))
+	for _, d := range s.docs {
+		if !matches(d, sel) {
+			kept = append(kept, d)
+		}
+	}
+	s.docs = kept
+	return nil
+}
+
+func (s *memSource) Update(selector, update interface{}) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sel, upd := toDoc(selector), toDoc(update)
+	for _, d := range s.docs {
+		if matches(d, sel) {
+			apply(d, upd)
+			return nil
+		}
+	}
+	return errors.New("not found")
+}
+
+func (s *memSource) UpdateAll(selector, update interface{}) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sel, upd := toDoc(selector), toDoc(update)
+	for _, d := range s.docs {
+		if matches(d, sel) {
+			apply(d, upd)
+		}
+	}
+	return nil
+}
+
+func (s *memSource) Count(query interface{}) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.find(query)), nil
+}
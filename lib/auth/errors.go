@@ -1,5 +1,10 @@
 package auth
 
+import (
+	"strings"
+	"time"
+)
+
 type ErrorUsersDoesntExist struct {}
 func (e ErrorUsersDoesntExist) Error() string {
 	return "User doesn't exist"
@@ -28,4 +33,132 @@ func (e ErrorAlreadyAuthenticated) Error() string {
 type ErrorNotAuthorized struct {}
 func (e ErrorNotAuthorized) Error() string {
 	return "User not authorized"
-}
\ No newline at end of file
+}
+type ErrorSessionNotFound struct {}
+func (e ErrorSessionNotFound) Error() string {
+	return "Session not found"
+}
+
+type ErrorInvalidToken struct {}
+func (e ErrorInvalidToken) Error() string {
+	return "Invalid or expired token"
+}
+
+type ErrorAlreadyVerified struct {}
+func (e ErrorAlreadyVerified) Error() string {
+	return "Email already verified"
+}
+
+type ErrorEmailNotVerified struct {}
+func (e ErrorEmailNotVerified) Error() string {
+	return "Email is not verified"
+}
+
+type ErrorTooManyRequests struct {}
+func (e ErrorTooManyRequests) Error() string {
+	return "Too many requests, try again later"
+}
+
+type ErrorLoginThrottled struct {
+	Until time.Time
+	Locked bool
+}
+func (e ErrorLoginThrottled) Error() string {
+	if e.Locked {
+		return "Too many failed login attempts, login is locked until " + e.Until.Format(time.RFC3339)
+	}
+	return "Too many failed login attempts, try again after " + e.Until.Format(time.RFC3339)
+}
+
+type ErrorForbidden struct {}
+func (e ErrorForbidden) Error() string {
+	return "Forbidden"
+}
+
+
+type ErrorTotpRequired struct {}
+func (e ErrorTotpRequired) Error() string {
+	return "Two-factor authentication code required"
+}
+
+type ErrorInvalidTotp struct {}
+func (e ErrorInvalidTotp) Error() string {
+	return "Invalid two-factor authentication code"
+}
+
+type ErrorTotpEnabled struct {}
+func (e ErrorTotpEnabled) Error() string {
+	return "Two-factor authentication already enabled"
+}
+
+type ErrorTotpNotEnabled struct {}
+func (e ErrorTotpNotEnabled) Error() string {
+	return "Two-factor authentication is not enabled"
+}
+
+
+type ErrorUserDisabled struct {}
+func (e ErrorUserDisabled) Error() string {
+	return "User is disabled"
+}
+
+type ErrorApiKeyNotFound struct {}
+func (e ErrorApiKeyNotFound) Error() string {
+	return "Api key not found"
+}
+
+type ErrorReadOnly struct {}
+func (e ErrorReadOnly) Error() string {
+	return "Credentials allow only reading"
+}
+
+type ErrorUnknownProvider struct {}
+func (e ErrorUnknownProvider) Error() string {
+	return "Unknown identity provider"
+}
+
+type ErrorIdentityLinked struct {}
+func (e ErrorIdentityLinked) Error() string {
+	return "Identity is linked to another user"
+}
+
+type ErrorEmailRequired struct {}
+func (e ErrorEmailRequired) Error() string {
+	return "Identity provider didn't return email"
+}
+
+// ErrorConflict reports the field which value is already used by another user
+type ErrorConflict struct {
+	Field string `json:"field"`
+	Message string `json:"error"`
+}
+func (e ErrorConflict) Error() string {
+	return e.Message
+}
+
+type ErrorInvalidCsrf struct {}
+func (e ErrorInvalidCsrf) Error() string {
+	return "Invalid or missing CSRF token"
+}
+
+type ErrorRegistrationClosed struct {}
+func (e ErrorRegistrationClosed) Error() string {
+	return "Registration is closed"
+}
+
+type ErrorInvalidInvite struct {}
+func (e ErrorInvalidInvite) Error() string {
+	return "Invalid or expired invite code"
+}
+
+type ErrorInviteNotFound struct {}
+func (e ErrorInviteNotFound) Error() string {
+	return "Invite not found"
+}
+
+type ErrorPasswordPolicy struct {
+	Violations []string
+}
+func (e ErrorPasswordPolicy) Error() string {
+	return strings.Join(e.Violations, "; ")
+}
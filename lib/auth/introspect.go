@@ -0,0 +1,89 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+	"github.com/dzendmitry/rating-service/lib/general"
+)
+
+const (
+	INTROSPECT_URL = "introspect"
+	USER_STATUS_URL = "user-status"
+	// Services put the shared secret to the header, internal endpoints of auth-service aren't open to users
+	SERVICE_SECRET_HEADER = "X-Service-Secret"
+)
+
+func IntrospectUri() string {
+	return general.BASE_URL_V1 + INTROSPECT_URL
+}
+
+func UserStatusUri() string {
+	return general.BASE_URL_V1 + USER_STATUS_URL
+}
+
+// CheckServiceSecret tells whether the request comes from a service knowing the shared secret
+func CheckServiceSecret(req *http.Request, secret []byte) bool {
+	return len(secret) > 0 && subtle.ConstantTimeCompare([]byte(req.Header.Get(SERVICE_SECRET_HEADER)), secret) == 1
+}
+
+// Introspect checks sid, access token or api key. Unknown and expired credentials are reported as inactive, not as errors.
+func (a *Auth) Introspect(users IAuthDataSource, sessions IAuthDataSource, apiKeys IAuthDataSource, token string) (*Introspection, error) {
+	if isApiKey(token) {
+		return a.introspectApiKey(users, apiKeys, token)
+	}
+	if strings.Contains(token, ".") {
+		if !tokensEnabled() {
+			return &Introspection{}, nil
+		}
+		claims, err := VerifyAccessToken(token)
+		if err != nil {
+			return &Introspection{}, nil
+		}
+		return &Introspection{
+			Active: true,
+			Uid: bson.ObjectIdHex(claims.Uid),
+			SessionId: bson.ObjectIdHex(claims.SessionId),
+			Name: claims.Name,
+			Unverified: claims.Unverified,
+			Expires: time.Unix(claims.Expires, 0),
+		}, nil
+	}
+	var session Session
+	if err := sessions.FindOne(bson.M{SidKey: token}, &session); err != nil {
+		if err.Error() == "not found" {
+			return &Introspection{}, nil
+		}
+		return nil, err
+	}
+	if !session.active(time.Now()) {
+		return &Introspection{}, nil
+	}
+	touchSession(sessions, &session)
+	expires := session.Expires
+	if expires.IsZero() {
+		expires = session.Created.Add(sessionMaxAge)
+	}
+	return &Introspection{
+		Active: true,
+		Uid: session.Uid,
+		SessionId: session.Id,
+		Name: session.Name,
+		Unverified: session.Unverified,
+		Expires: expires,
+		Csrf: session.Csrf,
+	}, nil
+}
+
+// UserStatus tells services whether the user is active, disabled users and users scheduled for deletion aren't
+func (a *Auth) UserStatus(users IAuthDataSource, uid bson.ObjectId) (*UserStatus, error) {
+	n, err := users.Count(bson.M{"_id": uid, "disabled": bson.M{"$ne": true}, "deleteat": bson.M{"$exists": false},
+		"purging": bson.M{"$ne": true}})
+	if err != nil {
+		return nil, err
+	}
+	return &UserStatus{Active: n > 0}, nil
+}
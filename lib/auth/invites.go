@@ -0,0 +1,106 @@
+package auth
+
+import (
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+	"github.com/dzendmitry/rating-service/lib/general"
+)
+
+const (
+	REG_MODE_OPEN = "open"
+	REG_MODE_CLOSED = "closed"
+	REG_MODE_INVITE = "invite"
+
+	INVITE_CODE_SIZE = 10
+	INVITE_SHOWN_PREFIX = 6
+	INVITE_EXPIRES_DAYS = 7
+	// Unused invites a user may have at once, admins are not limited
+	INVITES_PER_USER = 10
+)
+
+func (a *Auth) CreateInvite(invites IAuthDataSource, current *Session, admin bool, data *InviteData) (*NewInvite, error) {
+	now := time.Now()
+	if !admin {
+		n, err := invites.Count(bson.M{"uid": current.Uid, "usedby": bson.M{"$exists": false}, "expires": bson.M{"$gt": now}})
+		if err != nil {
+			return nil, err
+		}
+		if n >= INVITES_PER_USER {
+			return nil, ErrorTooManyRequests{}
+		}
+	}
+	code, err := general.GetRandomToken(INVITE_CODE_SIZE)
+	if err != nil {
+		return nil, err
+	}
+	days := data.ExpiresIn
+	if days <= 0 {
+		days = INVITE_EXPIRES_DAYS
+	}
+	invite := Invite{
+		Id: bson.NewObjectId(),
+		Uid: current.Uid,
+		Prefix: code[:INVITE_SHOWN_PREFIX],
+		Hash: hashToken(code),
+		Created: now,
+		Expires: now.Add(time.Duration(days) * 24 * time.Hour),
+	}
+	if err := invites.Insert(invite); err != nil {
+		return nil, err
+	}
+	return &NewInvite{Invite: invite, Code: code}, nil
+}
+
+// Invites returns invites created by the user, admins may list all of them
+func (a *Auth) Invites(invites IAuthDataSource, current *Session, all bool) ([]Invite, error) {
+	selector := bson.M{"uid": current.Uid}
+	if all {
+		selector = bson.M{}
+	}
+	list := make([]Invite, 0)
+	if err := invites.FindRange(selector, []string{"-created"}, 0, 0, &list); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// RevokeInvite removes the unused invite, admins may revoke invites of any user
+func (a *Auth) RevokeInvite(invites IAuthDataSource, current *Session, admin bool, id bson.ObjectId) error {
+	selector := bson.M{"_id": id, "usedby": bson.M{"$exists": false}}
+	if !admin {
+		selector["uid"] = current.Uid
+	}
+	if err := invites.Remove(selector); err != nil {
+		if err.Error() == "not found" {
+			return ErrorInviteNotFound{}
+		}
+		return err
+	}
+	return nil
+}
+
+// RegisterInvited marks the invite code as used by the new user and registers it.
+// The code is released if the registration fails.
+func (a *Auth) RegisterInvited(users IAuthDataSource, invites IAuthDataSource, user *RegData, code string) error {
+	if code == "" {
+		return ErrorInvalidInvite{}
+	}
+	now := time.Now()
+	hash := hashToken(code)
+	if err := invites.Update(bson.M{"hash": hash, "usedby": bson.M{"$exists": false}, "expires": bson.M{"$gt": now}},
+		bson.M{"$set": bson.M{"usedby": user.Id, "used": now}}); err != nil {
+		if err.Error() == "not found" {
+			return ErrorInvalidInvite{}
+		}
+		return err
+	}
+	if err := a.Register(users, *user); err != nil {
+		if e := invites.Update(bson.M{"hash": hash, "usedby": user.Id},
+			bson.M{"$unset": bson.M{"usedby": "", "used": ""}}); e != nil {
+			a.log.Warnf("Error while releasing invite %s: %s", hash, e.Error())
+		}
+		return err
+	}
+	return nil
+}
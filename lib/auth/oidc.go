@@ -0,0 +1,547 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+	"github.com/dzendmitry/logger"
+	"github.com/dzendmitry/rating-service/lib/general"
+)
+
+const (
+	OIDC_STATE_SIZE = 32
+	OIDC_VERIFIER_SIZE = 32
+	OIDC_STATE_EXPIRES = 600
+	OIDC_REQUEST_TIMEOUT = 5
+	OIDC_DISCOVERY_PATH = "/.well-known/openid-configuration"
+	OIDC_TOKEN_ALG = "RS256"
+	// Keys are refetched for unknown kid not more often than this, providers rotate keys rarely
+	OIDC_JWKS_REFRESH = 60
+	// The cookie binds the state to the browser which started the flow
+	OIDC_BINDING_COOKIE = "oidc_binding"
+	// Names of created users must match reg.json name pattern
+	OIDC_NAME_MAX_LEN = 15
+	OIDC_NAME_ATTEMPTS = 5
+)
+
+var oidcNameCleaner = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
+// OidcProviderConfig describes an OpenID Connect provider. Endpoints are discovered from the issuer
+// unless they are set explicitly, e.g. for a local stand-in provider.
+type OidcProviderConfig struct {
+	Name string             `json:"name"`
+	Issuer string           `json:"issuer"`
+	ClientId string         `json:"client_id"`
+	ClientSecret string     `json:"client_secret"`
+	RedirectUrl string      `json:"redirect_url"`
+	Scopes []string         `json:"scopes"`
+	AuthorizationUrl string `json:"authorization_endpoint"`
+	TokenUrl string         `json:"token_endpoint"`
+	JwksUrl string          `json:"jwks_uri"`
+}
+
+type oidcDiscovery struct {
+	Issuer string           `json:"issuer"`
+	AuthorizationUrl string `json:"authorization_endpoint"`
+	TokenUrl string         `json:"token_endpoint"`
+	JwksUrl string          `json:"jwks_uri"`
+}
+
+type oidcJwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N string   `json:"n"`
+	E string   `json:"e"`
+}
+
+type oidcJwks struct {
+	Keys []oidcJwk `json:"keys"`
+}
+
+// oidcKeys are verification keys of a provider by kid
+type oidcKeys struct {
+	keys map[string]*rsa.PublicKey
+	fetched time.Time
+}
+
+type oidcTokenResponse struct {
+	IdToken string `json:"id_token"`
+	Error string   `json:"error"`
+}
+
+type oidcClaims struct {
+	Issuer string            `json:"iss"`
+	Subject string           `json:"sub"`
+	Audience json.RawMessage `json:"aud"`
+	Expires int64            `json:"exp"`
+	Nonce string             `json:"nonce"`
+	Email string             `json:"email"`
+	EmailVerified bool       `json:"email_verified"`
+	PreferredUsername string `json:"preferred_username"`
+}
+
+type OidcState struct {
+	Id bson.ObjectId   `bson:"_id"`
+	Hash string        `bson:"hash"`
+	Provider string    `bson:"provider"`
+	Verifier string    `bson:"verifier"`
+	Nonce string       `bson:"nonce"`
+	// Hash of the binding cookie value
+	Binding string     `bson:"binding"`
+	// The identity is linked to this user instead of login when it's set
+	LinkUid bson.ObjectId `bson:"linkuid,omitempty"`
+	Remember bool      `bson:"remember,omitempty"`
+	Created time.Time  `bson:"created"`
+}
+
+type Oidc struct {
+	providers map[string]*OidcProviderConfig
+	// New users are created only when registration is open, otherwise identities are only linked
+	allowSignup bool
+	// Keys of providers by name, they are replaced as a whole on refetch
+	keys map[string]*oidcKeys
+	mu sync.Mutex
+	httpClient *http.Client
+	log logger.ILogger
+}
+
+func LoadOidcProviders(path string) ([]OidcProviderConfig, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var configs []OidcProviderConfig
+	if err := json.NewDecoder(f).Decode(&configs); err != nil {
+		return nil, err
+	}
+	for _, c := range configs {
+		if c.Name == "" || c.Issuer == "" || c.ClientId == "" || c.RedirectUrl == "" {
+			return nil, errors.New(fmt.Sprintf("Oidc provider %s needs name, issuer, client_id and redirect_url", c.Name))
+		}
+	}
+	return configs, nil
+}
+
+func NewOidc(configs []OidcProviderConfig, allowSignup bool, log logger.ILogger) *Oidc {
+	providers := make(map[string]*OidcProviderConfig, len(configs))
+	for i := range configs {
+		c := configs[i]
+		if len(c.Scopes) == 0 {
+			c.Scopes = []string{"openid", "email", "profile"}
+		}
+		providers[c.Name] = &c
+	}
+	return &Oidc{
+		providers: providers,
+		allowSignup: allowSignup,
+		keys: make(map[string]*oidcKeys),
+		httpClient: &http.Client{Timeout: OIDC_REQUEST_TIMEOUT * time.Second},
+		log: log,
+	}
+}
+
+// provider returns a copy of the config with discovered endpoints. The discovery request is made
+// without the lock, so a slow provider doesn't block flows of others.
+func (o *Oidc) provider(name string) (*OidcProviderConfig, error) {
+	o.mu.Lock()
+	p, ok := o.providers[name]
+	var c OidcProviderConfig
+	if ok {
+		c = *p
+	}
+	o.mu.Unlock()
+	if !ok {
+		return nil, ErrorUnknownProvider{}
+	}
+	if c.AuthorizationUrl != "" && c.TokenUrl != "" && c.JwksUrl != "" {
+		return &c, nil
+	}
+	body, err := general.GetPage(strings.TrimRight(c.Issuer, "/") + OIDC_DISCOVERY_PATH, true)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Oidc discovery of %s failed: %s", name, err.Error()))
+	}
+	var d oidcDiscovery
+	if err := json.Unmarshal(body, &d); err != nil {
+		return nil, errors.New(fmt.Sprintf("Oidc discovery of %s failed: %s", name, err.Error()))
+	}
+	if d.Issuer != c.Issuer || d.AuthorizationUrl == "" || d.TokenUrl == "" || d.JwksUrl == "" {
+		return nil, errors.New(fmt.Sprintf("Oidc discovery of %s returned invalid configuration", name))
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if p.AuthorizationUrl == "" {
+		p.AuthorizationUrl = d.AuthorizationUrl
+	}
+	if p.TokenUrl == "" {
+		p.TokenUrl = d.TokenUrl
+	}
+	if p.JwksUrl == "" {
+		p.JwksUrl = d.JwksUrl
+	}
+	c = *p
+	return &c, nil
+}
+
+func pkceChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// AuthUrl starts the authorization code flow and returns the provider url the user is redirected to
+// and the binding value which is set to OIDC_BINDING_COOKIE. When current session is passed,
+// the identity is linked to its user.
+func (o *Oidc) AuthUrl(states IAuthDataSource, providerName string, current *Session, remember bool) (string, string, error) {
+	p, err := o.provider(providerName)
+	if err != nil {
+		return "", "", err
+	}
+	var state, verifier, nonce, binding string
+	for _, t := range []*string{&state, &verifier, &nonce, &binding} {
+		if *t, err = general.GetRandomToken(OIDC_STATE_SIZE); err != nil {
+			return "", "", err
+		}
+	}
+	s := OidcState{
+		Id: bson.NewObjectId(),
+		Hash: hashToken(state),
+		Provider: p.Name,
+		Verifier: verifier,
+		Nonce: nonce,
+		Binding: hashToken(binding),
+		Remember: remember,
+		Created: time.Now(),
+	}
+	if current != nil {
+		s.LinkUid = current.Uid
+	}
+	if err := states.Insert(s); err != nil {
+		return "", "", err
+	}
+	params := url.Values{}
+	params.Set("response_type", "code")
+	params.Set("client_id", p.ClientId)
+	params.Set("redirect_uri", p.RedirectUrl)
+	params.Set("scope", strings.Join(p.Scopes, " "))
+	params.Set("state", state)
+	params.Set("nonce", nonce)
+	params.Set("code_challenge", pkceChallenge(verifier))
+	params.Set("code_challenge_method", "S256")
+	sep := "?"
+	if strings.Contains(p.AuthorizationUrl, "?") {
+		sep = "&"
+	}
+	return p.AuthorizationUrl + sep + params.Encode(), binding, nil
+}
+
+func (o *Oidc) exchange(p *OidcProviderConfig, code, verifier string) (string, error) {
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.RedirectUrl)
+	form.Set("client_id", p.ClientId)
+	form.Set("code_verifier", verifier)
+	if p.ClientSecret != "" {
+		form.Set("client_secret", p.ClientSecret)
+	}
+	res, err := o.httpClient.PostForm(p.TokenUrl, form)
+	if err != nil {
+		return "", errors.New(fmt.Sprintf("Oidc token request to %s failed: %s", p.Name, err.Error()))
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(io.LimitReader(res.Body, general.BODY_BUFFER))
+	if err != nil {
+		return "", err
+	}
+	var t oidcTokenResponse
+	if err := json.Unmarshal(body, &t); err != nil {
+		return "", errors.New(fmt.Sprintf("Unmarshalling oidc token response from %s: %s", p.Name, err.Error()))
+	}
+	if res.StatusCode != http.StatusOK || t.IdToken == "" {
+		return "", errors.New(fmt.Sprintf("Oidc token request to %s failed: %s %s", p.Name, res.Status, t.Error))
+	}
+	return t.IdToken, nil
+}
+
+func (k *oidcJwk) publicKey() (*rsa.PublicKey, error) {
+	n, err := base64.RawURLEncoding.DecodeString(k.N)
+	if err != nil {
+		return nil, err
+	}
+	e, err := base64.RawURLEncoding.DecodeString(k.E)
+	if err != nil {
+		return nil, err
+	}
+	if len(e) == 0 || len(e) > 4 {
+		return nil, errors.New("Invalid rsa exponent")
+	}
+	exp := 0
+	for _, b := range e {
+		exp = exp << 8 | int(b)
+	}
+	return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: exp}, nil
+}
+
+// verificationKey returns the key of the provider by kid, keys are fetched from jwks_uri on the first use
+// and refetched when the provider starts signing with a new key
+func (o *Oidc) verificationKey(p *OidcProviderConfig, kid string) (*rsa.PublicKey, error) {
+	o.mu.Lock()
+	k := o.keys[p.Name]
+	o.mu.Unlock()
+	if k != nil {
+		if key, ok := k.keys[kid]; ok {
+			return key, nil
+		}
+		if time.Since(k.fetched) < OIDC_JWKS_REFRESH * time.Second {
+			return nil, ErrorInvalidToken{}
+		}
+	}
+	body, err := general.GetPage(p.JwksUrl, false)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Oidc keys request to %s failed: %s", p.Name, err.Error()))
+	}
+	var set oidcJwks
+	if err := json.Unmarshal(body, &set); err != nil {
+		return nil, errors.New(fmt.Sprintf("Unmarshalling oidc keys of %s: %s", p.Name, err.Error()))
+	}
+	k = &oidcKeys{keys: make(map[string]*rsa.PublicKey, len(set.Keys)), fetched: time.Now()}
+	for i := range set.Keys {
+		j := &set.Keys[i]
+		if j.Kty != "RSA" || (j.Use != "" && j.Use != "sig") {
+			continue
+		}
+		key, err := j.publicKey()
+		if err != nil {
+			o.log.Warnf("Skipping invalid oidc key %s of %s: %s", j.Kid, p.Name, err.Error())
+			continue
+		}
+		k.keys[j.Kid] = key
+	}
+	o.mu.Lock()
+	o.keys[p.Name] = k
+	o.mu.Unlock()
+	if key, ok := k.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, ErrorInvalidToken{}
+}
+
+// verifyIdToken checks the signature of the id token with the provider keys and its claims
+func (o *Oidc) verifyIdToken(p *OidcProviderConfig, idToken, nonce string) (*oidcClaims, error) {
+	parts := strings.Split(idToken, ".")
+	if len(parts) != 3 {
+		return nil, ErrorInvalidToken{}
+	}
+	var header tokenHeader
+	if err := decodeTokenPart(parts[0], &header); err != nil || header.Alg != OIDC_TOKEN_ALG {
+		return nil, ErrorInvalidToken{}
+	}
+	key, err := o.verificationKey(p, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrorInvalidToken{}
+	}
+	sum := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, sum[:], sig); err != nil {
+		return nil, ErrorInvalidToken{}
+	}
+	var claims oidcClaims
+	if err := decodeTokenPart(parts[1], &claims); err != nil {
+		return nil, ErrorInvalidToken{}
+	}
+	if claims.Issuer != p.Issuer || claims.Subject == "" || claims.Nonce != nonce {
+		return nil, ErrorInvalidToken{}
+	}
+	if time.Now().Unix() >= claims.Expires {
+		return nil, ErrorInvalidToken{}
+	}
+	var aud string
+	var auds []string
+	if json.Unmarshal(claims.Audience, &aud) == nil {
+		auds = []string{aud}
+	} else if json.Unmarshal(claims.Audience, &auds) != nil {
+		return nil, ErrorInvalidToken{}
+	}
+	for _, a := range auds {
+		if a == p.ClientId {
+			return &claims, nil
+		}
+	}
+	return nil, ErrorInvalidToken{}
+}
+
+// Callback finishes the flow: it exchanges the code, finds, links or creates the user and returns a new sid.
+// Binding is the value of OIDC_BINDING_COOKIE, the state started in another browser is rejected.
+func (o *Oidc) Callback(a *Auth, users IAuthDataSource, sessions IAuthDataSource, states IAuthDataSource,
+	state, binding, code string, client ClientInfo) (string, error) {
+	sid, user, err := o.callback(a, users, sessions, states, state, binding, code, client)
+	if user != nil {
+		a.Audit.Record(AUDIT_LOGIN_OIDC, user.Id, user.Name, client, err)
+	} else {
+		a.Audit.Record(AUDIT_LOGIN_OIDC, "", "", client, err)
+	}
+	return sid, err
+}
+
+func (o *Oidc) callback(a *Auth, users IAuthDataSource, sessions IAuthDataSource, states IAuthDataSource,
+	state, binding, code string, client ClientInfo) (string, *RegData, error) {
+	var s OidcState
+	if err := states.FindOne(bson.M{"hash": hashToken(state)}, &s); err != nil {
+		if err.Error() == "not found" {
+			return "", nil, ErrorInvalidToken{}
+		}
+		return "", nil, err
+	}
+	if binding == "" || subtle.ConstantTimeCompare([]byte(hashToken(binding)), []byte(s.Binding)) != 1 {
+		return "", nil, ErrorInvalidToken{}
+	}
+	if err := states.Remove(bson.M{"_id": s.Id}); err != nil {
+		if err.Error() == "not found" {
+			return "", nil, ErrorInvalidToken{}
+		}
+		return "", nil, err
+	}
+	if time.Since(s.Created) > OIDC_STATE_EXPIRES * time.Second {
+		return "", nil, ErrorInvalidToken{}
+	}
+	p, err := o.provider(s.Provider)
+	if err != nil {
+		return "", nil, err
+	}
+	idToken, err := o.exchange(p, code, s.Verifier)
+	if err != nil {
+		return "", nil, err
+	}
+	claims, err := o.verifyIdToken(p, idToken, s.Nonce)
+	if err != nil {
+		return "", nil, err
+	}
+
+	identity := Identity{Provider: p.Name, Subject: claims.Subject}
+	user, err := o.findOrCreateUser(a, users, identity, claims, s.LinkUid)
+	if err != nil {
+		return "", nil, err
+	}
+	if user.Disabled {
+		return "", user, ErrorUserDisabled{}
+	}
+	if err := a.cancelDeletion(users, user); err != nil {
+		return "", user, err
+	}
+	sid, err := a.newSession(sessions, user, client, s.Remember)
+	return sid, user, err
+}
+
+func (o *Oidc) findOrCreateUser(a *Auth, users IAuthDataSource, identity Identity, claims *oidcClaims,
+	linkUid bson.ObjectId) (*RegData, error) {
+	var user RegData
+	err := users.FindOne(bson.M{"identities": bson.M{"$elemMatch": bson.M{"provider": identity.Provider, "subject": identity.Subject}}}, &user)
+	if err == nil {
+		if linkUid != "" && linkUid != user.Id {
+			return nil, ErrorIdentityLinked{}
+		}
+		return &user, nil
+	}
+	if err.Error() != "not found" {
+		return nil, err
+	}
+
+	// Explicit linking from the logged in account or implicit linking by the email confirmed
+	// both by the provider and by the account owner
+	selector := bson.M{}
+	if linkUid != "" {
+		selector["_id"] = linkUid
+	} else if claims.Email != "" && claims.EmailVerified {
+		selector["email"] = claims.Email
+		selector["unverified"] = bson.M{"$ne": true}
+	}
+	if len(selector) > 0 {
+		if err := users.FindOne(selector, &user); err == nil {
+			if err := users.Update(bson.M{"_id": user.Id}, bson.M{"$push": bson.M{"identities": identity}}); err != nil {
+				return nil, err
+			}
+			return &user, nil
+		} else if err.Error() != "not found" {
+			return nil, err
+		} else if linkUid != "" {
+			return nil, ErrorUsersDoesntExist{}
+		}
+	}
+	if claims.Email != "" {
+		// The email belongs to another account which has to be linked explicitly after login
+		n, err := users.Count(bson.M{"email": claims.Email})
+		if err != nil {
+			return nil, err
+		}
+		if n > 0 {
+			return nil, ErrorUserExists{}
+		}
+	}
+
+	if !o.allowSignup {
+		return nil, ErrorRegistrationClosed{}
+	}
+	if claims.Email == "" {
+		return nil, ErrorEmailRequired{}
+	}
+	base := claims.PreferredUsername
+	if base == "" {
+		base = strings.Split(claims.Email, "@")[0]
+	}
+	base = oidcNameCleaner.ReplaceAllString(base, "")
+	if len(base) < 3 {
+		base = "user" + base
+	}
+	if len(base) > OIDC_NAME_MAX_LEN {
+		base = base[:OIDC_NAME_MAX_LEN]
+	}
+	name := base
+	for i := 0; i < OIDC_NAME_ATTEMPTS; i++ {
+		user = RegData{
+			Id: bson.NewObjectId(),
+			Name: name,
+			Email: claims.Email,
+			Unverified: !claims.EmailVerified,
+			Identities: []Identity{identity},
+		}
+		err := a.Register(users, user)
+		if err == nil {
+			return &user, nil
+		}
+		if _, ok := err.(ErrorUserExists); !ok {
+			return nil, err
+		}
+		// The email could be taken concurrently
+		if n, _ := users.Count(bson.M{"email": claims.Email}); n > 0 {
+			return nil, ErrorUserExists{}
+		}
+		suffix, err := general.GetRandomToken(2)
+		if err != nil {
+			return nil, err
+		}
+		name = base + suffix
+	}
+	return nil, ErrorUserExists{}
+}
@@ -0,0 +1,468 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+	"github.com/dzendmitry/logger"
+)
+
+const (
+	testClientId = "rating-service"
+	testRedirectUrl = "http://localhost/api/v1/oidc/callback"
+	testKid = "key-1"
+)
+
+type fakeGrant struct {
+	challenge string
+	claims oidcClaims
+}
+
+// fakeProvider serves discovery, jwks and token endpoints of an OpenID Connect provider
+type fakeProvider struct {
+	srv *httptest.Server
+	key *rsa.PrivateKey
+	mu sync.Mutex
+	grants map[string]fakeGrant
+}
+
+var (
+	testKeyOnce sync.Once
+	testKeys [2]*rsa.PrivateKey
+)
+
+// providerKeys returns the key of the fake provider and a key unknown to it, generated once for all tests
+func providerKeys(t *testing.T) (*rsa.PrivateKey, *rsa.PrivateKey) {
+	testKeyOnce.Do(func() {
+		for i := range testKeys {
+			k, err := rsa.GenerateKey(rand.Reader, 2048)
+			if err != nil {
+				t.Fatal(err)
+			}
+			testKeys[i] = k
+		}
+	})
+	return testKeys[0], testKeys[1]
+}
+
+func newFakeProvider(t *testing.T) *fakeProvider {
+	key, _ := providerKeys(t)
+	f := &fakeProvider{key: key, grants: make(map[string]fakeGrant)}
+	mux := http.NewServeMux()
+	mux.HandleFunc(OIDC_DISCOVERY_PATH, func(w http.ResponseWriter, req *http.Request) {
+		f.writeJson(w, http.StatusOK, oidcDiscovery{
+			Issuer: f.srv.URL,
+			AuthorizationUrl: f.srv.URL + "/authorize",
+			TokenUrl: f.srv.URL + "/token",
+			JwksUrl: f.srv.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, req *http.Request) {
+		e := big.NewInt(int64(f.key.E)).Bytes()
+		f.writeJson(w, http.StatusOK, oidcJwks{Keys: []oidcJwk{{
+			Kty: "RSA",
+			Kid: testKid,
+			Use: "sig",
+			N: base64.RawURLEncoding.EncodeToString(f.key.N.Bytes()),
+			E: base64.RawURLEncoding.EncodeToString(e),
+		}}})
+	})
+	mux.HandleFunc("/token", f.token)
+	f.srv = httptest.NewServer(mux)
+	return f
+}
+
+func (f *fakeProvider) writeJson(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func (f *fakeProvider) token(w http.ResponseWriter, req *http.Request) {
+	f.mu.Lock()
+	grant, ok := f.grants[req.FormValue("code")]
+	delete(f.grants, req.FormValue("code"))
+	f.mu.Unlock()
+	if !ok || req.FormValue("grant_type") != "authorization_code" || req.FormValue("client_id") != testClientId ||
+		req.FormValue("redirect_uri") != testRedirectUrl {
+		f.writeJson(w, http.StatusBadRequest, oidcTokenResponse{Error: "invalid_grant"})
+		return
+	}
+	if pkceChallenge(req.FormValue("code_verifier")) != grant.challenge {
+		f.writeJson(w, http.StatusBadRequest, oidcTokenResponse{Error: "invalid_grant"})
+		return
+	}
+	f.writeJson(w, http.StatusOK, oidcTokenResponse{IdToken: f.sign(f.key, testKid, grant.claims)})
+}
+
+func (f *fakeProvider) sign(key *rsa.PrivateKey, kid string, claims oidcClaims) string {
+	header, _ := encodeTokenPart(tokenHeader{Alg: OIDC_TOKEN_ALG, Typ: "JWT", Kid: kid})
+	payload, _ := encodeTokenPart(claims)
+	sum := sha256.Sum256([]byte(header + "." + payload))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, sum[:])
+	if err != nil {
+		panic(err)
+	}
+	return header + "." + payload + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+// authorize plays the user who approved the request at the provider, it returns the code
+// issued for the claims and the state to pass to the callback
+func (f *fakeProvider) authorize(t *testing.T, location string, claims oidcClaims) (string, string) {
+	u, err := url.Parse(location)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	if !strings.HasPrefix(location, f.srv.URL + "/authorize?") {
+		t.Fatalf("Unexpected authorization url %s", location)
+	}
+	if q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "" {
+		t.Fatalf("Authorization url has no pkce challenge: %s", location)
+	}
+	if claims.Nonce == "" {
+		claims.Nonce = q.Get("nonce")
+	}
+	code := bson.NewObjectId().Hex()
+	f.mu.Lock()
+	f.grants[code] = fakeGrant{challenge: q.Get("code_challenge"), claims: claims}
+	f.mu.Unlock()
+	return code, q.Get("state")
+}
+
+func (f *fakeProvider) claims(subject, email string, verified bool) oidcClaims {
+	return oidcClaims{
+		Issuer: f.srv.URL,
+		Subject: subject,
+		Audience: json.RawMessage(`"` + testClientId + `"`),
+		Expires: time.Now().Add(time.Hour).Unix(),
+		Email: email,
+		EmailVerified: verified,
+		PreferredUsername: strings.Split(email, "@")[0],
+	}
+}
+
+type oidcEnv struct {
+	provider *fakeProvider
+	oidc *Oidc
+	auth *Auth
+	users, sessions, states *memSource
+}
+
+func newOidcEnv(t *testing.T, allowSignup bool) *oidcEnv {
+	log := logger.InitFileLogger("AUTH-TEST", "")
+	f := newFakeProvider(t)
+	return &oidcEnv{
+		provider: f,
+		oidc: NewOidc([]OidcProviderConfig{{
+			Name: "fake",
+			Issuer: f.srv.URL,
+			ClientId: testClientId,
+			RedirectUrl: testRedirectUrl,
+		}}, allowSignup, log),
+		auth: &Auth{Audit: NewAudit(newMemSource(), time.Hour, log), log: log},
+		users: newMemSource("name", "email"),
+		sessions: newMemSource(),
+		states: newMemSource(),
+	}
+}
+
+func (e *oidcEnv) close() {
+	e.provider.srv.Close()
+}
+
+func (e *oidcEnv) start(t *testing.T, current *Session) (string, string) {
+	location, binding, err := e.oidc.AuthUrl(e.states, "fake", current, false)
+	if err != nil {
+		t.Fatalf("AuthUrl failed: %+v", err)
+	}
+	return location, binding
+}
+
+func (e *oidcEnv) callback(state, binding, code string) (string, error) {
+	return e.oidc.Callback(e.auth, e.users, e.sessions, e.states, state, binding, code, ClientInfo{Ip: "127.0.0.1"})
+}
+
+func (e *oidcEnv) user(t *testing.T, selector bson.M) *RegData {
+	var user RegData
+	if err := e.users.FindOne(selector, &user); err != nil {
+		t.Fatalf("User %v isn't found: %+v", selector, err)
+	}
+	return &user
+}
+
+func TestOidcFlow(t *testing.T) {
+	_, unknownKey := providerKeys(t)
+	for _, c := range []struct {
+		name string
+		// tamper changes the request before the callback
+		tamper func(e *oidcEnv, claims *oidcClaims, state, binding *string)
+		// token replaces the id token issued by the provider
+		token func(e *oidcEnv, claims oidcClaims) string
+		err error
+	}{
+		{name: "signup"},
+		{
+			name: "unknown state",
+			tamper: func(e *oidcEnv, claims *oidcClaims, state, binding *string) { *state = "unknown" },
+			err: ErrorInvalidToken{},
+		},
+		{
+			name: "state of another browser",
+			tamper: func(e *oidcEnv, claims *oidcClaims, state, binding *string) { *binding = "another" },
+			err: ErrorInvalidToken{},
+		},
+		{
+			name: "missing binding cookie",
+			tamper: func(e *oidcEnv, claims *oidcClaims, state, binding *string) { *binding = "" },
+			err: ErrorInvalidToken{},
+		},
+		{
+			name: "expired state",
+			tamper: func(e *oidcEnv, claims *oidcClaims, state, binding *string) {
+				e.states.UpdateAll(bson.M{}, bson.M{"$set": bson.M{"created": time.Now().Add(-time.Hour)}})
+			},
+			err: ErrorInvalidToken{},
+		},
+		{
+			name: "wrong pkce verifier",
+			tamper: func(e *oidcEnv, claims *oidcClaims, state, binding *string) {
+				e.states.UpdateAll(bson.M{}, bson.M{"$set": bson.M{"verifier": "another"}})
+			},
+			err: errOther,
+		},
+		{
+			name: "wrong nonce",
+			tamper: func(e *oidcEnv, claims *oidcClaims, state, binding *string) { claims.Nonce = "another" },
+			err: ErrorInvalidToken{},
+		},
+		{
+			name: "wrong audience",
+			tamper: func(e *oidcEnv, claims *oidcClaims, state, binding *string) {
+				claims.Audience = json.RawMessage(`["another"]`)
+			},
+			err: ErrorInvalidToken{},
+		},
+		{
+			name: "wrong issuer",
+			tamper: func(e *oidcEnv, claims *oidcClaims, state, binding *string) { claims.Issuer = "http://another" },
+			err: ErrorInvalidToken{},
+		},
+		{
+			name: "expired token",
+			tamper: func(e *oidcEnv, claims *oidcClaims, state, binding *string) {
+				claims.Expires = time.Now().Add(-time.Minute).Unix()
+			},
+			err: ErrorInvalidToken{},
+		},
+		{
+			name: "signed by unknown key",
+			token: func(e *oidcEnv, claims oidcClaims) string { return e.provider.sign(unknownKey, testKid, claims) },
+			err: ErrorInvalidToken{},
+		},
+		{
+			name: "unknown kid",
+			token: func(e *oidcEnv, claims oidcClaims) string { return e.provider.sign(e.provider.key, "key-2", claims) },
+			err: ErrorInvalidToken{},
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			e := newOidcEnv(t, true)
+			defer e.close()
+			location, binding := e.start(t, nil)
+			claims := e.provider.claims("subject-1", "alice@example.com", true)
+			state := ""
+			if c.tamper != nil {
+				// The nonce is taken from the authorization url when the case doesn't set it
+				c.tamper(e, &claims, &state, &binding)
+			}
+			code, issued := e.provider.authorize(t, location, claims)
+			if state == "" {
+				state = issued
+			}
+			var sid string
+			var err error
+			if c.token != nil {
+				// Tokens are checked the same way, the callback just receives them from the token endpoint
+				p, perr := e.oidc.provider("fake")
+				if perr != nil {
+					t.Fatal(perr)
+				}
+				claims.Nonce = "nonce"
+				_, err = e.oidc.verifyIdToken(p, c.token(e, claims), "nonce")
+			} else {
+				sid, err = e.callback(state, binding, code)
+			}
+			if !sameError(err, c.err) {
+				t.Fatalf("Expected error %#v, got %#v", c.err, err)
+			}
+			if c.err != nil {
+				return
+			}
+			if sid == "" {
+				t.Fatal("Sid is empty")
+			}
+			user := e.user(t, bson.M{"email": "alice@example.com"})
+			if user.Name != "alice" || user.Password != "" || user.Unverified {
+				t.Fatalf("Unexpected user %+v", user)
+			}
+			if len(user.Identities) != 1 || user.Identities[0] != (Identity{Provider: "fake", Subject: "subject-1"}) {
+				t.Fatalf("Unexpected identities %+v", user.Identities)
+			}
+			// The state is used once
+			if _, err := e.callback(state, binding, code); !sameError(err, ErrorInvalidToken{}) {
+				t.Fatalf("Reused state is accepted: %#v", err)
+			}
+		})
+	}
+}
+
+func TestOidcLinking(t *testing.T) {
+	for _, c := range []struct {
+		name string
+		existing *RegData
+		// The identity is linked from the session of the existing user
+		explicit bool
+		email string
+		emailVerified bool
+		allowSignup bool
+		err error
+		// Name of the user who gets the identity
+		linked string
+	}{
+		{
+			name: "implicit by verified email",
+			existing: &RegData{Name: "alice", Email: "alice@example.com"},
+			email: "alice@example.com",
+			emailVerified: true,
+			linked: "alice",
+		},
+		{
+			name: "no implicit linking to unverified account",
+			existing: &RegData{Name: "alice", Email: "alice@example.com", Unverified: true},
+			email: "alice@example.com",
+			emailVerified: true,
+			allowSignup: true,
+			err: ErrorUserExists{},
+		},
+		{
+			name: "no implicit linking by email unverified by provider",
+			existing: &RegData{Name: "alice", Email: "alice@example.com"},
+			email: "alice@example.com",
+			allowSignup: true,
+			err: ErrorUserExists{},
+		},
+		{
+			name: "explicit to unverified account",
+			existing: &RegData{Name: "alice", Email: "alice@example.com", Unverified: true},
+			explicit: true,
+			email: "other@example.com",
+			linked: "alice",
+		},
+		{
+			name: "signup is closed",
+			email: "bob@example.com",
+			emailVerified: true,
+			err: ErrorRegistrationClosed{},
+		},
+		{
+			name: "signup without email",
+			allowSignup: true,
+			err: ErrorEmailRequired{},
+		},
+		{
+			name: "signup",
+			existing: &RegData{Name: "alice", Email: "alice@example.com"},
+			email: "carol@example.com",
+			emailVerified: true,
+			allowSignup: true,
+			linked: "carol",
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			e := newOidcEnv(t, c.allowSignup)
+			defer e.close()
+			var current *Session
+			if c.existing != nil {
+				c.existing.Id = bson.NewObjectId()
+				if err := e.users.Insert(c.existing); err != nil {
+					t.Fatal(err)
+				}
+				if c.explicit {
+					current = &Session{Uid: c.existing.Id, Name: c.existing.Name}
+				}
+			}
+			location, binding := e.start(t, current)
+			code, state := e.provider.authorize(t, location, e.provider.claims("subject-1", c.email, c.emailVerified))
+			_, err := e.callback(state, binding, code)
+			if !sameError(err, c.err) {
+				t.Fatalf("Expected error %#v, got %#v", c.err, err)
+			}
+			if c.err != nil {
+				if n, _ := e.users.Count(bson.M{"identities": bson.M{"$elemMatch": bson.M{"subject": "subject-1"}}}); n != 0 {
+					t.Fatal("The identity is linked on failure")
+				}
+				return
+			}
+			user := e.user(t, bson.M{"identities": bson.M{"$elemMatch": bson.M{"provider": "fake", "subject": "subject-1"}}})
+			if user.Name != c.linked {
+				t.Fatalf("The identity is linked to %s instead of %s", user.Name, c.linked)
+			}
+		})
+	}
+}
+
+func TestOidcIdentityLinkedToAnotherUser(t *testing.T) {
+	e := newOidcEnv(t, false)
+	defer e.close()
+	owner := RegData{Id: bson.NewObjectId(), Name: "alice", Email: "alice@example.com",
+		Identities: []Identity{{Provider: "fake", Subject: "subject-1"}}}
+	other := RegData{Id: bson.NewObjectId(), Name: "bob", Email: "bob@example.com"}
+	for _, u := range []RegData{owner, other} {
+		if err := e.users.Insert(u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	location, binding := e.start(t, &Session{Uid: other.Id, Name: other.Name})
+	code, state := e.provider.authorize(t, location, e.provider.claims("subject-1", "alice@example.com", true))
+	if _, err := e.callback(state, binding, code); !sameError(err, ErrorIdentityLinked{}) {
+		t.Fatalf("Expected ErrorIdentityLinked, got %#v", err)
+	}
+}
+
+// errOther stands for errors which are not typed, e.g. failures of requests to the provider
+var errOther = otherError{}
+
+type otherError struct{}
+
+func (e otherError) Error() string {
+	return "other"
+}
+
+func sameError(err, expected error) bool {
+	if expected == errOther {
+		return err != nil && !isTypedError(err)
+	}
+	return err == expected
+}
+
+func isTypedError(err error) bool {
+	switch err.(type) {
+	case ErrorInvalidToken, ErrorUserExists, ErrorRegistrationClosed, ErrorEmailRequired, ErrorIdentityLinked:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,45 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+	"github.com/dzendmitry/rating-service/lib/general"
+)
+
+const (
+	// Length of the hex encoded unsalted md5 hashes stored by the first versions of the service
+	LEGACY_HASH_LEN = 32
+	BCRYPT_PREFIX = "$2"
+)
+
+func isLegacyHash(hash string) bool {
+	return len(hash) == LEGACY_HASH_LEN && !strings.HasPrefix(hash, BCRYPT_PREFIX)
+}
+
+// HashPassword returns bcrypt hash of the password. Algorithm and cost are encoded in the result.
+func (a *Auth) HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), a.passwordCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// CheckPassword compares the password with the stored hash. The second value reports
+// that the hash is outdated (legacy md5 or another cost) and should be replaced.
+func (a *Auth) CheckPassword(hash, password string) (bool, bool) {
+	if isLegacyHash(hash) {
+		ok := subtle.ConstantTimeCompare([]byte(hash), []byte(general.GetHash(password))) == 1
+		return ok, ok
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)); err != nil {
+		return false, false
+	}
+	cost, err := bcrypt.Cost([]byte(hash))
+	if err != nil {
+		return true, true
+	}
+	return true, cost != a.passwordCost
+}
@@ -0,0 +1,103 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"gopkg.in/mgo.v2/bson"
+	"github.com/dzendmitry/logger"
+	"github.com/dzendmitry/rating-service/lib/general"
+)
+
+func bcryptHash(t *testing.T, password string, cost int) string {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), cost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(hash)
+}
+
+func TestCheckPassword(t *testing.T) {
+	a := &Auth{passwordCost: bcrypt.MinCost}
+	for _, c := range []struct {
+		name string
+		hash string
+		password string
+		ok bool
+		rehash bool
+	}{
+		{"bcrypt", bcryptHash(t, "secret", bcrypt.MinCost), "secret", true, false},
+		{"bcrypt wrong password", bcryptHash(t, "secret", bcrypt.MinCost), "Secret", false, false},
+		{"bcrypt of another cost", bcryptHash(t, "secret", bcrypt.MinCost + 1), "secret", true, true},
+		{"legacy md5", general.GetHash("secret"), "secret", true, true},
+		{"legacy md5 wrong password", general.GetHash("secret"), "Secret", false, false},
+		{"legacy md5 uppercase", strings.ToUpper(general.GetHash("secret")), "secret", false, false},
+		{"no password", "", "", false, false},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			ok, rehash := a.CheckPassword(c.hash, c.password)
+			if ok != c.ok || rehash != c.rehash {
+				t.Fatalf("Expected %v %v, got %v %v", c.ok, c.rehash, ok, rehash)
+			}
+		})
+	}
+}
+
+func TestHashPassword(t *testing.T) {
+	a := &Auth{passwordCost: bcrypt.MinCost}
+	hash, err := a.HashPassword("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(hash, BCRYPT_PREFIX) || isLegacyHash(hash) {
+		t.Fatalf("Unexpected hash %s", hash)
+	}
+	if cost, err := bcrypt.Cost([]byte(hash)); err != nil || cost != bcrypt.MinCost {
+		t.Fatalf("Unexpected cost %d %v", cost, err)
+	}
+	if ok, rehash := a.CheckPassword(hash, "secret"); !ok || rehash {
+		t.Fatalf("The hash isn't accepted: %v %v", ok, rehash)
+	}
+}
+
+func TestLoginRehashesPassword(t *testing.T) {
+	log := logger.InitFileLogger("AUTH-TEST", "")
+	for _, c := range []struct {
+		name string
+		hash string
+		rehashed bool
+	}{
+		{"legacy md5", general.GetHash("secret"), true},
+		{"bcrypt of another cost", bcryptHash(t, "secret", bcrypt.MinCost + 1), true},
+		{"bcrypt", bcryptHash(t, "secret", bcrypt.MinCost), false},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			a := &Auth{
+				passwordCost: bcrypt.MinCost,
+				Throttle: NewThrottle(NewMemoryAttemptsStore(), newMemSource(), log),
+				Audit: NewAudit(newMemSource(), time.Hour, log),
+				log: log,
+			}
+			users := newMemSource("name")
+			uid := bson.NewObjectId()
+			if err := users.Insert(RegData{Id: uid, Name: "alice", Password: c.hash}); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := a.Auth(users, newMemSource(), &AuthData{Name: "alice", Password: "secret"}, ClientInfo{}); err != nil {
+				t.Fatalf("Login failed: %+v", err)
+			}
+			var user RegData
+			if err := users.FindOne(bson.M{"_id": uid}, &user); err != nil {
+				t.Fatal(err)
+			}
+			if (user.Password != c.hash) != c.rehashed {
+				t.Fatalf("Expected rehash %v, the hash is %s", c.rehashed, user.Password)
+			}
+			if ok, rehash := a.CheckPassword(user.Password, "secret"); !ok || rehash {
+				t.Fatalf("The stored hash isn't current: %v %v", ok, rehash)
+			}
+		})
+	}
+}
@@ -0,0 +1,140 @@
+package auth
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+)
+
+const (
+	PASSWORD_MIN_LENGTH = 8
+
+	CLASS_LOWER = "lower"
+	CLASS_UPPER = "upper"
+	CLASS_DIGIT = "digit"
+	CLASS_SYMBOL = "symbol"
+
+	// Shorter prefixes of the breached list would reject too many passwords
+	BREACHED_MIN_PREFIX = 5
+	// Field of the password in registration and reset requests
+	PASSWORD_FIELD = "password"
+)
+
+var passwordClasses = map[string]func(rune) bool{
+	CLASS_LOWER: unicode.IsLower,
+	CLASS_UPPER: unicode.IsUpper,
+	CLASS_DIGIT: unicode.IsDigit,
+	CLASS_SYMBOL: func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && !unicode.IsSpace(r)
+	},
+}
+
+type PasswordPolicy struct {
+	MinLength int
+	Classes []string
+	ForbidName bool
+	// Upper case hex SHA-1 prefixes of breached passwords grouped by the prefix length
+	breached map[int]map[string]struct{}
+}
+
+func ParsePasswordClasses(s string) ([]string, error) {
+	classes := make([]string, 0)
+	for _, c := range strings.Split(s, ",") {
+		c = strings.TrimSpace(c)
+		if c == "" {
+			continue
+		}
+		if _, ok := passwordClasses[c]; !ok {
+			return nil, errors.New(fmt.Sprintf("Unknown password character class: %s", c))
+		}
+		classes = append(classes, c)
+	}
+	return classes, nil
+}
+
+// LoadBreached reads the file with one SHA-1 prefix per line. Anything after ':' is ignored,
+// so files in the "HASH:COUNT" format can be used as is.
+func (p *PasswordPolicy) LoadBreached(path string) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	breached := make(map[int]map[string]struct{})
+	n := 0
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if i := strings.IndexByte(line, ':'); i >= 0 {
+			line = line[:i]
+		}
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if len(line) < BREACHED_MIN_PREFIX || len(line) > sha1.Size * 2 {
+			return 0, errors.New(fmt.Sprintf("Invalid breached password prefix: %s", line))
+		}
+		if _, err := hex.DecodeString(line + strings.Repeat("0", len(line) % 2)); err != nil {
+			return 0, errors.New(fmt.Sprintf("Invalid breached password prefix: %s", line))
+		}
+		prefixes, ok := breached[len(line)]
+		if !ok {
+			prefixes = make(map[string]struct{})
+			breached[len(line)] = prefixes
+		}
+		prefixes[strings.ToUpper(line)] = struct{}{}
+		n++
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+	p.breached = breached
+	return n, nil
+}
+
+func (p *PasswordPolicy) isBreached(password string) bool {
+	if len(p.breached) == 0 {
+		return false
+	}
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	for l, prefixes := range p.breached {
+		if _, ok := prefixes[hash[:l]]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Check returns violations of the policy in the format of the json schema errors, prefixed with the field
+// of the password. The name is the user name which the password must not contain.
+func (p *PasswordPolicy) Check(field, password, name string) []string {
+	violations := make([]string, 0)
+	if len([]rune(password)) < p.MinLength {
+		violations = append(violations, fmt.Sprintf("%s: Password must be at least %d characters long", field, p.MinLength))
+	}
+	for _, c := range p.Classes {
+		found := false
+		for _, r := range password {
+			if passwordClasses[c](r) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			violations = append(violations, fmt.Sprintf("%s: Password must contain a %s character", field, c))
+		}
+	}
+	if p.ForbidName && name != "" && strings.Contains(strings.ToLower(password), strings.ToLower(name)) {
+		violations = append(violations, fmt.Sprintf("%s: Password must not contain the user name", field))
+	}
+	if p.isBreached(password) {
+		violations = append(violations, fmt.Sprintf("%s: Password is found in a list of breached passwords", field))
+	}
+	return violations
+}
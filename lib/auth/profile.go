@@ -0,0 +1,118 @@
+package auth
+
+import (
+	"strings"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Unique indexes of the users collection, names are generated by mongo from the keys
+var uniqueUserFields = map[string]string{
+	"name_1": "name",
+	"email_1": "email",
+}
+
+func conflictError(field string) ErrorConflict {
+	return ErrorConflict{Field: field, Message: strings.Title(field) + " is already taken"}
+}
+
+// userConflict converts duplicate key error of the users collection into ErrorConflict
+func userConflict(err error) error {
+	if !strings.Contains(err.Error(), "dup key") {
+		return err
+	}
+	for index, field := range uniqueUserFields {
+		if strings.Contains(err.Error(), index) {
+			return conflictError(field)
+		}
+	}
+	return ErrorUserExists{}
+}
+
+func (a *Auth) Profile(users IAuthDataSource, current *Session) (*Profile, error) {
+	user, err := a.findUser(users, current.Uid)
+	if err != nil {
+		return nil, err
+	}
+	profile := &Profile{
+		Name: user.Name,
+		Email: user.Email,
+		EmailVerified: !user.Unverified,
+		DisplayName: user.DisplayName,
+		Preferences: user.Preferences,
+	}
+	if !user.DeleteAt.IsZero() {
+		profile.DeleteAt = &user.DeleteAt
+	}
+	return profile, nil
+}
+
+// UpdateProfile applies the changes and returns the updated user. Changed email becomes unverified,
+// the second value reports that the verification has to be sent.
+func (a *Auth) UpdateProfile(users IAuthDataSource, sessions IAuthDataSource, tokens IAuthDataSource,
+	current *Session, data *ProfileData) (*RegData, bool, error) {
+	user, err := a.findUser(users, current.Uid)
+	if err != nil {
+		return nil, false, err
+	}
+	set := bson.M{}
+	sessionSet := bson.M{}
+	emailChanged := false
+	if data.Name != nil && *data.Name != user.Name {
+		if n, err := users.Count(bson.M{"name": *data.Name}); err != nil {
+			return nil, false, err
+		} else if n > 0 {
+			return nil, false, conflictError("name")
+		}
+		set["name"], sessionSet["name"] = *data.Name, *data.Name
+		user.Name = *data.Name
+	}
+	if data.Email != nil && *data.Email != user.Email {
+		if n, err := users.Count(bson.M{"email": *data.Email}); err != nil {
+			return nil, false, err
+		} else if n > 0 {
+			return nil, false, conflictError("email")
+		}
+		set["email"], sessionSet["email"] = *data.Email, *data.Email
+		set["unverified"], sessionSet["unverified"] = true, true
+		user.Email, user.Unverified = *data.Email, true
+		emailChanged = true
+	}
+	if data.DisplayName != nil {
+		set["displayname"] = *data.DisplayName
+		user.DisplayName = *data.DisplayName
+	}
+	if data.Preferences != nil {
+		if data.Preferences.Locale != "" {
+			set["preferences.locale"] = data.Preferences.Locale
+			user.Preferences.Locale = data.Preferences.Locale
+		}
+		if data.Preferences.RatingScale != 0 {
+			set["preferences.ratingscale"] = data.Preferences.RatingScale
+			user.Preferences.RatingScale = data.Preferences.RatingScale
+		}
+	}
+	if len(set) == 0 {
+		return user, false, nil
+	}
+
+	// Counts above give readable errors, unique indexes still guard against concurrent changes
+	if err := users.Update(bson.M{"_id": user.Id}, bson.M{"$set": set}); err != nil {
+		if err.Error() == "not found" {
+			return nil, false, ErrorUsersDoesntExist{}
+		}
+		return nil, false, userConflict(err)
+	}
+	if len(sessionSet) > 0 {
+		if err := sessions.UpdateAll(bson.M{"uid": user.Id}, bson.M{"$set": sessionSet}); err != nil {
+			return nil, false, err
+		}
+	}
+	if emailChanged {
+		// Links sent to the previous email must not verify the new one
+		if err := tokens.RemoveAll(bson.M{"uid": user.Id}); err != nil && err.Error() != "not found" {
+			return nil, false, err
+		}
+	}
+	return user, emailChanged, nil
+}
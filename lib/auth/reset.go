@@ -0,0 +1,109 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+	"github.com/dzendmitry/rating-service/lib/general"
+)
+
+const (
+	RESET_TOKEN_SIZE = 32
+	RESET_TOKEN_EXPIRES = 3600
+)
+
+// Only hashes of one-time tokens are stored, so leaked collection can't be used to reset passwords
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func (a *Auth) setPassword(users IAuthDataSource, uid bson.ObjectId, password string) error {
+	hash, err := a.HashPassword(password)
+	if err != nil {
+		return err
+	}
+	if err := users.Update(bson.M{"_id": uid}, bson.M{"$set": bson.M{"password": hash}}); err != nil {
+		if err.Error() == "not found" {
+			return ErrorUsersDoesntExist{}
+		}
+		return err
+	}
+	return nil
+}
+
+func (a *Auth) ChangePassword(users IAuthDataSource, sessions IAuthDataSource, current *Session, data *ChangePasswordData) error {
+	var user RegData
+	if err := users.FindOne(bson.M{"_id": current.Uid}, &user); err != nil {
+		if err.Error() == "not found" {
+			return ErrorUsersDoesntExist{}
+		}
+		return err
+	}
+	if ok, _ := a.CheckPassword(user.Password, data.OldPassword); !ok {
+		return ErrorInvalidPassword{}
+	}
+	if err := a.setPassword(users, user.Id, data.NewPassword); err != nil {
+		return err
+	}
+	return a.RevokeOtherSessions(sessions, current)
+}
+
+// RequestPasswordReset creates a one-time reset token for the user with given email.
+// Returned token has to be delivered to the user, only its hash is stored.
+func (a *Auth) RequestPasswordReset(users IAuthDataSource, tokens IAuthDataSource, data *ResetRequestData) (string, *RegData, error) {
+	var user RegData
+	if err := users.FindOne(bson.M{"email": data.Email}, &user); err != nil {
+		if err.Error() == "not found" {
+			return "", nil, ErrorUsersDoesntExist{}
+		}
+		return "", nil, err
+	}
+	token, err := general.GetRandomToken(RESET_TOKEN_SIZE)
+	if err != nil {
+		return "", nil, err
+	}
+	if err := tokens.Insert(ResetToken{
+		Id: bson.NewObjectId(),
+		Hash: hashToken(token),
+		Uid: user.Id,
+		Created: time.Now(),
+	}); err != nil {
+		return "", nil, err
+	}
+	return token, &user, nil
+}
+
+func (a *Auth) ResetPassword(users IAuthDataSource, sessions IAuthDataSource, tokens IAuthDataSource, data *ResetPasswordData) (bson.ObjectId, error) {
+	var token ResetToken
+	if err := tokens.FindOne(bson.M{"hash": hashToken(data.Token)}, &token); err != nil {
+		if err.Error() == "not found" {
+			return "", ErrorInvalidToken{}
+		}
+		return "", err
+	}
+	// The token is kept when the password is rejected, so the user can choose another one
+	user, err := a.findUser(users, token.Uid)
+	if err != nil {
+		return "", err
+	}
+	if violations := a.Policy.Check(PASSWORD_FIELD, data.Password, user.Name); len(violations) > 0 {
+		return user.Id, ErrorPasswordPolicy{Violations: violations}
+	}
+	// Removing goes first, so concurrent requests can't use the same token twice
+	if err := tokens.Remove(bson.M{"_id": token.Id}); err != nil {
+		if err.Error() == "not found" {
+			return "", ErrorInvalidToken{}
+		}
+		return "", err
+	}
+	if time.Since(token.Created) > RESET_TOKEN_EXPIRES * time.Second {
+		return "", ErrorInvalidToken{}
+	}
+	if err := a.setPassword(users, token.Uid, data.Password); err != nil {
+		return token.Uid, err
+	}
+	return token.Uid, sessions.RemoveAll(bson.M{"uid": token.Uid})
+}
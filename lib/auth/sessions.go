@@ -0,0 +1,160 @@
+package auth
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+	"github.com/dzendmitry/rating-service/lib/general"
+)
+
+const (
+	SID_SIZE = 32
+	// lastSeen and expires are written to mongo not more often than once per interval (seconds),
+	// so requests are authorized without a write
+	LAST_SEEN_INTERVAL = 60
+
+	// Session expires after the idle timeout since the last activity but not later than max age (seconds).
+	// Sessions without "remember me" use the short timeout.
+	SESSION_IDLE_TIMEOUT = 604800
+	SESSION_SHORT_IDLE_TIMEOUT = 43200
+	SESSION_MAX_AGE = COOKIE_EXPIRES
+)
+
+// Timeouts are set once on start, before requests are served
+var (
+	sessionIdleTimeout = SESSION_IDLE_TIMEOUT * time.Second
+	sessionShortIdleTimeout = SESSION_SHORT_IDLE_TIMEOUT * time.Second
+	sessionMaxAge = SESSION_MAX_AGE * time.Second
+)
+
+// InitSessionTimeouts sets idle timeouts and max age of sessions. Max age can't exceed COOKIE_EXPIRES,
+// which is the TTL of sessions in mongo.
+func InitSessionTimeouts(idle, shortIdle, maxAge time.Duration) error {
+	if idle <= 0 || shortIdle <= 0 || maxAge <= 0 {
+		return errors.New("Session timeouts must be positive")
+	}
+	if maxAge > COOKIE_EXPIRES * time.Second {
+		return errors.New("Session max age can't exceed the sessions TTL")
+	}
+	sessionIdleTimeout, sessionShortIdleTimeout, sessionMaxAge = idle, shortIdle, maxAge
+	return nil
+}
+
+func (s *Session) idleTimeout() time.Duration {
+	if s.Short {
+		return sessionShortIdleTimeout
+	}
+	return sessionIdleTimeout
+}
+
+// nextExpiry returns the expiry of the session active at the moment
+func (s *Session) nextExpiry(now time.Time) time.Time {
+	expires := now.Add(s.idleTimeout())
+	if max := s.Created.Add(sessionMaxAge); expires.After(max) {
+		return max
+	}
+	return expires
+}
+
+// active checks the expiry in code, mongo removes expired documents with a delay.
+// Sessions created before sliding expiry have no expires and live until max age.
+func (s *Session) active(now time.Time) bool {
+	if !now.Before(s.Created.Add(sessionMaxAge)) {
+		return false
+	}
+	return s.Expires.IsZero() || now.Before(s.Expires)
+}
+
+// CookieExpires returns expiry of the sid cookie, it's zero for short sessions which end with the browser session
+func (s *Session) CookieExpires() time.Time {
+	if s.Short {
+		return time.Time{}
+	}
+	return s.Created.Add(sessionMaxAge)
+}
+
+func NewClientInfo(req *http.Request) ClientInfo {
+	ip, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		ip = req.RemoteAddr
+	}
+	return ClientInfo{
+		UserAgent: req.UserAgent(),
+		Ip: ip,
+	}
+}
+
+func newSid() (string, error) {
+	return general.GetRandomToken(SID_SIZE)
+}
+
+func (a *Auth) newSession(sessions IAuthDataSource, user *RegData, client ClientInfo, remember bool) (string, error) {
+	sid, err := newSid()
+	if err != nil {
+		return "", err
+	}
+	csrf, err := newCsrfToken()
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	session := Session{
+		Id: bson.NewObjectId(),
+		Sid: sid,
+		Uid: user.Id,
+		Name: user.Name,
+		Email: user.Email,
+		Unverified: user.Unverified,
+		UserAgent: client.UserAgent,
+		Ip: client.Ip,
+		Created: now,
+		LastSeen: now,
+		Csrf: csrf,
+		Short: !remember,
+	}
+	session.Expires = session.nextExpiry(now)
+	if err := sessions.Insert(session); err != nil {
+		return "", err
+	}
+	return sid, nil
+}
+
+// touchSession extends the session on activity
+func touchSession(sessions IAuthDataSource, session *Session) {
+	now := time.Now()
+	if now.Sub(session.LastSeen) < LAST_SEEN_INTERVAL * time.Second {
+		return
+	}
+	session.LastSeen = now
+	session.Expires = session.nextExpiry(now)
+	// Failed update must not break the request, the session is extended by the next one
+	sessions.Update(bson.M{"_id": session.Id}, bson.M{"$set": bson.M{"lastseen": now, "expires": session.Expires}})
+}
+
+func (a *Auth) Sessions(sessions IAuthDataSource, current *Session) ([]Session, error) {
+	list := make([]Session, 0)
+	if err := sessions.FindAll(bson.M{"uid": current.Uid}, &list); err != nil {
+		return nil, err
+	}
+	for i := range list {
+		list[i].Current = list[i].Id == current.Id
+	}
+	return list, nil
+}
+
+func (a *Auth) RevokeSession(sessions IAuthDataSource, current *Session, id bson.ObjectId) error {
+	if err := sessions.Remove(bson.M{"_id": id, "uid": current.Uid}); err != nil {
+		if err.Error() == "not found" {
+			return ErrorSessionNotFound{}
+		}
+		return err
+	}
+	return nil
+}
+
+func (a *Auth) RevokeOtherSessions(sessions IAuthDataSource, current *Session) error {
+	return sessions.RemoveAll(bson.M{"uid": current.Uid, "_id": bson.M{"$ne": current.Id}})
+}
@@ -0,0 +1,280 @@
+package auth
+
+import (
+	"sync"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+	"github.com/dzendmitry/logger"
+	"github.com/dzendmitry/rating-service/lib/redis"
+)
+
+const (
+	LOGIN_MAX_ACCOUNT_FAILURES = 5
+	LOGIN_MAX_IP_FAILURES = 20
+	// Failures are counted within the window, lock lasts LOGIN_LOCK_TIME (seconds)
+	LOGIN_FAILURES_WINDOW = 900
+	LOGIN_LOCK_TIME = 900
+	LOGIN_MAX_DELAY = 60
+
+	LOCK_EVENT_LOCK = "lock"
+	LOCK_EVENT_UNLOCK = "unlock"
+
+	failuresPrefix = "login-failures:"
+	delayPrefix = "login-delay:"
+	lockPrefix = "login-lock:"
+	accountKey = "account:"
+	ipKey = "ip:"
+)
+
+// IAttemptsStore keeps expiring counters of failed login attempts
+type IAttemptsStore interface {
+	Incr(key string, ex int) (int, error)
+	Get(key string) (int, error)
+	Set(key string, value, ex int) error
+	Del(keys ...string) error
+}
+
+type RedisAttemptsStore struct {
+	master string
+}
+
+func NewRedisAttemptsStore(master string) *RedisAttemptsStore {
+	return &RedisAttemptsStore{master: master}
+}
+
+func (s *RedisAttemptsStore) Incr(key string, ex int) (int, error) {
+	return redis.IncrExSentiel(s.master, key, ex)
+}
+
+func (s *RedisAttemptsStore) Get(key string) (int, error) {
+	return redis.GetIntSentiel(s.master, key)
+}
+
+func (s *RedisAttemptsStore) Set(key string, value, ex int) error {
+	return redis.SetIntExSentiel(s.master, key, value, ex)
+}
+
+func (s *RedisAttemptsStore) Del(keys ...string) error {
+	return redis.DelSentiel(s.master, keys...)
+}
+
+type memoryAttempt struct {
+	value int
+	expires time.Time
+}
+
+// MemoryAttemptsStore is used by single-node setups without redis
+type MemoryAttemptsStore struct {
+	mu sync.Mutex
+	data map[string]memoryAttempt
+}
+
+func NewMemoryAttemptsStore() *MemoryAttemptsStore {
+	return &MemoryAttemptsStore{data: make(map[string]memoryAttempt)}
+}
+
+func (s *MemoryAttemptsStore) get(key string, now time.Time) (memoryAttempt, bool) {
+	a, ok := s.data[key]
+	if ok && !now.Before(a.expires) {
+		delete(s.data, key)
+		return a, false
+	}
+	return a, ok
+}
+
+func (s *MemoryAttemptsStore) Incr(key string, ex int) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	a, ok := s.get(key, now)
+	if !ok {
+		a = memoryAttempt{expires: now.Add(time.Duration(ex) * time.Second)}
+	}
+	a.value++
+	s.data[key] = a
+	return a.value, nil
+}
+
+func (s *MemoryAttemptsStore) Get(key string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	a, _ := s.get(key, time.Now())
+	return a.value, nil
+}
+
+func (s *MemoryAttemptsStore) Set(key string, value, ex int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data[key] = memoryAttempt{value: value, expires: time.Now().Add(time.Duration(ex) * time.Second)}
+	return nil
+}
+
+func (s *MemoryAttemptsStore) Del(keys ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, k := range keys {
+		delete(s.data, k)
+	}
+	return nil
+}
+
+// FallbackAttemptsStore uses the secondary store while the primary one returns errors
+type FallbackAttemptsStore struct {
+	primary IAttemptsStore
+	secondary IAttemptsStore
+	log logger.ILogger
+}
+
+func NewFallbackAttemptsStore(primary, secondary IAttemptsStore, log logger.ILogger) *FallbackAttemptsStore {
+	return &FallbackAttemptsStore{primary: primary, secondary: secondary, log: log}
+}
+
+func (s *FallbackAttemptsStore) Incr(key string, ex int) (int, error) {
+	n, err := s.primary.Incr(key, ex)
+	if err != nil {
+		s.log.Warnf("Attempts store error, fallback is used: %s", err.Error())
+		return s.secondary.Incr(key, ex)
+	}
+	return n, nil
+}
+
+func (s *FallbackAttemptsStore) Get(key string) (int, error) {
+	n, err := s.primary.Get(key)
+	if err != nil {
+		s.log.Warnf("Attempts store error, fallback is used: %s", err.Error())
+		return s.secondary.Get(key)
+	}
+	return n, nil
+}
+
+func (s *FallbackAttemptsStore) Set(key string, value, ex int) error {
+	if err := s.primary.Set(key, value, ex); err != nil {
+		s.log.Warnf("Attempts store error, fallback is used: %s", err.Error())
+		return s.secondary.Set(key, value, ex)
+	}
+	return nil
+}
+
+func (s *FallbackAttemptsStore) Del(keys ...string) error {
+	// Both stores are cleaned, the secondary one could be used while the primary was down
+	s.secondary.Del(keys...)
+	return s.primary.Del(keys...)
+}
+
+// Throttle counts failed logins per account and per client ip. Every failure delays the next attempt
+// exponentially, too many failures lock the account or the ip for LOGIN_LOCK_TIME.
+type Throttle struct {
+	store IAttemptsStore
+	events IAuthDataSource
+	log logger.ILogger
+}
+
+func NewThrottle(store IAttemptsStore, events IAuthDataSource, log logger.ILogger) *Throttle {
+	return &Throttle{store: store, events: events, log: log}
+}
+
+// Check returns ErrorLoginThrottled if the account or the ip is locked or has to wait
+func (t *Throttle) Check(name, ip string) error {
+	now := time.Now().Unix()
+	var until int64
+	locked := false
+	for _, k := range []string{accountKey + name, ipKey + ip} {
+		lock, err := t.store.Get(lockPrefix + k)
+		if err != nil {
+			return err
+		}
+		if int64(lock) > now && int64(lock) > until {
+			until, locked = int64(lock), true
+		}
+	}
+	if !locked {
+		delay, err := t.store.Get(delayPrefix + accountKey + name)
+		if err != nil {
+			return err
+		}
+		if int64(delay) > now {
+			until = int64(delay)
+		}
+	}
+	if until > now {
+		return ErrorLoginThrottled{Until: time.Unix(until, 0), Locked: locked}
+	}
+	return nil
+}
+
+func (t *Throttle) Failure(name, ip string) {
+	now := time.Now()
+	accountFailures, err := t.store.Incr(failuresPrefix + accountKey + name, LOGIN_FAILURES_WINDOW)
+	if err != nil {
+		t.log.Warnf("Error while counting login failure of %s: %s", name, err.Error())
+		return
+	}
+	ipFailures, err := t.store.Incr(failuresPrefix + ipKey + ip, LOGIN_FAILURES_WINDOW)
+	if err != nil {
+		t.log.Warnf("Error while counting login failure from %s: %s", ip, err.Error())
+		return
+	}
+
+	delay := LOGIN_MAX_DELAY
+	if accountFailures <= 6 {
+		delay = 1 << uint(accountFailures - 1)
+	}
+	if err := t.store.Set(delayPrefix + accountKey + name, int(now.Unix()) + delay, delay); err != nil {
+		t.log.Warnf("Error while setting login delay of %s: %s", name, err.Error())
+	}
+
+	if accountFailures == LOGIN_MAX_ACCOUNT_FAILURES {
+		t.lock(LockEvent{Name: name}, accountKey + name, accountFailures, now)
+	}
+	if ipFailures == LOGIN_MAX_IP_FAILURES {
+		t.lock(LockEvent{Ip: ip}, ipKey + ip, ipFailures, now)
+	}
+}
+
+func (t *Throttle) Success(name string) {
+	if err := t.store.Del(failuresPrefix + accountKey + name, delayPrefix + accountKey + name); err != nil {
+		t.log.Warnf("Error while resetting login failures of %s: %s", name, err.Error())
+	}
+}
+
+func (t *Throttle) lock(event LockEvent, key string, failures int, now time.Time) {
+	until := now.Add(LOGIN_LOCK_TIME * time.Second)
+	if err := t.store.Set(lockPrefix + key, int(until.Unix()), LOGIN_LOCK_TIME); err != nil {
+		t.log.Warnf("Error while locking %s: %s", key, err.Error())
+		return
+	}
+	t.store.Del(failuresPrefix + key)
+	event.Id = bson.NewObjectId()
+	event.Type = LOCK_EVENT_LOCK
+	event.Failures = failures
+	event.Until = until
+	event.Created = now
+	if err := t.events.Insert(event); err != nil {
+		t.log.Warnf("Error while recording lock of %s: %s", key, err.Error())
+	}
+}
+
+// Unlock removes locks and failures of the account and/or the ip, admin is the user who unlocks
+func (t *Throttle) Unlock(data *UnlockData, admin bson.ObjectId) error {
+	keys := make([]string, 0, 2)
+	if data.Name != "" {
+		keys = append(keys, accountKey + data.Name)
+	}
+	if data.Ip != "" {
+		keys = append(keys, ipKey + data.Ip)
+	}
+	for _, k := range keys {
+		if err := t.store.Del(lockPrefix + k, failuresPrefix + k, delayPrefix + k); err != nil {
+			return err
+		}
+	}
+	return t.events.Insert(LockEvent{
+		Id: bson.NewObjectId(),
+		Type: LOCK_EVENT_UNLOCK,
+		Name: data.Name,
+		Ip: data.Ip,
+		By: admin,
+		Created: time.Now(),
+	})
+}
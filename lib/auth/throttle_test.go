@@ -0,0 +1,120 @@
+package auth
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+	"github.com/dzendmitry/logger"
+)
+
+func newTestThrottle() (*Throttle, *MemoryAttemptsStore, *memSource) {
+	store, events := NewMemoryAttemptsStore(), newMemSource()
+	return NewThrottle(store, events, logger.InitFileLogger("AUTH-TEST", "")), store, events
+}
+
+func throttled(t *testing.T, err error) *ErrorLoginThrottled {
+	if err == nil {
+		return nil
+	}
+	e, ok := err.(ErrorLoginThrottled)
+	if !ok {
+		t.Fatalf("Unexpected error %#v", err)
+	}
+	return &e
+}
+
+func TestThrottleAccountFailures(t *testing.T) {
+	th, _, events := newTestThrottle()
+	for _, c := range []struct {
+		failures int
+		locked bool
+		// Delay of the next attempt in seconds
+		delay int
+	}{
+		{1, false, 1},
+		{2, false, 2},
+		{3, false, 4},
+		{4, false, 8},
+		{LOGIN_MAX_ACCOUNT_FAILURES, true, LOGIN_LOCK_TIME},
+	} {
+		t.Run(fmt.Sprintf("%d failures", c.failures), func(t *testing.T) {
+			th.Failure("alice", fmt.Sprintf("10.0.0.%d", c.failures))
+			now := time.Now()
+			e := throttled(t, th.Check("alice", "10.0.1.1"))
+			if e == nil {
+				t.Fatal("The account isn't throttled")
+			}
+			if e.Locked != c.locked {
+				t.Fatalf("Expected locked %v, got %v", c.locked, e.Locked)
+			}
+			until := now.Add(time.Duration(c.delay) * time.Second)
+			if e.Until.Before(until.Add(-2 * time.Second)) || e.Until.After(until.Add(time.Second)) {
+				t.Fatalf("Expected throttling until %s, got %s", until, e.Until)
+			}
+		})
+	}
+	var locks []LockEvent
+	if err := events.FindAll(bson.M{"type": LOCK_EVENT_LOCK}, &locks); err != nil {
+		t.Fatal(err)
+	}
+	if len(locks) != 1 || locks[0].Name != "alice" || locks[0].Failures != LOGIN_MAX_ACCOUNT_FAILURES {
+		t.Fatalf("Unexpected lock events %+v", locks)
+	}
+	if e := throttled(t, th.Check("bob", "10.0.1.1")); e != nil {
+		t.Fatalf("Another account is throttled: %+v", e)
+	}
+}
+
+func TestThrottleIpFailures(t *testing.T) {
+	th, _, _ := newTestThrottle()
+	ip := "10.0.0.1"
+	for i := 1; i <= LOGIN_MAX_IP_FAILURES; i++ {
+		// Every name fails once, so only the ip counter reaches its limit
+		th.Failure(fmt.Sprintf("user%d", i), ip)
+		e := throttled(t, th.Check("alice", ip))
+		if locked := e != nil && e.Locked; locked != (i == LOGIN_MAX_IP_FAILURES) {
+			t.Fatalf("Unexpected lock of the ip after %d failures: %+v", i, e)
+		}
+	}
+	if e := throttled(t, th.Check("alice", "10.0.0.2")); e != nil {
+		t.Fatalf("Another ip is throttled: %+v", e)
+	}
+}
+
+func TestThrottleSuccessAndUnlock(t *testing.T) {
+	th, store, events := newTestThrottle()
+	for i := 0; i < LOGIN_MAX_ACCOUNT_FAILURES - 1; i++ {
+		th.Failure("alice", "10.0.0.1")
+	}
+	th.Success("alice")
+	if e := throttled(t, th.Check("alice", "10.0.0.1")); e != nil {
+		t.Fatalf("The account is throttled after success: %+v", e)
+	}
+	// The counter starts over, so one more failure doesn't lock the account
+	th.Failure("alice", "10.0.0.1")
+	if e := throttled(t, th.Check("alice", "10.0.0.1")); e == nil || e.Locked {
+		t.Fatalf("Expected a delay without lock, got %+v", e)
+	}
+
+	for i := 0; i < LOGIN_MAX_ACCOUNT_FAILURES; i++ {
+		th.Failure("bob", "10.0.0.2")
+	}
+	if e := throttled(t, th.Check("bob", "10.0.0.2")); e == nil || !e.Locked {
+		t.Fatalf("The account isn't locked: %+v", e)
+	}
+	admin := bson.NewObjectId()
+	if err := th.Unlock(&UnlockData{Name: "bob"}, admin); err != nil {
+		t.Fatal(err)
+	}
+	if e := throttled(t, th.Check("bob", "10.0.0.2")); e != nil {
+		t.Fatalf("The account is throttled after unlock: %+v", e)
+	}
+	if n, _ := store.Get(failuresPrefix + accountKey + "bob"); n != 0 {
+		t.Fatalf("Failures aren't reset by unlock: %d", n)
+	}
+	if n, _ := events.Count(bson.M{"type": LOCK_EVENT_UNLOCK, "by": admin}); n != 1 {
+		t.Fatal("Unlock isn't recorded")
+	}
+}
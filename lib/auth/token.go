@@ -0,0 +1,250 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	AUTH_MODE_COOKIE = "cookie"
+	AUTH_MODE_TOKEN  = "token"
+
+	ACCESS_TOKEN_EXPIRES = 300
+	TOKEN_TYPE = "Bearer"
+	TOKEN_ALG = "HS256"
+)
+
+// Signing keys are shared by auth-service, which issues access tokens, and services which verify them.
+// Keys are identified by kid, so a new key can be added and made current while tokens signed
+// with the previous one are still valid.
+var (
+	tokenKeysMu sync.RWMutex
+	tokenKeys   map[string][]byte
+	signingKid  string
+)
+
+type TokenKey struct {
+	Id string
+	Secret []byte
+}
+
+type tokenHeader struct {
+	Alg string `json:"alg"`
+	Typ string `json:"typ"`
+	Kid string `json:"kid"`
+}
+
+type TokenClaims struct {
+	Uid string      `json:"sub"`
+	SessionId string `json:"sid"`
+	Name string     `json:"name,omitempty"`
+	Unverified bool `json:"unv,omitempty"`
+	IssuedAt int64  `json:"iat"`
+	Expires int64   `json:"exp"`
+}
+
+type Tokens struct {
+	AccessToken string  `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	TokenType string    `json:"token_type"`
+	ExpiresIn int       `json:"expires_in"`
+}
+
+type RefreshData struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// ParseTokenKeys parses keys in format "kid1:secret1,kid2:secret2"
+func ParseTokenKeys(s string) ([]TokenKey, error) {
+	keys := make([]TokenKey, 0)
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		kv := strings.SplitN(pair, ":", 2)
+		if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+			return nil, errors.New(fmt.Sprintf("Invalid token key: %s", pair))
+		}
+		keys = append(keys, TokenKey{Id: kv[0], Secret: []byte(kv[1])})
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("There is no one token key")
+	}
+	return keys, nil
+}
+
+// InitTokens sets keys for issuing and verifying access tokens. New tokens are signed with the kid key.
+func InitTokens(keys []TokenKey, kid string) error {
+	m := make(map[string][]byte, len(keys))
+	for _, k := range keys {
+		m[k.Id] = k.Secret
+	}
+	if _, ok := m[kid]; !ok {
+		return errors.New(fmt.Sprintf("There is no signing key with id %s", kid))
+	}
+	tokenKeysMu.Lock()
+	tokenKeys = m
+	signingKid = kid
+	tokenKeysMu.Unlock()
+	return nil
+}
+
+func tokensEnabled() bool {
+	tokenKeysMu.RLock()
+	defer tokenKeysMu.RUnlock()
+	return len(tokenKeys) > 0
+}
+
+func signToken(key []byte, data string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func encodeTokenPart(v interface{}) (string, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+func decodeTokenPart(s string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// IssueAccessToken returns JWT (HS256) for the session
+func IssueAccessToken(session *Session) (string, error) {
+	tokenKeysMu.RLock()
+	kid, key := signingKid, tokenKeys[signingKid]
+	tokenKeysMu.RUnlock()
+	if key == nil {
+		return "", errors.New("Token keys are not initialized")
+	}
+	now := time.Now()
+	header, err := encodeTokenPart(tokenHeader{Alg: TOKEN_ALG, Typ: "JWT", Kid: kid})
+	if err != nil {
+		return "", err
+	}
+	claims, err := encodeTokenPart(TokenClaims{
+		Uid: session.Uid.Hex(),
+		SessionId: session.Id.Hex(),
+		Name: session.Name,
+		Unverified: session.Unverified,
+		IssuedAt: now.Unix(),
+		Expires: now.Add(ACCESS_TOKEN_EXPIRES * time.Second).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+	data := header + "." + claims
+	return data + "." + signToken(key, data), nil
+}
+
+func VerifyAccessToken(token string) (*TokenClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrorInvalidToken{}
+	}
+	var header tokenHeader
+	if err := decodeTokenPart(parts[0], &header); err != nil || header.Alg != TOKEN_ALG {
+		return nil, ErrorInvalidToken{}
+	}
+	tokenKeysMu.RLock()
+	key := tokenKeys[header.Kid]
+	tokenKeysMu.RUnlock()
+	if key == nil {
+		return nil, ErrorInvalidToken{}
+	}
+	if !hmac.Equal([]byte(parts[2]), []byte(signToken(key, parts[0] + "." + parts[1]))) {
+		return nil, ErrorInvalidToken{}
+	}
+	var claims TokenClaims
+	if err := decodeTokenPart(parts[1], &claims); err != nil {
+		return nil, ErrorInvalidToken{}
+	}
+	if time.Now().Unix() >= claims.Expires {
+		return nil, ErrorInvalidToken{}
+	}
+	if !bson.IsObjectIdHex(claims.Uid) || !bson.IsObjectIdHex(claims.SessionId) {
+		return nil, ErrorInvalidToken{}
+	}
+	return &claims, nil
+}
+
+// sessionFromClaims builds session without touching the storage. Sid is replaced with the session id,
+// the real sid is the refresh token and never leaves auth-service.
+func sessionFromClaims(claims *TokenClaims) *Session {
+	return &Session{
+		Id: bson.ObjectIdHex(claims.SessionId),
+		Sid: claims.SessionId,
+		Uid: bson.ObjectIdHex(claims.Uid),
+		Name: claims.Name,
+		Unverified: claims.Unverified,
+		Created: time.Unix(claims.IssuedAt, 0),
+		LastSeen: time.Unix(claims.IssuedAt, 0),
+	}
+}
+
+// IssueTokens returns access token and refresh token for the session with given sid.
+// In the token mode sid of the session is used as the refresh token.
+func (a *Auth) IssueTokens(sessions IAuthDataSource, sid string) (*Tokens, error) {
+	var session Session
+	if err := sessions.FindOne(bson.M{SidKey: sid}, &session); err != nil {
+		if err.Error() == "not found" {
+			return nil, ErrorInvalidToken{}
+		}
+		return nil, err
+	}
+	access, err := IssueAccessToken(&session)
+	if err != nil {
+		return nil, err
+	}
+	return &Tokens{
+		AccessToken: access,
+		RefreshToken: sid,
+		TokenType: TOKEN_TYPE,
+		ExpiresIn: ACCESS_TOKEN_EXPIRES,
+	}, nil
+}
+
+// RefreshTokens rotates refresh token, so every refresh token can be used only once
+func (a *Auth) RefreshTokens(sessions IAuthDataSource, refresh string) (*Tokens, error) {
+	var session Session
+	if err := sessions.FindOne(bson.M{SidKey: refresh}, &session); err != nil {
+		if err.Error() == "not found" {
+			return nil, ErrorInvalidToken{}
+		}
+		return nil, err
+	}
+	now := time.Now()
+	if !session.active(now) {
+		return nil, ErrorInvalidToken{}
+	}
+	sid, err := newSid()
+	if err != nil {
+		return nil, err
+	}
+	if err := sessions.Update(bson.M{"_id": session.Id, SidKey: refresh},
+		bson.M{"$set": bson.M{SidKey: sid, "lastseen": now, "expires": session.nextExpiry(now)}}); err != nil {
+		if err.Error() == "not found" {
+			return nil, ErrorInvalidToken{}
+		}
+		return nil, err
+	}
+	return a.IssueTokens(sessions, sid)
+}
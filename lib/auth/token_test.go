@@ -0,0 +1,169 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+func initTestTokens(t *testing.T, kid string, keys ...string) {
+	parsed, err := ParseTokenKeys(strings.Join(keys, ","))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := InitTokens(parsed, kid); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// resetTokens disables tokens for other tests
+func resetTokens() {
+	tokenKeysMu.Lock()
+	tokenKeys, signingKid = nil, ""
+	tokenKeysMu.Unlock()
+}
+
+func testSession() *Session {
+	return &Session{Id: bson.NewObjectId(), Uid: bson.NewObjectId(), Name: "alice"}
+}
+
+// craftToken signs arbitrary header and claims with the key
+func craftToken(t *testing.T, key string, header tokenHeader, claims TokenClaims) string {
+	h, err := encodeTokenPart(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := encodeTokenPart(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h + "." + c + "." + signToken([]byte(key), h + "." + c)
+}
+
+func TestParseTokenKeys(t *testing.T) {
+	for _, c := range []struct {
+		value string
+		ids []string
+		ok bool
+	}{
+		{"k1:secret", []string{"k1"}, true},
+		{"k1:secret, k2:other:with:colons", []string{"k1", "k2"}, true},
+		{"k1:secret,", []string{"k1"}, true},
+		{"", nil, false},
+		{"k1", nil, false},
+		{"k1:", nil, false},
+		{":secret", nil, false},
+	} {
+		keys, err := ParseTokenKeys(c.value)
+		if (err == nil) != c.ok {
+			t.Fatalf("%q: unexpected error %v", c.value, err)
+		}
+		if len(keys) != len(c.ids) {
+			t.Fatalf("%q: expected keys %v, got %+v", c.value, c.ids, keys)
+		}
+		for i := range keys {
+			if keys[i].Id != c.ids[i] {
+				t.Fatalf("%q: expected keys %v, got %+v", c.value, c.ids, keys)
+			}
+		}
+	}
+}
+
+func TestInitTokensUnknownKid(t *testing.T) {
+	defer resetTokens()
+	if err := InitTokens([]TokenKey{{Id: "k1", Secret: []byte("secret")}}, "k2"); err == nil {
+		t.Fatal("Unknown signing kid is accepted")
+	}
+}
+
+func TestAccessToken(t *testing.T) {
+	defer resetTokens()
+	initTestTokens(t, "k1", "k1:secret1")
+	session := testSession()
+	token, err := IssueAccessToken(session)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := VerifyAccessToken(token)
+	if err != nil {
+		t.Fatalf("Issued token is rejected: %+v", err)
+	}
+	if claims.Uid != session.Uid.Hex() || claims.SessionId != session.Id.Hex() || claims.Name != session.Name {
+		t.Fatalf("Unexpected claims %+v", claims)
+	}
+	if claims.Expires - claims.IssuedAt != ACCESS_TOKEN_EXPIRES {
+		t.Fatalf("Unexpected lifetime %d", claims.Expires - claims.IssuedAt)
+	}
+
+	now := time.Now().Unix()
+	valid := TokenClaims{Uid: session.Uid.Hex(), SessionId: session.Id.Hex(), IssuedAt: now, Expires: now + 60}
+	expired := valid
+	expired.Expires = now - 1
+	invalidUid := valid
+	invalidUid.Uid = "alice"
+	parts := strings.Split(token, ".")
+	for _, c := range []struct {
+		name string
+		token string
+		ok bool
+	}{
+		{"crafted with the key", craftToken(t, "secret1", tokenHeader{Alg: TOKEN_ALG, Kid: "k1"}, valid), true},
+		{"expired", craftToken(t, "secret1", tokenHeader{Alg: TOKEN_ALG, Kid: "k1"}, expired), false},
+		{"invalid uid", craftToken(t, "secret1", tokenHeader{Alg: TOKEN_ALG, Kid: "k1"}, invalidUid), false},
+		{"another key", craftToken(t, "secret2", tokenHeader{Alg: TOKEN_ALG, Kid: "k1"}, valid), false},
+		{"unknown kid", craftToken(t, "secret1", tokenHeader{Alg: TOKEN_ALG, Kid: "k2"}, valid), false},
+		{"alg none", craftToken(t, "secret1", tokenHeader{Alg: "none", Kid: "k1"}, valid), false},
+		{"no signature", parts[0] + "." + parts[1] + ".", false},
+		{"changed claims", parts[0] + "." + strings.Split(craftToken(t, "x", tokenHeader{}, valid), ".")[1] + "." + parts[2], false},
+		{"garbage", "a.b.c", false},
+		{"two parts", parts[0] + "." + parts[1], false},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := VerifyAccessToken(c.token)
+			if c.ok && err != nil {
+				t.Fatalf("The token is rejected: %+v", err)
+			}
+			if !c.ok {
+				if _, ok := err.(ErrorInvalidToken); !ok {
+					t.Fatalf("Expected ErrorInvalidToken, got %#v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestAccessTokenKeyRotation(t *testing.T) {
+	defer resetTokens()
+	initTestTokens(t, "k1", "k1:secret1")
+	old, err := IssueAccessToken(testSession())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The new key becomes current while the previous one still verifies issued tokens
+	initTestTokens(t, "k2", "k1:secret1", "k2:secret2")
+	current, err := IssueAccessToken(testSession())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var header tokenHeader
+	if err := decodeTokenPart(strings.Split(current, ".")[0], &header); err != nil || header.Kid != "k2" {
+		t.Fatalf("The token isn't signed with the current key: %+v %v", header, err)
+	}
+	for _, token := range []string{old, current} {
+		if _, err := VerifyAccessToken(token); err != nil {
+			t.Fatalf("The token is rejected during rotation: %+v", err)
+		}
+	}
+
+	// The previous key is retired
+	initTestTokens(t, "k2", "k2:secret2")
+	if _, err := VerifyAccessToken(old); err == nil {
+		t.Fatal("The token of the retired key is accepted")
+	}
+	if _, err := VerifyAccessToken(current); err != nil {
+		t.Fatalf("The token of the current key is rejected: %+v", err)
+	}
+}
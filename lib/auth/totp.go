@@ -0,0 +1,236 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+	"github.com/dzendmitry/rating-service/lib/general"
+)
+
+const (
+	TOTP_ISSUER = "rating-service"
+	TOTP_SECRET_SIZE = 20
+	TOTP_PERIOD = 30
+	TOTP_DIGITS = 6
+	// Number of periods before and after the current one, codes from which are accepted
+	TOTP_SKEW = 1
+
+	RECOVERY_CODES_COUNT = 10
+	RECOVERY_CODE_SIZE = 5
+)
+
+var (
+	totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+	totpModulus = pow10(TOTP_DIGITS)
+)
+
+func pow10(n int) uint32 {
+	m := uint32(1)
+	for i := 0; i < n; i++ {
+		m *= 10
+	}
+	return m
+}
+
+// totpCode implements RFC 6238 with HMAC-SHA1
+func totpCode(secret []byte, step int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum) - 1] & 0x0f
+	code := binary.BigEndian.Uint32(sum[offset:offset + 4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", TOTP_DIGITS, code % totpModulus)
+}
+
+// checkTotp returns the matched step, so the same code can't be used twice
+func checkTotp(secret, code string, lastStep int64) (int64, bool) {
+	key, err := totpEncoding.DecodeString(secret)
+	if err != nil {
+		return 0, false
+	}
+	current := time.Now().Unix() / TOTP_PERIOD
+	for step := current - TOTP_SKEW; step <= current + TOTP_SKEW; step++ {
+		if step <= lastStep {
+			continue
+		}
+		if hmac.Equal([]byte(totpCode(key, step)), []byte(code)) {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+func isTotpCode(code string) bool {
+	return len(code) == TOTP_DIGITS
+}
+
+func newRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, RECOVERY_CODES_COUNT)
+	hashes := make([]string, RECOVERY_CODES_COUNT)
+	for i := range codes {
+		a, err := general.GetRandomToken(RECOVERY_CODE_SIZE)
+		if err != nil {
+			return nil, nil, err
+		}
+		codes[i] = a[:RECOVERY_CODE_SIZE] + "-" + a[RECOVERY_CODE_SIZE:]
+		hashes[i] = hashToken(codes[i])
+	}
+	return codes, hashes, nil
+}
+
+func (a *Auth) findUser(users IAuthDataSource, uid bson.ObjectId) (*RegData, error) {
+	var user RegData
+	if err := users.FindOne(bson.M{"_id": uid}, &user); err != nil {
+		if err.Error() == "not found" {
+			return nil, ErrorUsersDoesntExist{}
+		}
+		return nil, err
+	}
+	return &user, nil
+}
+
+// checkSecondFactor accepts either the current totp code or one of unused recovery codes
+func (a *Auth) checkSecondFactor(users IAuthDataSource, user *RegData, code string) error {
+	if code == "" {
+		return ErrorTotpRequired{}
+	}
+	if isTotpCode(code) {
+		step, ok := checkTotp(user.Totp, code, user.TotpLastStep)
+		if !ok {
+			return ErrorInvalidTotp{}
+		}
+		// Selector on the last step guards against concurrent use of the same code
+		if err := users.Update(bson.M{"_id": user.Id, "totplast": user.TotpLastStep},
+			bson.M{"$set": bson.M{"totplast": step}}); err != nil {
+			if err.Error() == "not found" {
+				return ErrorInvalidTotp{}
+			}
+			return err
+		}
+		return nil
+	}
+	hash := hashToken(strings.ToLower(code))
+	if err := users.Update(bson.M{"_id": user.Id, "recoverycodes": hash},
+		bson.M{"$pull": bson.M{"recoverycodes": hash}}); err != nil {
+		if err.Error() == "not found" {
+			return ErrorInvalidTotp{}
+		}
+		return err
+	}
+	return nil
+}
+
+// EnrollTotp generates a new secret. It's activated only after a code is confirmed with ConfirmTotp.
+func (a *Auth) EnrollTotp(users IAuthDataSource, current *Session) (*TotpEnrollment, error) {
+	user, err := a.findUser(users, current.Uid)
+	if err != nil {
+		return nil, err
+	}
+	if user.Totp != "" {
+		return nil, ErrorTotpEnabled{}
+	}
+	key := make([]byte, TOTP_SECRET_SIZE)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	secret := totpEncoding.EncodeToString(key)
+	if err := users.Update(bson.M{"_id": user.Id}, bson.M{"$set": bson.M{"totppending": secret}}); err != nil {
+		return nil, err
+	}
+	label := url.PathEscape(TOTP_ISSUER + ":" + user.Name)
+	params := url.Values{}
+	params.Set("secret", secret)
+	params.Set("issuer", TOTP_ISSUER)
+	params.Set("digits", fmt.Sprintf("%d", TOTP_DIGITS))
+	params.Set("period", fmt.Sprintf("%d", TOTP_PERIOD))
+	return &TotpEnrollment{
+		Secret: secret,
+		Uri: "otpauth://totp/" + label + "?" + params.Encode(),
+	}, nil
+}
+
+func (a *Auth) ConfirmTotp(users IAuthDataSource, current *Session, data *TotpCodeData) (*RecoveryCodes, error) {
+	user, err := a.findUser(users, current.Uid)
+	if err != nil {
+		return nil, err
+	}
+	if user.Totp != "" {
+		return nil, ErrorTotpEnabled{}
+	}
+	if user.TotpPending == "" {
+		return nil, ErrorTotpNotEnabled{}
+	}
+	step, ok := checkTotp(user.TotpPending, data.Code, 0)
+	if !ok {
+		return nil, ErrorInvalidTotp{}
+	}
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	if err := users.Update(bson.M{"_id": user.Id, "totppending": user.TotpPending}, bson.M{
+		"$set": bson.M{"totp": user.TotpPending, "totplast": step, "recoverycodes": hashes},
+		"$unset": bson.M{"totppending": ""},
+	}); err != nil {
+		if err.Error() == "not found" {
+			return nil, ErrorInvalidTotp{}
+		}
+		return nil, err
+	}
+	return &RecoveryCodes{Codes: codes}, nil
+}
+
+// DisableTotp requires the password and the second factor. Users without a password,
+// e.g. signed up with an OpenID provider, confirm disabling with the current totp code only.
+func (a *Auth) DisableTotp(users IAuthDataSource, current *Session, data *TotpDisableData) error {
+	user, err := a.findUser(users, current.Uid)
+	if err != nil {
+		return err
+	}
+	if user.Totp == "" {
+		return ErrorTotpNotEnabled{}
+	}
+	if user.Password == "" {
+		if !isTotpCode(data.Code) {
+			return ErrorInvalidTotp{}
+		}
+	} else if ok, _ := a.CheckPassword(user.Password, data.Password); !ok {
+		return ErrorInvalidPassword{}
+	}
+	if err := a.checkSecondFactor(users, user, data.Code); err != nil {
+		return err
+	}
+	return users.Update(bson.M{"_id": user.Id},
+		bson.M{"$unset": bson.M{"totp": "", "totppending": "", "totplast": "", "recoverycodes": ""}})
+}
+
+func (a *Auth) RegenerateRecoveryCodes(users IAuthDataSource, current *Session, data *TotpCodeData) (*RecoveryCodes, error) {
+	user, err := a.findUser(users, current.Uid)
+	if err != nil {
+		return nil, err
+	}
+	if user.Totp == "" {
+		return nil, ErrorTotpNotEnabled{}
+	}
+	if err := a.checkSecondFactor(users, user, data.Code); err != nil {
+		return nil, err
+	}
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	if err := users.Update(bson.M{"_id": user.Id}, bson.M{"$set": bson.M{"recoverycodes": hashes}}); err != nil {
+		return nil, err
+	}
+	return &RecoveryCodes{Codes: codes}, nil
+}
@@ -0,0 +1,114 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Test vectors of RFC 6238 appendix B for HMAC-SHA1, the codes are truncated to TOTP_DIGITS
+func TestTotpCodeRfc6238(t *testing.T) {
+	key := []byte("12345678901234567890")
+	for _, c := range []struct {
+		time int64
+		code string
+	}{
+		{59, "94287082"},
+		{1111111109, "07081804"},
+		{1111111111, "14050471"},
+		{1234567890, "89005924"},
+		{2000000000, "69279037"},
+		{20000000000, "65353130"},
+	} {
+		expected := c.code[len(c.code) - TOTP_DIGITS:]
+		if code := totpCode(key, c.time / TOTP_PERIOD); code != expected {
+			t.Fatalf("Time %d: expected %s, got %s", c.time, expected, code)
+		}
+	}
+}
+
+func TestCheckTotp(t *testing.T) {
+	key := []byte("12345678901234567890")
+	secret := totpEncoding.EncodeToString(key)
+	current := time.Now().Unix() / TOTP_PERIOD
+	for _, c := range []struct {
+		name string
+		code string
+		lastStep int64
+		step int64
+		ok bool
+	}{
+		{"current", totpCode(key, current), 0, current, true},
+		{"previous", totpCode(key, current - TOTP_SKEW), 0, current - TOTP_SKEW, true},
+		{"next", totpCode(key, current + TOTP_SKEW), 0, current + TOTP_SKEW, true},
+		{"too old", totpCode(key, current - TOTP_SKEW - 1), 0, 0, false},
+		{"too new", totpCode(key, current + TOTP_SKEW + 1), 0, 0, false},
+		{"used", totpCode(key, current), current, 0, false},
+		{"after the used one", totpCode(key, current + TOTP_SKEW), current, current + TOTP_SKEW, true},
+		{"another key", totpCode([]byte("another key"), current), 0, 0, false},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			// The period could change between the code generation and the check
+			if time.Now().Unix() / TOTP_PERIOD != current {
+				t.Skip("Period has changed")
+			}
+			step, ok := checkTotp(secret, c.code, c.lastStep)
+			if ok != c.ok || (ok && step != c.step) {
+				t.Fatalf("Expected %v at %d, got %v at %d", c.ok, c.step, ok, step)
+			}
+		})
+	}
+	if _, ok := checkTotp("not base32!", totpCode(key, current), 0); ok {
+		t.Fatal("Invalid secret is accepted")
+	}
+}
+
+func TestDisableTotp(t *testing.T) {
+	key := []byte("12345678901234567890")
+	secret := totpEncoding.EncodeToString(key)
+	recovery := "abcde-01234"
+	for _, c := range []struct {
+		name string
+		password string
+		data TotpDisableData
+		err error
+	}{
+		{"password and code", "secret", TotpDisableData{Password: "secret"}, nil},
+		{"wrong password", "secret", TotpDisableData{Password: "wrong"}, ErrorInvalidPassword{}},
+		{"password and recovery code", "secret", TotpDisableData{Password: "secret", Code: recovery}, nil},
+		{"no password and code", "", TotpDisableData{}, nil},
+		{"no password and recovery code", "", TotpDisableData{Code: recovery}, ErrorInvalidTotp{}},
+		{"no password and wrong code", "", TotpDisableData{Code: "000000"}, ErrorInvalidTotp{}},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			a := &Auth{passwordCost: 4}
+			hash := ""
+			if c.password != "" {
+				hash = bcryptHash(t, c.password, 4)
+			}
+			users := newMemSource()
+			user := RegData{Id: bson.NewObjectId(), Name: "alice", Password: hash, Totp: secret, TotpLastStep: 1,
+				RecoveryCodes: []string{hashToken(recovery)}}
+			if err := users.Insert(user); err != nil {
+				t.Fatal(err)
+			}
+			if c.data.Code == "" {
+				c.data.Code = totpCode(key, time.Now().Unix() / TOTP_PERIOD)
+			} else if c.data.Code == "000000" && c.data.Code == totpCode(key, time.Now().Unix() / TOTP_PERIOD) {
+				t.Skip("The wrong code is current")
+			}
+			err := a.DisableTotp(users, &Session{Uid: user.Id}, &c.data)
+			if err != c.err {
+				t.Fatalf("Expected error %#v, got %#v", c.err, err)
+			}
+			var stored RegData
+			if err := users.FindOne(bson.M{"_id": user.Id}, &stored); err != nil {
+				t.Fatal(err)
+			}
+			if (stored.Totp == "") != (c.err == nil) {
+				t.Fatalf("Unexpected totp state %q", stored.Totp)
+			}
+		})
+	}
+}
@@ -10,18 +10,284 @@ type RegData struct {
 	Name string `json:"name" bson:"name"`
 	Password string `json:"password" bson:"password"`
 	Email string `json:"email" bson:"email"`
+	// Invite code, required in the invite-only registration mode
+	Invite string `json:"invite" bson:"-"`
+	// Accounts registered before email verification was introduced have no flag and stay verified
+	Unverified bool `json:"-" bson:"unverified,omitempty"`
+	Totp string `json:"-" bson:"totp,omitempty"`
+	TotpPending string `json:"-" bson:"totppending,omitempty"`
+	TotpLastStep int64 `json:"-" bson:"totplast,omitempty"`
+	RecoveryCodes []string `json:"-" bson:"recoverycodes,omitempty"`
+	Role string `json:"-" bson:"role,omitempty"`
+	Disabled bool `json:"-" bson:"disabled,omitempty"`
+	// Users created by identity providers have no password
+	Identities []Identity `json:"-" bson:"identities,omitempty"`
+	DisplayName string `json:"-" bson:"displayname,omitempty"`
+	Preferences Preferences `json:"-" bson:"preferences,omitempty"`
+	// Set while the account is scheduled for deletion
+	DeleteAt time.Time `json:"-" bson:"deleteat,omitempty"`
+	// Set when the grace period is over and the purge removes data of the account
+	Purging bool `json:"-" bson:"purging,omitempty"`
+}
+
+type Preferences struct {
+	Locale string    `json:"locale,omitempty"       bson:"locale,omitempty"`
+	RatingScale int  `json:"rating_scale,omitempty" bson:"ratingscale,omitempty"`
+}
+
+type Identity struct {
+	Provider string `json:"provider" bson:"provider"`
+	Subject string  `json:"subject" bson:"subject"`
 }
 
 type AuthData struct {
 	Name string `json:"name" bson:"name"`
 	Password string `json:"password" bson:"password"`
+	// Totp or recovery code, required when two-factor authentication is enabled
+	Code string `json:"code" bson:"-"`
+	Remember bool `json:"remember" bson:"-"`
 }
 
 type Session struct {
-	Id bson.ObjectId `bson:"_id"`
-	Sid string `bson:"sid"`
+	Id bson.ObjectId      `json:"id"         bson:"_id"`
+	Sid string            `json:"-"          bson:"sid"`
+	Uid bson.ObjectId     `json:"-"          bson:"uid"`
+	Name string           `json:"-"          bson:"name"`
+	Email string          `json:"-"          bson:"email"`
+	Unverified bool       `json:"-"          bson:"unverified,omitempty"`
+	UserAgent string      `json:"user_agent" bson:"useragent"`
+	Ip string             `json:"ip"         bson:"ip"`
+	Created time.Time     `json:"created"    bson:"created"`
+	LastSeen time.Time    `json:"last_seen"  bson:"lastseen"`
+	Expires time.Time     `json:"expires"    bson:"expires,omitempty"`
+	// Session without "remember me", it has the short idle timeout and the browser session cookie
+	Short bool            `json:"-"          bson:"short,omitempty"`
+	Current bool          `json:"current"    bson:"-"`
+	// Scope of the api key the request is authorized with, it's empty for sessions
+	Scope string          `json:"-"          bson:"-"`
+	// Synchronizer token required in mutating requests authenticated by the cookie
+	Csrf string           `json:"-"          bson:"csrf,omitempty"`
+}
+
+type SessionId struct {
+	Id bson.ObjectId `json:"id"`
+}
+
+type ClientInfo struct {
+	UserAgent string
+	Ip string
+}
+type ChangePasswordData struct {
+	OldPassword string `json:"old_password"`
+	NewPassword string `json:"new_password"`
+}
+
+type ResetRequestData struct {
+	Email string `json:"email"`
+}
+
+type ResetPasswordData struct {
+	Token string `json:"token"`
+	Password string `json:"password"`
+}
+
+type ResetToken struct {
+	Id bson.ObjectId  `bson:"_id"`
+	Hash string       `bson:"hash"`
+	Uid bson.ObjectId `bson:"uid"`
+	Created time.Time `bson:"created"`
+}
+
+type VerifyToken struct {
+	Id bson.ObjectId  `bson:"_id"`
+	Hash string       `bson:"hash"`
 	Uid bson.ObjectId `bson:"uid"`
-	Name string `bson:"name"`
-	Email string `bson:"email"`
+	Email string      `bson:"email"`
 	Created time.Time `bson:"created"`
-}
\ No newline at end of file
+}
+
+type IntrospectData struct {
+	Token string `json:"token"`
+}
+
+type Introspection struct {
+	Active bool              `json:"active"`
+	Uid bson.ObjectId        `json:"uid,omitempty"`
+	SessionId bson.ObjectId  `json:"session_id,omitempty"`
+	Name string              `json:"name,omitempty"`
+	Unverified bool          `json:"unverified,omitempty"`
+	Scope string             `json:"scope,omitempty"`
+	Expires time.Time        `json:"expires"`
+	// CSRF token of the session, services check it for requests with the sid cookie
+	Csrf string              `json:"csrf,omitempty"`
+}
+
+type UserStatus struct {
+	Active bool `json:"active"`
+}
+
+type CsrfToken struct {
+	Token string `json:"csrf_token"`
+}
+
+type UnlockData struct {
+	Name string `json:"name"`
+	Ip string   `json:"ip"`
+}
+
+type LockEvent struct {
+	Id bson.ObjectId  `json:"id"                 bson:"_id"`
+	Type string       `json:"type"               bson:"type"`
+	Name string       `json:"name,omitempty"     bson:"name,omitempty"`
+	Ip string         `json:"ip,omitempty"       bson:"ip,omitempty"`
+	Failures int      `json:"failures,omitempty" bson:"failures,omitempty"`
+	Until time.Time   `json:"until"              bson:"until,omitempty"`
+	By bson.ObjectId  `json:"by,omitempty"       bson:"by,omitempty"`
+	Created time.Time `json:"created"            bson:"created"`
+}
+
+type TotpEnrollment struct {
+	Secret string `json:"secret"`
+	Uri string    `json:"uri"`
+}
+
+type TotpCodeData struct {
+	Code string `json:"code"`
+}
+
+type TotpDisableData struct {
+	Password string `json:"password"`
+	Code string     `json:"code"`
+}
+
+type RecoveryCodes struct {
+	Codes []string `json:"recovery_codes"`
+}
+
+type UserId struct {
+	Id bson.ObjectId `json:"id"`
+}
+
+type UserInfo struct {
+	Id bson.ObjectId `json:"id"         bson:"_id"`
+	Name string      `json:"name"       bson:"name"`
+	Email string     `json:"email"      bson:"email"`
+	Role string      `json:"role"       bson:"role"`
+	Disabled bool    `json:"disabled"   bson:"disabled"`
+	Unverified bool  `json:"unverified" bson:"unverified"`
+	Totp bool        `json:"totp"       bson:"-"`
+}
+
+type ApiKeyData struct {
+	Name string   `json:"name"`
+	Scope string  `json:"scope"`
+	// Days before the key expires, the key never expires when it's 0
+	ExpiresIn int `json:"expires_in"`
+}
+
+type ApiKey struct {
+	Id bson.ObjectId   `json:"id"                bson:"_id"`
+	Uid bson.ObjectId  `json:"-"                 bson:"uid"`
+	Name string        `json:"name"              bson:"name"`
+	Prefix string      `json:"prefix"            bson:"prefix"`
+	Hash string        `json:"-"                 bson:"hash"`
+	Scope string       `json:"scope"             bson:"scope"`
+	Created time.Time  `json:"created"           bson:"created"`
+	Expires time.Time  `json:"expires,omitempty" bson:"expires,omitempty"`
+	LastUsed time.Time `json:"last_used"         bson:"lastused,omitempty"`
+}
+
+type ApiKeyId struct {
+	Id bson.ObjectId `json:"id"`
+}
+
+type NewApiKey struct {
+	ApiKey
+	Key string `json:"key"`
+}
+
+type Profile struct {
+	Name string              `json:"name"`
+	Email string             `json:"email"`
+	EmailVerified bool       `json:"email_verified"`
+	DisplayName string       `json:"display_name"`
+	Preferences Preferences  `json:"preferences"`
+	DeleteAt *time.Time      `json:"delete_at,omitempty"`
+}
+
+// ProfileData contains only changed fields, absent ones are kept
+type ProfileData struct {
+	Name *string             `json:"name"`
+	Email *string            `json:"email"`
+	DisplayName *string      `json:"display_name"`
+	Preferences *Preferences `json:"preferences"`
+}
+
+type AccountExport struct {
+	Exported time.Time `json:"exported"`
+	Profile *Profile   `json:"profile"`
+	Role string        `json:"role"`
+	// Two-factor authentication is enabled
+	Totp bool          `json:"totp"`
+	Identities []Identity `json:"identities"`
+	Sessions []Session `json:"sessions"`
+	ApiKeys []ApiKey   `json:"api_keys"`
+	Invites []Invite   `json:"invites"`
+	AuditEvents []AuditEvent `json:"audit_events"`
+	Units []bson.M     `json:"units"`
+	Answers []bson.M   `json:"answers"`
+	Lists []bson.M     `json:"lists"`
+	Diary []bson.M     `json:"diary"`
+	History []bson.M   `json:"history"`
+}
+
+type DeletionInfo struct {
+	DeleteAt time.Time `json:"delete_at"`
+}
+
+type AuditEvent struct {
+	Id bson.ObjectId   `json:"id"                bson:"_id"`
+	Type string        `json:"type"              bson:"type"`
+	Uid bson.ObjectId  `json:"uid,omitempty"     bson:"uid,omitempty"`
+	Name string        `json:"name,omitempty"    bson:"name,omitempty"`
+	// User affected by the admin action
+	Target bson.ObjectId `json:"target,omitempty" bson:"target,omitempty"`
+	Ip string          `json:"ip"                bson:"ip"`
+	UserAgent string   `json:"user_agent"        bson:"useragent"`
+	Outcome string     `json:"outcome"           bson:"outcome"`
+	Reason string      `json:"reason,omitempty"  bson:"reason,omitempty"`
+	Created time.Time  `json:"created"           bson:"created"`
+	Expires time.Time  `json:"-"                 bson:"expires"`
+}
+
+type AuditFilter struct {
+	Uid bson.ObjectId
+	Type string
+	From time.Time
+	To time.Time
+}
+
+type InviteData struct {
+	// Days before the invite expires
+	ExpiresIn int `json:"expires_in"`
+}
+
+type Invite struct {
+	Id bson.ObjectId     `json:"id"                bson:"_id"`
+	Uid bson.ObjectId    `json:"created_by"        bson:"uid"`
+	Prefix string        `json:"prefix"            bson:"prefix"`
+	Hash string          `json:"-"                 bson:"hash"`
+	Created time.Time    `json:"created"           bson:"created"`
+	Expires time.Time    `json:"expires"           bson:"expires"`
+	UsedBy bson.ObjectId `json:"used_by,omitempty" bson:"usedby,omitempty"`
+	Used time.Time       `json:"used,omitempty"    bson:"used,omitempty"`
+}
+
+type InviteId struct {
+	Id bson.ObjectId `json:"id"`
+}
+
+type NewInvite struct {
+	Invite
+	Code string `json:"code"`
+}
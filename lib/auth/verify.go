@@ -0,0 +1,104 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+	"github.com/dzendmitry/rating-service/lib/general"
+)
+
+const (
+	VERIFY_NONCE_SIZE = 32
+	VERIFY_TOKEN_EXPIRES = 86400
+	// Minimal interval between two verification emails and max emails per VERIFY_TOKEN_EXPIRES (seconds)
+	VERIFY_RESEND_INTERVAL = 60
+	VERIFY_RESEND_LIMIT = 5
+)
+
+// Verification token is a random nonce followed by hmac of the nonce, user id and email,
+// so the link becomes invalid after the email is changed
+func (a *Auth) signVerifyToken(nonce string, uid bson.ObjectId, email string) string {
+	mac := hmac.New(sha256.New, a.verifySecret)
+	mac.Write([]byte(nonce + " " + uid.Hex() + " " + email))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (a *Auth) NewVerifyToken(tokens IAuthDataSource, user *RegData) (string, error) {
+	nonce, err := general.GetRandomToken(VERIFY_NONCE_SIZE)
+	if err != nil {
+		return "", err
+	}
+	if err := tokens.Insert(VerifyToken{
+		Id: bson.NewObjectId(),
+		Hash: hashToken(nonce),
+		Uid: user.Id,
+		Email: user.Email,
+		Created: time.Now(),
+	}); err != nil {
+		return "", err
+	}
+	return nonce + a.signVerifyToken(nonce, user.Id, user.Email), nil
+}
+
+func (a *Auth) ResendVerification(users IAuthDataSource, tokens IAuthDataSource, current *Session) (string, *RegData, error) {
+	var user RegData
+	if err := users.FindOne(bson.M{"_id": current.Uid}, &user); err != nil {
+		if err.Error() == "not found" {
+			return "", nil, ErrorUsersDoesntExist{}
+		}
+		return "", nil, err
+	}
+	if !user.Unverified {
+		return "", nil, ErrorAlreadyVerified{}
+	}
+	now := time.Now()
+	recent, err := tokens.Count(bson.M{"uid": user.Id, "created": bson.M{"$gt": now.Add(-VERIFY_RESEND_INTERVAL * time.Second)}})
+	if err != nil {
+		return "", nil, err
+	}
+	total, err := tokens.Count(bson.M{"uid": user.Id, "created": bson.M{"$gt": now.Add(-VERIFY_TOKEN_EXPIRES * time.Second)}})
+	if err != nil {
+		return "", nil, err
+	}
+	if recent > 0 || total >= VERIFY_RESEND_LIMIT {
+		return "", nil, ErrorTooManyRequests{}
+	}
+	token, err := a.NewVerifyToken(tokens, &user)
+	if err != nil {
+		return "", nil, err
+	}
+	return token, &user, nil
+}
+
+func (a *Auth) VerifyEmail(users IAuthDataSource, sessions IAuthDataSource, tokens IAuthDataSource, token string) (bson.ObjectId, error) {
+	if len(token) != VERIFY_NONCE_SIZE * 2 + sha256.Size * 2 {
+		return "", ErrorInvalidToken{}
+	}
+	nonce, sig := token[:VERIFY_NONCE_SIZE * 2], token[VERIFY_NONCE_SIZE * 2:]
+	var vt VerifyToken
+	if err := tokens.FindOne(bson.M{"hash": hashToken(nonce)}, &vt); err != nil {
+		if err.Error() == "not found" {
+			return "", ErrorInvalidToken{}
+		}
+		return "", err
+	}
+	if time.Since(vt.Created) > VERIFY_TOKEN_EXPIRES * time.Second {
+		return "", ErrorInvalidToken{}
+	}
+	if !hmac.Equal([]byte(sig), []byte(a.signVerifyToken(nonce, vt.Uid, vt.Email))) {
+		return "", ErrorInvalidToken{}
+	}
+	if err := users.Update(bson.M{"_id": vt.Uid, "email": vt.Email}, bson.M{"$unset": bson.M{"unverified": ""}}); err != nil {
+		if err.Error() == "not found" {
+			return "", ErrorInvalidToken{}
+		}
+		return "", err
+	}
+	if err := sessions.UpdateAll(bson.M{"uid": vt.Uid}, bson.M{"$unset": bson.M{"unverified": ""}}); err != nil {
+		return vt.Uid, err
+	}
+	return vt.Uid, tokens.RemoveAll(bson.M{"uid": vt.Uid})
+}
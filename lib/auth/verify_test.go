@@ -0,0 +1,118 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestSignVerifyToken(t *testing.T) {
+	a := &Auth{verifySecret: []byte("secret")}
+	uid := bson.NewObjectId()
+	sig := a.signVerifyToken("nonce", uid, "alice@example.com")
+	if sig != a.signVerifyToken("nonce", uid, "alice@example.com") {
+		t.Fatal("The signature isn't deterministic")
+	}
+	for _, c := range []struct {
+		name string
+		secret string
+		nonce string
+		uid bson.ObjectId
+		email string
+	}{
+		{"another secret", "another", "nonce", uid, "alice@example.com"},
+		{"another nonce", "secret", "nonce2", uid, "alice@example.com"},
+		{"another user", "secret", "nonce", bson.NewObjectId(), "alice@example.com"},
+		{"another email", "secret", "nonce", uid, "bob@example.com"},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			other := &Auth{verifySecret: []byte(c.secret)}
+			if other.signVerifyToken(c.nonce, c.uid, c.email) == sig {
+				t.Fatal("The signature doesn't depend on the input")
+			}
+		})
+	}
+}
+
+func TestVerifyEmail(t *testing.T) {
+	for _, c := range []struct {
+		name string
+		// change returns the token sent to the verification
+		change func(a *Auth, users, tokens *memSource, token string) string
+		ok bool
+	}{
+		{name: "valid", ok: true},
+		{
+			name: "wrong signature",
+			change: func(a *Auth, users, tokens *memSource, token string) string {
+				return token[:VERIFY_NONCE_SIZE * 2] + strings.Repeat("0", len(token) - VERIFY_NONCE_SIZE * 2)
+			},
+		},
+		{
+			name: "truncated",
+			change: func(a *Auth, users, tokens *memSource, token string) string { return token[:len(token) - 1] },
+		},
+		{
+			name: "signed with another secret",
+			change: func(a *Auth, users, tokens *memSource, token string) string {
+				a.verifySecret = []byte("another")
+				return token
+			},
+		},
+		{
+			name: "expired",
+			change: func(a *Auth, users, tokens *memSource, token string) string {
+				tokens.UpdateAll(bson.M{}, bson.M{"$set": bson.M{"created": time.Now().Add(-VERIFY_TOKEN_EXPIRES * time.Second - time.Minute)}})
+				return token
+			},
+		},
+		{
+			name: "email changed",
+			change: func(a *Auth, users, tokens *memSource, token string) string {
+				users.UpdateAll(bson.M{}, bson.M{"$set": bson.M{"email": "bob@example.com"}})
+				return token
+			},
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			a := &Auth{verifySecret: []byte("secret")}
+			users, sessions, tokens := newMemSource(), newMemSource(), newMemSource()
+			user := RegData{Id: bson.NewObjectId(), Name: "alice", Email: "alice@example.com", Unverified: true}
+			if err := users.Insert(user); err != nil {
+				t.Fatal(err)
+			}
+			token, err := a.NewVerifyToken(tokens, &user)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c.change != nil {
+				token = c.change(a, users, tokens, token)
+			}
+			uid, err := a.VerifyEmail(users, sessions, tokens, token)
+			var stored RegData
+			if err := users.FindOne(bson.M{"_id": user.Id}, &stored); err != nil {
+				t.Fatal(err)
+			}
+			if !c.ok {
+				if _, ok := err.(ErrorInvalidToken); !ok {
+					t.Fatalf("Expected ErrorInvalidToken, got %#v", err)
+				}
+				if !stored.Unverified {
+					t.Fatal("The email is verified by the invalid token")
+				}
+				return
+			}
+			if err != nil || uid != user.Id {
+				t.Fatalf("Verification failed: %s %+v", uid, err)
+			}
+			if stored.Unverified {
+				t.Fatal("The email isn't verified")
+			}
+			if n, _ := tokens.Count(bson.M{"uid": user.Id}); n != 0 {
+				t.Fatal("Used tokens aren't removed")
+			}
+		})
+	}
+}
@@ -0,0 +1,255 @@
+package content
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"gopkg.in/mgo.v2/bson"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/dzendmitry/rating-service/lib/general"
+)
+
+const (
+	SORT_CREATED = "created"
+	SORT_EDITED = "edited"
+	SORT_STARS = "stars"
+	SORT_TITLE = "title"
+	SORT_YEAR = "year"
+
+	ORDER_ASC = "asc"
+	ORDER_DESC = "desc"
+
+	PAGE_LIMIT = 50
+	MAX_LIMIT = 200
+)
+
+var sortFields = map[string]bool{
+	SORT_CREATED: true,
+	SORT_EDITED: true,
+	SORT_STARS: true,
+	SORT_TITLE: true,
+	SORT_YEAR: true,
+}
+
+type IContentDataSource interface {
+	FindRange(query interface{}, sort []string, skip, limit int, result interface{}) error
+	FindOne(query interface{}, result interface{}) error
+	UpdateAll(selector, update interface{}) error
+	Aggregate(pipeline interface{}, result interface{}) error
+}
+
+// ParseQuery reads sorting, filters and the cursor from request parameters.
+// The newest units go first by default.
+func ParseQuery(form url.Values, unitType string) (*Query, error) {
+	q := &Query{Type: unitType, Sort: SORT_CREATED, Desc: true, Limit: PAGE_LIMIT}
+	if s := form.Get("sort"); s != "" {
+		if !sortFields[s] {
+			return nil, ErrorInvalidQuery{fmt.Sprintf("Unknown sort field: %s", s)}
+		}
+		q.Sort = s
+	}
+	switch form.Get("order") {
+	case "":
+	case ORDER_ASC:
+		q.Desc = false
+	case ORDER_DESC:
+		q.Desc = true
+	default:
+		return nil, ErrorInvalidQuery{fmt.Sprintf("order must be %s or %s", ORDER_ASC, ORDER_DESC)}
+	}
+	for _, p := range []struct {
+		name string
+		v *int
+	}{{"limit", &q.Limit}, {"stars_min", &q.StarsMin}, {"stars_max", &q.StarsMax},
+		{"year_min", &q.YearMin}, {"year_max", &q.YearMax}} {
+		if v := form.Get(p.name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				return nil, ErrorInvalidQuery{fmt.Sprintf("%s must be a non-negative integer", p.name)}
+			}
+			*p.v = n
+		}
+	}
+	if q.Limit == 0 {
+		q.Limit = PAGE_LIMIT
+	}
+	if q.Limit > MAX_LIMIT {
+		q.Limit = MAX_LIMIT
+	}
+	if v := form.Get("edited_since"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return nil, ErrorInvalidQuery{"Invalid edited_since, RFC 3339 time is expected"}
+		}
+		q.EditedSince = t
+	}
+	if v := form.Get("has_comment"); v != "" {
+		has, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, ErrorInvalidQuery{"has_comment must be a boolean"}
+		}
+		q.HasComment = &has
+	}
+	if tags, ok := form["tag"]; ok {
+		q.Tags = NormalizeTags(tags)
+		if len(q.Tags) == 0 {
+			return nil, ErrorInvalidQuery{"tag is empty"}
+		}
+	}
+	for _, s := range form["status"] {
+		if !general.Statuses[s] {
+			return nil, ErrorInvalidQuery{fmt.Sprintf("Unknown status: %s", s)}
+		}
+		q.Statuses = append(q.Statuses, s)
+	}
+	if v := form.Get("cursor"); v != "" {
+		c, err := decodeCursor(v)
+		if err != nil {
+			return nil, err
+		}
+		if c.Sort != q.Sort || c.Desc != q.Desc {
+			return nil, ErrorInvalidQuery{"Cursor belongs to another sort order"}
+		}
+		q.Cursor = c
+	}
+	return q, nil
+}
+
+func decodeCursor(s string) (*Cursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrorInvalidCursor{}
+	}
+	var c Cursor
+	if err := json.Unmarshal(data, &c); err != nil || !c.Id.Valid() || !sortFields[c.Sort] {
+		return nil, ErrorInvalidCursor{}
+	}
+	return &c, nil
+}
+
+func encodeCursor(c *Cursor) (string, error) {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+func newCursor(q *Query, u *general.ContentUnit) *Cursor {
+	c := &Cursor{Sort: q.Sort, Desc: q.Desc, Id: u.Id}
+	switch q.Sort {
+	case SORT_CREATED:
+		c.Time = u.Created
+	case SORT_EDITED:
+		c.Time = u.Edited
+	case SORT_STARS:
+		c.Int = u.Stars
+	case SORT_TITLE:
+		c.Str = u.Title
+	case SORT_YEAR:
+		c.Str = u.Year
+	}
+	return c
+}
+
+func (c *Cursor) value() interface{} {
+	switch c.Sort {
+	case SORT_CREATED, SORT_EDITED:
+		return c.Time
+	case SORT_STARS:
+		return c.Int
+	}
+	return c.Str
+}
+
+// Selector returns the mongo query of the filters without the cursor
+func (q *Query) Selector(uid bson.ObjectId) bson.M {
+	selector := bson.M{"uid": uid}
+	if q.Type != "" {
+		selector["type"] = q.Type
+	}
+	stars := bson.M{}
+	if q.StarsMin > 0 {
+		stars["$gte"] = q.StarsMin
+	}
+	if q.StarsMax > 0 {
+		stars["$lte"] = q.StarsMax
+	}
+	if len(stars) > 0 {
+		selector["stars"] = stars
+	}
+	// Year is a string like "1999" or "1999-2003", strings with 4 digit years compare as numbers
+	year := bson.M{}
+	if q.YearMin > 0 {
+		year["$gte"] = strconv.Itoa(q.YearMin)
+	}
+	if q.YearMax > 0 {
+		year["$lt"] = strconv.Itoa(q.YearMax + 1)
+		// Units without year are empty strings, they would be less than any year
+		if q.YearMin == 0 {
+			year["$gt"] = ""
+		}
+	}
+	if len(year) > 0 {
+		selector["year"] = year
+	}
+	if !q.EditedSince.IsZero() {
+		selector["edited"] = bson.M{"$gte": q.EditedSince}
+	}
+	if q.HasComment != nil {
+		if *q.HasComment {
+			selector["comment"] = bson.M{"$gt": ""}
+		} else {
+			selector["comment"] = bson.M{"$in": []interface{}{"", nil}}
+		}
+	}
+	if len(q.Tags) > 0 {
+		selector["tags"] = bson.M{"$all": q.Tags}
+	}
+	if len(q.Statuses) > 0 {
+		statuses := make([]interface{}, 0, len(q.Statuses) + 1)
+		for _, s := range q.Statuses {
+			statuses = append(statuses, s)
+			// Units without status were added rated and count as finished
+			if s == general.STATUS_FINISHED {
+				statuses = append(statuses, nil)
+			}
+		}
+		selector["status"] = bson.M{"$in": statuses}
+	}
+	return selector
+}
+
+// FindUnits returns a page of the user's units. The id breaks ties of the sort field,
+// so the cursor continues exactly after the last unit of the previous page.
+func FindUnits(units IContentDataSource, uid bson.ObjectId, q *Query) (*Page, error) {
+	selector := q.Selector(uid)
+	op, sort := "$gt", []string{q.Sort, "_id"}
+	if q.Desc {
+		op, sort = "$lt", []string{"-" + q.Sort, "-_id"}
+	}
+	if q.Cursor != nil {
+		v := q.Cursor.value()
+		selector = bson.M{"$and": []bson.M{selector, {"$or": []bson.M{
+			{q.Sort: bson.M{op: v}},
+			{q.Sort: v, "_id": bson.M{op: q.Cursor.Id}},
+		}}}}
+	}
+	page := &Page{Units: make([]general.ContentUnit, 0, q.Limit)}
+	// One more unit tells whether there is the next page
+	if err := units.FindRange(selector, sort, 0, q.Limit + 1, &page.Units); err != nil {
+		return nil, err
+	}
+	if len(page.Units) > q.Limit {
+		page.Units = page.Units[:q.Limit]
+		next, err := encodeCursor(newCursor(q, &page.Units[q.Limit - 1]))
+		if err != nil {
+			return nil, err
+		}
+		page.Next = next
+	}
+	return page, nil
+}
@@ -0,0 +1,206 @@
+package content
+
+import (
+	"encoding/base64"
+	"net/url"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+	"github.com/dzendmitry/rating-service/lib/general"
+)
+
+func TestParseQuery(t *testing.T) {
+	q, err := ParseQuery(url.Values{}, "film")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q.Type != "film" || q.Sort != SORT_CREATED || !q.Desc || q.Limit != PAGE_LIMIT || q.HasComment != nil {
+		t.Fatalf("Unexpected defaults %+v", q)
+	}
+	if q, err := ParseQuery(url.Values{"limit": {"1000"}}, ""); err != nil || q.Limit != MAX_LIMIT {
+		t.Fatalf("The limit isn't capped: %+v %v", q, err)
+	}
+
+	for _, form := range []string{
+		"sort=rating",
+		"order=up",
+		"limit=-1",
+		"stars_min=many",
+		"year_max=1999.5",
+		"edited_since=2017-01-02",
+		"has_comment=maybe",
+		"tag=%20",
+		"status=watched",
+		"cursor=%21%21",
+	} {
+		t.Run(form, func(t *testing.T) {
+			values, _ := url.ParseQuery(form)
+			if _, err := ParseQuery(values, ""); err == nil {
+				t.Fatal("The query is accepted")
+			}
+		})
+	}
+}
+
+func TestCursor(t *testing.T) {
+	c := &Cursor{Sort: SORT_EDITED, Desc: true, Time: time.Now().Truncate(time.Millisecond), Id: bson.NewObjectId()}
+	s, err := encodeCursor(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := decodeCursor(s)
+	if err != nil || decoded.Sort != c.Sort || decoded.Desc != c.Desc || !decoded.Time.Equal(c.Time) || decoded.Id != c.Id {
+		t.Fatalf("Unexpected cursor %+v %v", decoded, err)
+	}
+
+	// The cursor belongs to the descending edited order only
+	for _, form := range []url.Values{{"sort": {SORT_EDITED}, "order": {ORDER_ASC}}, {"sort": {SORT_STARS}}} {
+		form.Set("cursor", s)
+		if _, err := ParseQuery(form, ""); err == nil {
+			t.Fatalf("The cursor is accepted by %v", form)
+		}
+	}
+	if _, err := ParseQuery(url.Values{"sort": {SORT_EDITED}, "cursor": {s}}, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, invalid := range []string{
+		"not base64!",
+		base64.RawURLEncoding.EncodeToString([]byte("not json")),
+		base64.RawURLEncoding.EncodeToString([]byte(`{"s":"created"}`)),
+		base64.RawURLEncoding.EncodeToString([]byte(`{"s":"rating","id":"` + bson.NewObjectId().Hex() + `"}`)),
+	} {
+		if _, err := decodeCursor(invalid); err != (ErrorInvalidCursor{}) {
+			t.Fatalf("Expected ErrorInvalidCursor for %q, got %#v", invalid, err)
+		}
+	}
+}
+
+type unitsFixture struct {
+	units *memSource
+	uid bson.ObjectId
+}
+
+func newUnitsFixture(t *testing.T, units ...general.ContentUnit) *unitsFixture {
+	f := &unitsFixture{units: newMemSource(), uid: bson.NewObjectId()}
+	created := time.Now().Add(-time.Hour).Truncate(time.Millisecond)
+	for i, u := range units {
+		u.Id, u.Uid = bson.NewObjectId(), f.uid
+		if u.Created.IsZero() {
+			u.Created = created.Add(time.Duration(i) * time.Minute)
+		}
+		if u.Edited.IsZero() {
+			u.Edited = u.Created
+		}
+		if err := f.units.Insert(u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Units of another user are never found
+	if err := f.units.Insert(general.ContentUnit{Id: bson.NewObjectId(), Uid: bson.NewObjectId(), Title: "other", Stars: 5}); err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+// titles returns titles of all pages of the query
+func (f *unitsFixture) titles(t *testing.T, form url.Values) []string {
+	titles := []string{}
+	for {
+		q, err := ParseQuery(form, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		page, err := FindUnits(f.units, f.uid, q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, u := range page.Units {
+			titles = append(titles, u.Title)
+		}
+		if page.Next == "" {
+			return titles
+		}
+		if len(titles) > 100 {
+			t.Fatal("Pages don't end")
+		}
+		form.Set("cursor", page.Next)
+	}
+}
+
+func TestFindUnitsPages(t *testing.T) {
+	f := newUnitsFixture(t,
+		general.ContentUnit{Title: "a", Stars: 3, Year: "2001"},
+		general.ContentUnit{Title: "b", Stars: 5, Year: "1999"},
+		general.ContentUnit{Title: "c", Stars: 3, Year: "2001"},
+		general.ContentUnit{Title: "d", Stars: 1, Year: "1999-2003"},
+		general.ContentUnit{Title: "e", Stars: 3, Year: "2001"},
+		general.ContentUnit{Title: "f", Stars: 5, Year: "2010"},
+		general.ContentUnit{Title: "g", Stars: 3, Year: "2001"},
+	)
+	for _, c := range []struct {
+		form string
+		// Units with equal sort values follow in the order of ids, which is the order of creation
+		titles string
+	}{
+		{"", "gfedcba"},
+		{"order=asc", "abcdefg"},
+		{"sort=stars", "fbgecad"},
+		{"sort=stars&order=asc", "daceg" + "bf"},
+		{"sort=year&order=asc", "bdaceg" + "f"},
+		{"sort=title&order=desc", "gfedcba"},
+	} {
+		for _, limit := range []string{"1", "2", "3", "50"} {
+			t.Run(c.form + "&limit=" + limit, func(t *testing.T) {
+				form, _ := url.ParseQuery(c.form)
+				form.Set("limit", limit)
+				if titles := strings.Join(f.titles(t, form), ""); titles != c.titles {
+					t.Fatalf("Expected %s, got %s", c.titles, titles)
+				}
+			})
+		}
+	}
+}
+
+func TestFindUnitsFilters(t *testing.T) {
+	since := time.Now().Add(-time.Minute).Truncate(time.Millisecond)
+	f := newUnitsFixture(t,
+		general.ContentUnit{Title: "a", Stars: 5, Year: "1998", Comment: "liked", Tags: []string{"drama", "rewatch"}},
+		general.ContentUnit{Title: "b", Stars: 4, Year: "1999-2003", Edited: since.Add(time.Second), Tags: []string{"drama"}},
+		general.ContentUnit{Title: "c", Stars: 2, Year: "2003", Status: general.STATUS_FINISHED, Edited: since},
+		general.ContentUnit{Title: "d", Year: "2004", Status: general.STATUS_WANT, Comment: "later"},
+		general.ContentUnit{Title: "e", Stars: 3, Status: general.STATUS_IN_PROGRESS, Tags: []string{"rewatch"}},
+	)
+	for _, c := range []struct {
+		form string
+		titles string
+	}{
+		{"stars_min=3", "abe"},
+		{"stars_max=3", "cde"},
+		{"stars_min=2&stars_max=4", "bce"},
+		// Years are compared as strings, a range counts from its first year
+		{"year_min=1999", "bcd"},
+		{"year_max=1999", "ab"},
+		{"year_min=1999&year_max=2003", "bc"},
+		{"year_min=2004", "d"},
+		{"edited_since=" + url.QueryEscape(since.Format(time.RFC3339Nano)), "bc"},
+		{"has_comment=true", "ad"},
+		{"has_comment=false", "bce"},
+		{"tag=drama", "ab"},
+		{"tag=Drama&tag=rewatch", "a"},
+		{"status=finished", "abc"},
+		{"status=want&status=in_progress", "de"},
+	} {
+		t.Run(c.form, func(t *testing.T) {
+			form, _ := url.ParseQuery(c.form)
+			titles := f.titles(t, form)
+			sort.Strings(titles)
+			if s := strings.Join(titles, ""); s != c.titles {
+				t.Fatalf("Expected %s, got %s", c.titles, s)
+			}
+		})
+	}
+}
@@ -0,0 +1,413 @@
+package content
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// memSource is an in-memory data source which understands the subset of mongo queries used by the package
+type memSource struct {
+	mu sync.Mutex
+	docs []bson.M
+}
+
+func newMemSource() *memSource {
+	return &memSource{}
+}
+
+// toDoc normalizes values the same way they are stored by mongo
+func toDoc(v interface{}) bson.M {
+	data, err := bson.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	var m bson.M
+	if err := bson.Unmarshal(data, &m); err != nil {
+		panic(err)
+	}
+	return m
+}
+
+func fromDoc(m bson.M, result interface{}) error {
+	data, err := bson.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return bson.Unmarshal(data, result)
+}
+
+func compare(a, b interface{}) (int, bool) {
+	switch x := a.(type) {
+	case time.Time:
+		y, ok := b.(time.Time)
+		if !ok {
+			return 0, false
+		}
+		switch {
+		case x.Before(y):
+			return -1, true
+		case x.After(y):
+			return 1, true
+		}
+		return 0, true
+	case int, int32, int64:
+		xi, yi := reflect.ValueOf(a).Int(), int64(0)
+		switch y := b.(type) {
+		case int, int32, int64:
+			yi = reflect.ValueOf(y).Int()
+		default:
+			return 0, false
+		}
+		switch {
+		case xi < yi:
+			return -1, true
+		case xi > yi:
+			return 1, true
+		}
+		return 0, true
+	case string:
+		y, ok := b.(string)
+		if !ok {
+			return 0, false
+		}
+		return strings.Compare(x, y), true
+	case bson.ObjectId:
+		y, ok := b.(bson.ObjectId)
+		if !ok {
+			return 0, false
+		}
+		return strings.Compare(string(x), string(y)), true
+	}
+	return 0, false
+}
+
+func equalValue(field, v interface{}) bool {
+	if reflect.DeepEqual(field, v) {
+		return true
+	}
+	if c, ok := compare(field, v); ok && c == 0 {
+		return true
+	}
+	// Scalars match elements of arrays
+	if arr, ok := field.([]interface{}); ok {
+		for _, e := range arr {
+			if equalValue(e, v) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// lookup follows dotted paths into documents and arrays, fields of array elements are collected
+func lookup(doc bson.M, path string) (interface{}, bool) {
+	var value interface{} = doc
+	for _, key := range strings.Split(path, ".") {
+		switch v := value.(type) {
+		case bson.M:
+			f, ok := v[key]
+			if !ok {
+				return nil, false
+			}
+			value = f
+		case []interface{}:
+			if i, err := strconv.Atoi(key); err == nil {
+				if i >= len(v) {
+					return nil, false
+				}
+				value = v[i]
+				continue
+			}
+			collected := make([]interface{}, 0, len(v))
+			for _, e := range v {
+				if d, ok := e.(bson.M); ok {
+					if f, ok := d[key]; ok {
+						collected = append(collected, f)
+					}
+				}
+			}
+			if len(collected) == 0 {
+				return nil, false
+			}
+			value = collected
+		default:
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+func matchOperators(field interface{}, exists bool, ops bson.M) bool {
+	for op, v := range ops {
+		switch op {
+		case "$ne":
+			if exists && equalValue(field, v) {
+				return false
+			}
+		case "$exists":
+			if exists != v.(bool) {
+				return false
+			}
+		case "$in":
+			found := false
+			for _, e := range v.([]interface{}) {
+				// Null matches missing fields
+				found = found || (exists && equalValue(field, e)) || (!exists && e == nil)
+			}
+			if !found {
+				return false
+			}
+		case "$all":
+			for _, e := range v.([]interface{}) {
+				if !exists || !equalValue(field, e) {
+					return false
+				}
+			}
+		case "$lt", "$lte", "$gt", "$gte":
+			c, ok := compare(field, v)
+			if !exists || !ok {
+				return false
+			}
+			if (op == "$lt" && c >= 0) || (op == "$lte" && c > 0) || (op == "$gt" && c <= 0) || (op == "$gte" && c < 0) {
+				return false
+			}
+		default:
+			panic("memSource doesn't support " + op)
+		}
+	}
+	return true
+}
+
+func matches(doc bson.M, selector bson.M) bool {
+	for k, v := range selector {
+		switch k {
+		case "$and", "$or":
+			matched := false
+			for _, s := range v.([]interface{}) {
+				m := matches(doc, s.(bson.M))
+				if k == "$and" && !m {
+					return false
+				}
+				matched = matched || m
+			}
+			if k == "$or" && !matched {
+				return false
+			}
+			continue
+		}
+		field, exists := lookup(doc, k)
+		if ops, ok := v.(bson.M); ok && len(ops) > 0 && strings.HasPrefix(firstKey(ops), "$") {
+			if !matchOperators(field, exists, ops) {
+				return false
+			}
+			continue
+		}
+		if !exists || !equalValue(field, v) {
+			return false
+		}
+	}
+	return true
+}
+
+func firstKey(m bson.M) string {
+	for k := range m {
+		return k
+	}
+	return ""
+}
+
+func apply(doc bson.M, update bson.M) {
+	for op, v := range update {
+		fields := v.(bson.M)
+		for k, value := range fields {
+			switch op {
+			case "$set":
+				doc[k] = value
+			case "$unset":
+				delete(doc, k)
+			case "$push":
+				arr, _ := doc[k].([]interface{})
+				each, ok := value.(bson.M)
+				if !ok || each["$each"] == nil {
+					doc[k] = append(arr, value)
+					continue
+				}
+				values := each["$each"].([]interface{})
+				pos := len(arr)
+				if p, ok := each["$position"].(int); ok && p < pos {
+					pos = p
+				}
+				pushed := make([]interface{}, 0, len(arr) + len(values))
+				pushed = append(append(append(pushed, arr[:pos]...), values...), arr[pos:]...)
+				doc[k] = pushed
+			case "$addToSet":
+				arr, _ := doc[k].([]interface{})
+				if !equalValue(arr, value) {
+					arr = append(arr, value)
+				}
+				doc[k] = arr
+			case "$pull":
+				arr, _ := doc[k].([]interface{})
+				kept := make([]interface{}, 0, len(arr))
+				for _, e := range arr {
+					cond, isCond := value.(bson.M)
+					d, isDoc := e.(bson.M)
+					if (isCond && isDoc && matches(d, cond)) || (!isCond && equalValue(e, value)) {
+						continue
+					}
+					kept = append(kept, e)
+				}
+				doc[k] = kept
+			default:
+				panic("memSource doesn't support " + op)
+			}
+		}
+	}
+}
+
+func (s *memSource) Insert(query interface{}) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	doc := toDoc(query)
+	if _, ok := doc["_id"]; !ok {
+		doc["_id"] = bson.NewObjectId()
+	}
+	for _, d := range s.docs {
+		if reflect.DeepEqual(d["_id"], doc["_id"]) {
+			return errors.New("E11000 duplicate key error index: _id dup key")
+		}
+	}
+	s.docs = append(s.docs, doc)
+	return nil
+}
+
+func (s *memSource) find(query interface{}) []bson.M {
+	selector := bson.M{}
+	if query != nil {
+		selector = toDoc(query)
+	}
+	found := make([]bson.M, 0)
+	for _, d := range s.docs {
+		if matches(d, selector) {
+			found = append(found, d)
+		}
+	}
+	return found
+}
+
+func (s *memSource) FindOne(query interface{}, result interface{}) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	found := s.find(query)
+	if len(found) == 0 {
+		return errors.New("not found")
+	}
+	return fromDoc(found[0], result)
+}
+
+func (s *memSource) FindAll(query interface{}, result interface{}) error {
+	return s.FindRange(query, nil, 0, 0, result)
+}
+
+func (s *memSource) FindRange(query interface{}, order []string, skip, limit int, result interface{}) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	found := s.find(query)
+	sort.SliceStable(found, func(i, j int) bool {
+		for _, k := range order {
+			desc := strings.HasPrefix(k, "-")
+			k = strings.TrimPrefix(k, "-")
+			c, _ := compare(found[i][k], found[j][k])
+			if c != 0 {
+				return (c < 0) != desc
+			}
+		}
+		return false
+	})
+	if skip > len(found) {
+		skip = len(found)
+	}
+	found = found[skip:]
+	if limit > 0 && limit < len(found) {
+		found = found[:limit]
+	}
+	slice := reflect.ValueOf(result).Elem()
+	slice.Set(reflect.MakeSlice(slice.Type(), 0, len(found)))
+	for _, d := range found {
+		e := reflect.New(slice.Type().Elem())
+		if err := fromDoc(d, e.Interface()); err != nil {
+			return err
+		}
+		slice.Set(reflect.Append(slice, e.Elem()))
+	}
+	return nil
+}
+
+func (s *memSource) Aggregate(pipeline interface{}, result interface{}) error {
+	return errors.New("memSource doesn't support aggregation")
+}
+
+func (s *memSource) Remove(selector interface{}) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sel := toDoc(selector)
+	for i, d := range s.docs {
+		if matches(d, sel) {
+			s.docs = append(s.docs[:i], s.docs[i + 1:]...)
+			return nil
+		}
+	}
+	return errors.New("not found")
+}
+
+func (s *memSource) RemoveAll(selector interface{}) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sel := toDoc(selector)
+	kept := make([]bson.M, 0, len(s.docs))
+	for _, d := range s.docs {
+		if !matches(d, sel) {
+			kept = append(kept, d)
+		}
+	}
+	s.docs = kept
+	return nil
+}
+
+func (s *memSource) Update(selector, update interface{}) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sel, upd := toDoc(selector), toDoc(update)
+	for _, d := range s.docs {
+		if matches(d, sel) {
+			apply(d, upd)
+			return nil
+		}
+	}
+	return errors.New("not found")
+}
+
+func (s *memSource) UpdateAll(selector, update interface{}) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sel, upd := toDoc(selector), toDoc(update)
+	for _, d := range s.docs {
+		if matches(d, sel) {
+			apply(d, upd)
+		}
+	}
+	return nil
+}
+
+func (s *memSource) Count(query interface{}) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.find(query)), nil
+}
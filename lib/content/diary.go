@@ -0,0 +1,275 @@
+package content
+
+import (
+	"gopkg.in/mgo.v2/bson"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/dzendmitry/rating-service/lib/general"
+)
+
+const (
+	DIARY_PAGE_LIMIT = 50
+	DIARY_MAX_LIMIT = 200
+	// Dates a bit in the future are allowed for time zones of clients
+	DIARY_FUTURE_SKEW = 24 * time.Hour
+)
+
+type IDiaryDataSource interface {
+	Insert(query interface{}) error
+	FindOne(query interface{}, result interface{}) error
+	FindRange(query interface{}, sort []string, skip, limit int, result interface{}) error
+	Update(selector, update interface{}) error
+	Remove(selector interface{}) error
+	RemoveAll(selector interface{}) error
+}
+
+func checkDiaryDate(date *time.Time) error {
+	if date != nil && date.After(time.Now().Add(DIARY_FUTURE_SKEW)) {
+		return ErrorInvalidUnit{"Diary date is in the future"}
+	}
+	return nil
+}
+
+// AddDiaryEntry records watching or reading of the unit, now by default. The unit takes the rating
+// of its latest rated entry, units the user wanted become finished.
+func AddDiaryEntry(diary IDiaryDataSource, units IUnitDataSource, history IHistoryDataSource, uid bson.ObjectId,
+	data *DiaryEntryData, source string) (*DiaryEntry, error) {
+	if !data.Unit.Valid() {
+		return nil, ErrorInvalidQuery{"unit_id is required"}
+	}
+	if err := checkDiaryDate(data.Date); err != nil {
+		return nil, err
+	}
+	var unit general.ContentUnit
+	if err := units.FindOne(bson.M{"_id": data.Unit, "uid": uid}, &unit); err != nil {
+		if err.Error() == "not found" {
+			return nil, ErrorUnitNotFound{}
+		}
+		return nil, err
+	}
+	now := time.Now()
+	entry := &DiaryEntry{
+		Id: bson.NewObjectId(),
+		Uid: uid,
+		Unit: data.Unit,
+		Date: now,
+		Created: now,
+	}
+	if data.Date != nil {
+		entry.Date = *data.Date
+	}
+	entry.Stars = data.Stars
+	if data.Note != nil {
+		entry.Note = *data.Note
+	}
+	if err := diary.Insert(entry); err != nil {
+		return nil, err
+	}
+	return entry, syncRating(diary, units, history, uid, data.Unit, source, &entry.Date, false)
+}
+
+// EditDiaryEntry changes only fields present in the data
+func EditDiaryEntry(diary IDiaryDataSource, units IUnitDataSource, history IHistoryDataSource, uid bson.ObjectId,
+	data *DiaryEntryData, source string) error {
+	if err := checkDiaryDate(data.Date); err != nil {
+		return err
+	}
+	var entry DiaryEntry
+	if err := diary.FindOne(bson.M{"_id": data.Id, "uid": uid}, &entry); err != nil {
+		return diaryNotFound(err)
+	}
+	set := bson.M{}
+	if data.Date != nil {
+		set["date"] = *data.Date
+	}
+	if data.Stars != nil {
+		set["stars"] = *data.Stars
+	}
+	if data.Note != nil {
+		set["note"] = *data.Note
+	}
+	if len(set) == 0 {
+		return nil
+	}
+	if err := diary.Update(bson.M{"_id": data.Id, "uid": uid}, bson.M{"$set": set}); err != nil {
+		return diaryNotFound(err)
+	}
+	return syncRating(diary, units, history, uid, entry.Unit, source, nil, false)
+}
+
+// RemoveDiaryEntry removes the entry, the unit becomes unrated when no rated entries remain
+func RemoveDiaryEntry(diary IDiaryDataSource, units IUnitDataSource, history IHistoryDataSource, uid bson.ObjectId,
+	id bson.ObjectId, source string) error {
+	var entry DiaryEntry
+	if err := diary.FindOne(bson.M{"_id": id, "uid": uid}, &entry); err != nil {
+		return diaryNotFound(err)
+	}
+	if err := diary.Remove(bson.M{"_id": id, "uid": uid}); err != nil {
+		return diaryNotFound(err)
+	}
+	return syncRating(diary, units, history, uid, entry.Unit, source, nil, entry.Stars != nil)
+}
+
+// RemoveUnitDiary removes entries of the removed unit
+func RemoveUnitDiary(diary IDiaryDataSource, uid bson.ObjectId, unit bson.ObjectId) error {
+	if err := diary.RemoveAll(bson.M{"uid": uid, "unit": unit}); err != nil && err.Error() != "not found" {
+		return err
+	}
+	return nil
+}
+
+func diaryNotFound(err error) error {
+	if err != nil && err.Error() == "not found" {
+		return ErrorDiaryEntryNotFound{}
+	}
+	return err
+}
+
+// syncRating sets stars of the unit from its latest rated entry, unrated entries don't change the rating.
+// When the removed entry was the last rated one the unit becomes unrated, otherwise units without rated
+// entries keep stars set by edits. The unit the user wanted becomes finished at the given date, or at
+// the date of the rated entry, since wanted units have no stars. The change is recorded to the history.
+func syncRating(diary IDiaryDataSource, units IUnitDataSource, history IHistoryDataSource, uid bson.ObjectId,
+	id bson.ObjectId, source string, finished *time.Time, removedRated bool) error {
+	var rated []DiaryEntry
+	if err := diary.FindRange(bson.M{"uid": uid, "unit": id, "stars": bson.M{"$exists": true}}, []string{"-date", "-_id"},
+		0, 1, &rated); err != nil {
+		return err
+	}
+	var unit general.ContentUnit
+	if err := units.FindOne(bson.M{"_id": id, "uid": uid}, &unit); err != nil {
+		if err.Error() == "not found" {
+			return nil
+		}
+		return err
+	}
+	old := unit
+	set := bson.M{}
+	switch {
+	case len(rated) > 0:
+		unit.Stars = *rated[0].Stars
+		if finished == nil && unit.Stars > 0 {
+			finished = &rated[0].Date
+		}
+	case removedRated:
+		unit.Stars = 0
+	}
+	if unit.Stars != old.Stars {
+		set["stars"] = unit.Stars
+	}
+	if finished != nil && unit.Status == general.STATUS_WANT {
+		unit.Status, unit.Finished = general.STATUS_FINISHED, finished
+		set["status"], set["finished"] = unit.Status, *finished
+	}
+	if len(set) == 0 {
+		return nil
+	}
+	unit.Edited = time.Now()
+	set["edited"] = unit.Edited
+	if err := units.Update(bson.M{"_id": id, "uid": uid}, bson.M{"$set": set}); err != nil {
+		if err.Error() == "not found" {
+			return nil
+		}
+		return err
+	}
+	return RecordChange(history, uid, &old, &unit, source)
+}
+
+// ParseDiaryQuery reads the period, the unit and the page from request parameters, the latest entries go first by default
+func ParseDiaryQuery(form url.Values) (*DiaryQuery, error) {
+	q := &DiaryQuery{Desc: true, Limit: DIARY_PAGE_LIMIT}
+	switch form.Get("order") {
+	case "", ORDER_DESC:
+	case ORDER_ASC:
+		q.Desc = false
+	default:
+		return nil, ErrorInvalidQuery{"order must be " + ORDER_ASC + " or " + ORDER_DESC}
+	}
+	for _, p := range []struct {
+		name string
+		t *time.Time
+	}{{"from", &q.From}, {"to", &q.To}} {
+		if v := form.Get(p.name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return nil, ErrorInvalidQuery{"Invalid " + p.name + ", RFC 3339 time is expected"}
+			}
+			*p.t = t
+		}
+	}
+	if v := form.Get("unit_id"); v != "" {
+		if !bson.IsObjectIdHex(v) {
+			return nil, ErrorInvalidQuery{"Invalid unit_id"}
+		}
+		q.Unit = bson.ObjectIdHex(v)
+	}
+	for _, p := range []struct {
+		name string
+		v *int
+	}{{"skip", &q.Skip}, {"limit", &q.Limit}} {
+		if v := form.Get(p.name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				return nil, ErrorInvalidQuery{p.name + " must be a non-negative integer"}
+			}
+			*p.v = n
+		}
+	}
+	if q.Limit == 0 {
+		q.Limit = DIARY_PAGE_LIMIT
+	}
+	if q.Limit > DIARY_MAX_LIMIT {
+		q.Limit = DIARY_MAX_LIMIT
+	}
+	return q, nil
+}
+
+// Diary returns entries of the user in chronological order with brief info about their units
+func Diary(diary IDiaryDataSource, units IUnitDataSource, uid bson.ObjectId, q *DiaryQuery) ([]DiaryItem, error) {
+	selector := bson.M{"uid": uid}
+	if q.Unit != "" {
+		selector["unit"] = q.Unit
+	}
+	date := bson.M{}
+	if !q.From.IsZero() {
+		date["$gte"] = q.From
+	}
+	if !q.To.IsZero() {
+		date["$lt"] = q.To
+	}
+	if len(date) > 0 {
+		selector["date"] = date
+	}
+	sort := []string{"date", "_id"}
+	if q.Desc {
+		sort = []string{"-date", "-_id"}
+	}
+	var entries []DiaryEntry
+	if err := diary.FindRange(selector, sort, q.Skip, q.Limit, &entries); err != nil {
+		return nil, err
+	}
+
+	ids := make([]bson.ObjectId, 0, len(entries))
+	for _, e := range entries {
+		ids = append(ids, e.Unit)
+	}
+	var found []general.ContentUnit
+	if err := units.FindAll(bson.M{"_id": bson.M{"$in": ids}, "uid": uid}, &found); err != nil {
+		return nil, err
+	}
+	byId := make(map[bson.ObjectId]*general.ContentUnit, len(found))
+	for i := range found {
+		byId[found[i].Id] = &found[i]
+	}
+	items := make([]DiaryItem, 0, len(entries))
+	for _, e := range entries {
+		item := DiaryItem{DiaryEntry: e}
+		if u, ok := byId[e.Unit]; ok {
+			item.Type, item.Title, item.Year, item.PicUrl = u.Type, u.Title, u.Year, u.PicUrl
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
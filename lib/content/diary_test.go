@@ -0,0 +1,207 @@
+package content
+
+import (
+	"testing"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+	"github.com/dzendmitry/rating-service/lib/general"
+)
+
+type diaryFixture struct {
+	t *testing.T
+	diary, units, history *memSource
+	uid bson.ObjectId
+	unit general.ContentUnit
+}
+
+func newDiaryFixture(t *testing.T, unit general.ContentUnit) *diaryFixture {
+	f := &diaryFixture{t: t, diary: newMemSource(), units: newMemSource(), history: newMemSource(), uid: bson.NewObjectId()}
+	unit.Id, unit.Uid = bson.NewObjectId(), f.uid
+	if err := f.units.Insert(unit); err != nil {
+		t.Fatal(err)
+	}
+	f.unit = unit
+	return f
+}
+
+func stars(n int) *int {
+	return &n
+}
+
+func daysAgo(n int) *time.Time {
+	t := time.Now().Add(-time.Duration(n) * 24 * time.Hour).Truncate(time.Millisecond)
+	return &t
+}
+
+func (f *diaryFixture) add(date *time.Time, s *int) bson.ObjectId {
+	entry, err := AddDiaryEntry(f.diary, f.units, f.history, f.uid, &DiaryEntryData{Unit: f.unit.Id, Date: date, Stars: s}, SOURCE_WEB)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	return entry.Id
+}
+
+func (f *diaryFixture) stored() general.ContentUnit {
+	var unit general.ContentUnit
+	if err := f.units.FindOne(bson.M{"_id": f.unit.Id}, &unit); err != nil {
+		f.t.Fatal(err)
+	}
+	return unit
+}
+
+func TestDiaryRating(t *testing.T) {
+	for _, c := range []struct {
+		name string
+		stars int
+		// change returns the expected rating
+		change func(f *diaryFixture) int
+	}{
+		{
+			name: "latest entry",
+			change: func(f *diaryFixture) int {
+				f.add(daysAgo(2), stars(3))
+				f.add(daysAgo(1), stars(4))
+				return 4
+			},
+		},
+		{
+			name: "older entry added",
+			change: func(f *diaryFixture) int {
+				f.add(daysAgo(1), stars(4))
+				f.add(daysAgo(2), stars(3))
+				return 4
+			},
+		},
+		{
+			name: "newer unrated entry",
+			change: func(f *diaryFixture) int {
+				f.add(daysAgo(2), stars(3))
+				f.add(daysAgo(1), nil)
+				return 3
+			},
+		},
+		{
+			name: "rated entry edited before an unrated one",
+			change: func(f *diaryFixture) int {
+				a := f.add(daysAgo(2), stars(3))
+				f.add(daysAgo(1), nil)
+				if err := EditDiaryEntry(f.diary, f.units, f.history, f.uid, &DiaryEntryData{Id: a, Stars: stars(5)}, SOURCE_WEB); err != nil {
+					f.t.Fatal(err)
+				}
+				return 5
+			},
+		},
+		{
+			name: "newer rated entry removed before an unrated one",
+			change: func(f *diaryFixture) int {
+				f.add(daysAgo(3), stars(3))
+				c := f.add(daysAgo(2), stars(4))
+				f.add(daysAgo(1), nil)
+				if err := RemoveDiaryEntry(f.diary, f.units, f.history, f.uid, c, SOURCE_WEB); err != nil {
+					f.t.Fatal(err)
+				}
+				return 3
+			},
+		},
+		{
+			name: "last rated entry removed",
+			change: func(f *diaryFixture) int {
+				a := f.add(daysAgo(2), stars(3))
+				f.add(daysAgo(1), nil)
+				if err := RemoveDiaryEntry(f.diary, f.units, f.history, f.uid, a, SOURCE_WEB); err != nil {
+					f.t.Fatal(err)
+				}
+				return 0
+			},
+		},
+		{
+			name: "unrated entry of the unit rated by edit",
+			stars: 4,
+			change: func(f *diaryFixture) int {
+				f.add(daysAgo(1), nil)
+				return 4
+			},
+		},
+		{
+			name: "unrated entry removed from the unit rated by edit",
+			stars: 4,
+			change: func(f *diaryFixture) int {
+				b := f.add(daysAgo(1), nil)
+				if err := RemoveDiaryEntry(f.diary, f.units, f.history, f.uid, b, SOURCE_WEB); err != nil {
+					f.t.Fatal(err)
+				}
+				return 4
+			},
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			f := newDiaryFixture(t, general.ContentUnit{Stars: c.stars, Status: general.STATUS_FINISHED, Finished: daysAgo(10)})
+			expected := c.change(f)
+			if unit := f.stored(); unit.Stars != expected {
+				t.Fatalf("Expected %d stars, got %d", expected, unit.Stars)
+			}
+		})
+	}
+}
+
+func TestDiaryFinishesWantedUnit(t *testing.T) {
+	for _, c := range []struct {
+		name string
+		change func(f *diaryFixture) *time.Time
+	}{
+		{
+			name: "rated entry added",
+			change: func(f *diaryFixture) *time.Time {
+				date := daysAgo(1)
+				f.add(date, stars(4))
+				return date
+			},
+		},
+		{
+			name: "unrated entry added",
+			change: func(f *diaryFixture) *time.Time {
+				date := daysAgo(1)
+				f.add(date, nil)
+				return date
+			},
+		},
+		{
+			name: "entry rated by edit",
+			change: func(f *diaryFixture) *time.Time {
+				date := daysAgo(1)
+				id := f.add(date, nil)
+				// The unit is wanted again, so only the rating finishes it
+				f.units.Update(bson.M{"_id": f.unit.Id}, bson.M{"$set": bson.M{"status": general.STATUS_WANT},
+					"$unset": bson.M{"finished": ""}})
+				if err := EditDiaryEntry(f.diary, f.units, f.history, f.uid, &DiaryEntryData{Id: id, Stars: stars(4)}, SOURCE_WEB); err != nil {
+					f.t.Fatal(err)
+				}
+				return date
+			},
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			f := newDiaryFixture(t, general.ContentUnit{Status: general.STATUS_WANT})
+			date := c.change(f)
+			unit := f.stored()
+			if unit.Status != general.STATUS_FINISHED || unit.Finished == nil || !unit.Finished.Equal(*date) {
+				t.Fatalf("The unit isn't finished at %s: %s %v", date, unit.Status, unit.Finished)
+			}
+		})
+	}
+}
+
+func TestDiaryRatingHistory(t *testing.T) {
+	f := newDiaryFixture(t, general.ContentUnit{Status: general.STATUS_FINISHED, Finished: daysAgo(10)})
+	f.add(daysAgo(2), stars(3))
+	f.add(daysAgo(1), nil)
+	var entries []HistoryEntry
+	if err := f.history.FindAll(bson.M{"unit": f.unit.Id}, &entries); err != nil {
+		t.Fatal(err)
+	}
+	// The unrated entry doesn't change the unit
+	if len(entries) != 1 || entries[0].Old.Stars != 0 || entries[0].New.Stars != 3 || entries[0].Source != SOURCE_WEB {
+		t.Fatalf("Unexpected history %+v", entries)
+	}
+}
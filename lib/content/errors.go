@@ -0,0 +1,67 @@
+package content
+
+import "fmt"
+
+type ErrorInvalidQuery struct {
+	Message string
+}
+func (e ErrorInvalidQuery) Error() string {
+	return e.Message
+}
+
+type ErrorInvalidCursor struct {}
+func (e ErrorInvalidCursor) Error() string {
+	return "Invalid cursor"
+}
+
+type ErrorTagNotFound struct {}
+func (e ErrorTagNotFound) Error() string {
+	return "Tag not found"
+}
+
+type ErrorListNotFound struct {}
+func (e ErrorListNotFound) Error() string {
+	return "List not found"
+}
+
+type ErrorTooManyLists struct {}
+func (e ErrorTooManyLists) Error() string {
+	return fmt.Sprintf("Too many lists, a user may have up to %d lists", LISTS_PER_USER)
+}
+
+type ErrorListFull struct {}
+func (e ErrorListFull) Error() string {
+	return fmt.Sprintf("List is full, a list may have up to %d entries", ENTRIES_PER_LIST)
+}
+
+type ErrorAlreadyInList struct {}
+func (e ErrorAlreadyInList) Error() string {
+	return "Unit is already in the list"
+}
+
+type ErrorListChanged struct {}
+func (e ErrorListChanged) Error() string {
+	return "List was changed, reload it and try again"
+}
+
+type ErrorUnitNotFound struct {}
+func (e ErrorUnitNotFound) Error() string {
+	return "Unit not found"
+}
+
+type ErrorInvalidUnit struct {
+	Message string
+}
+func (e ErrorInvalidUnit) Error() string {
+	return e.Message
+}
+
+type ErrorDiaryEntryNotFound struct {}
+func (e ErrorDiaryEntryNotFound) Error() string {
+	return "Diary entry not found"
+}
+
+type ErrorHistoryEntryNotFound struct {}
+func (e ErrorHistoryEntryNotFound) Error() string {
+	return "History entry not found"
+}
@@ -0,0 +1,190 @@
+package content
+
+import (
+	"gopkg.in/mgo.v2/bson"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/dzendmitry/rating-service/lib/general"
+)
+
+const (
+	SOURCE_WEB = "web"
+	SOURCE_API_KEY = "api_key"
+
+	HISTORY_PAGE_LIMIT = 50
+	HISTORY_MAX_LIMIT = 200
+)
+
+type IHistoryDataSource interface {
+	Insert(query interface{}) error
+	FindOne(query interface{}, result interface{}) error
+	FindRange(query interface{}, sort []string, skip, limit int, result interface{}) error
+	RemoveAll(selector interface{}) error
+}
+
+func versionOf(u *general.ContentUnit) *UnitVersion {
+	return &UnitVersion{
+		Stars: u.Stars,
+		Comment: u.Comment,
+		Status: u.Status,
+		Started: u.Started,
+		Finished: u.Finished,
+	}
+}
+
+func sameTime(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(*b)
+}
+
+func (v *UnitVersion) equal(o *UnitVersion) bool {
+	return v.Stars == o.Stars && v.Comment == o.Comment && v.Status == o.Status &&
+		sameTime(v.Started, o.Started) && sameTime(v.Finished, o.Finished)
+}
+
+// RecordChange saves the change of the unit to the history, old is nil when the unit is added.
+// Changes of fields which aren't versioned, e.g. tags, aren't recorded.
+func RecordChange(history IHistoryDataSource, uid bson.ObjectId, old, new *general.ContentUnit, source string) error {
+	entry := newChange(uid, old, new, source)
+	if entry == nil {
+		return nil
+	}
+	return history.Insert(entry)
+}
+
+// newChange returns nil when versioned fields aren't changed
+func newChange(uid bson.ObjectId, old, new *general.ContentUnit, source string) *HistoryEntry {
+	entry := &HistoryEntry{
+		Id: bson.NewObjectId(),
+		Uid: uid,
+		Unit: new.Id,
+		New: *versionOf(new),
+		Source: source,
+		Created: time.Now(),
+	}
+	if old != nil {
+		entry.Old = versionOf(old)
+		if entry.Old.equal(&entry.New) {
+			return nil
+		}
+	}
+	return entry
+}
+
+// ParseHistoryQuery reads the unit and the page from request parameters
+func ParseHistoryQuery(form url.Values) (*HistoryQuery, error) {
+	q := &HistoryQuery{Limit: HISTORY_PAGE_LIMIT}
+	v := form.Get("unit_id")
+	if !bson.IsObjectIdHex(v) {
+		return nil, ErrorInvalidQuery{"Invalid unit_id"}
+	}
+	q.Unit = bson.ObjectIdHex(v)
+	for _, p := range []struct {
+		name string
+		v *int
+	}{{"skip", &q.Skip}, {"limit", &q.Limit}} {
+		if v := form.Get(p.name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				return nil, ErrorInvalidQuery{p.name + " must be a non-negative integer"}
+			}
+			*p.v = n
+		}
+	}
+	if q.Limit == 0 {
+		q.Limit = HISTORY_PAGE_LIMIT
+	}
+	if q.Limit > HISTORY_MAX_LIMIT {
+		q.Limit = HISTORY_MAX_LIMIT
+	}
+	return q, nil
+}
+
+// History returns changes of the unit, the latest go first
+func History(history IHistoryDataSource, uid bson.ObjectId, q *HistoryQuery) ([]HistoryEntry, error) {
+	entries := make([]HistoryEntry, 0)
+	if err := history.FindRange(bson.M{"uid": uid, "unit": q.Unit}, []string{"-created", "-_id"},
+		q.Skip, q.Limit, &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// RestoreVersion sets the unit to the version saved by the history entry. The restore is recorded as a new change
+// of the source with the restored entry before the unit is updated, so the unit never changes without a version.
+func RestoreVersion(history IHistoryDataSource, units IUnitDataSource, uid bson.ObjectId, id bson.ObjectId,
+	source string) (*general.ContentUnit, error) {
+	var entry HistoryEntry
+	if err := history.FindOne(bson.M{"_id": id, "uid": uid}, &entry); err != nil {
+		if err.Error() == "not found" {
+			return nil, ErrorHistoryEntryNotFound{}
+		}
+		return nil, err
+	}
+	var unit general.ContentUnit
+	if err := units.FindOne(bson.M{"_id": entry.Unit, "uid": uid}, &unit); err != nil {
+		if err.Error() == "not found" {
+			return nil, ErrorUnitNotFound{}
+		}
+		return nil, err
+	}
+	old := unit
+	v := entry.New
+	unit.Stars, unit.Comment = v.Stars, v.Comment
+	// The version is checked like an edit, so an inconsistent one isn't restored
+	version := general.ContentUnit{Status: v.Status, Started: v.Started, Finished: v.Finished}
+	if err := SetStatus(&unit, &version, &version); err != nil {
+		return nil, err
+	}
+	unit.Edited = time.Now()
+
+	set := bson.M{"stars": unit.Stars, "comment": unit.Comment, "edited": unit.Edited}
+	unset := bson.M{}
+	for _, f := range []struct {
+		name string
+		empty bool
+		value interface{}
+	}{{"status", unit.Status == "", unit.Status}, {"started", unit.Started == nil, unit.Started},
+		{"finished", unit.Finished == nil, unit.Finished}} {
+		if f.empty {
+			unset[f.name] = ""
+		} else {
+			set[f.name] = f.value
+		}
+	}
+	update := bson.M{"$set": set}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+
+	change := newChange(uid, &old, &unit, source)
+	if change != nil {
+		change.Restored = entry.Id
+		if err := history.Insert(change); err != nil {
+			return nil, err
+		}
+	}
+	if err := units.Update(bson.M{"_id": unit.Id, "uid": uid}, update); err != nil {
+		// The change didn't happen
+		if change != nil {
+			history.RemoveAll(bson.M{"_id": change.Id, "uid": uid})
+		}
+		if err.Error() == "not found" {
+			return nil, ErrorUnitNotFound{}
+		}
+		return nil, err
+	}
+	return &unit, nil
+}
+
+// RemoveUnitHistory removes changes of the removed unit
+func RemoveUnitHistory(history IHistoryDataSource, uid bson.ObjectId, unit bson.ObjectId) error {
+	if err := history.RemoveAll(bson.M{"uid": uid, "unit": unit}); err != nil && err.Error() != "not found" {
+		return err
+	}
+	return nil
+}
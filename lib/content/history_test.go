@@ -0,0 +1,176 @@
+package content
+
+import (
+	"errors"
+	"testing"
+
+	"gopkg.in/mgo.v2/bson"
+	"github.com/dzendmitry/rating-service/lib/general"
+)
+
+// failingSource fails inserts and updates while fail is set
+type failingSource struct {
+	*memSource
+	fail bool
+}
+
+func (s *failingSource) Insert(query interface{}) error {
+	if s.fail {
+		return errors.New("connection lost")
+	}
+	return s.memSource.Insert(query)
+}
+
+func (s *failingSource) Update(selector, update interface{}) error {
+	if s.fail {
+		return errors.New("connection lost")
+	}
+	return s.memSource.Update(selector, update)
+}
+
+type historyFixture struct {
+	history, units *failingSource
+	uid bson.ObjectId
+	unit general.ContentUnit
+	// Version of the unit before the edit
+	first HistoryEntry
+}
+
+// newHistoryFixture adds the rated unit and edits its comment and stars
+func newHistoryFixture(t *testing.T) *historyFixture {
+	f := &historyFixture{history: &failingSource{memSource: newMemSource()}, units: &failingSource{memSource: newMemSource()},
+		uid: bson.NewObjectId()}
+	added := general.ContentUnit{Id: bson.NewObjectId(), Uid: f.uid, Stars: 3, Comment: "good",
+		Status: general.STATUS_FINISHED, Finished: daysAgo(2)}
+	if err := f.units.Insert(added); err != nil {
+		t.Fatal(err)
+	}
+	if err := RecordChange(f.history, f.uid, nil, &added, SOURCE_WEB); err != nil {
+		t.Fatal(err)
+	}
+	edited := added
+	edited.Stars, edited.Comment = 5, "great"
+	if err := f.units.Update(bson.M{"_id": added.Id}, bson.M{"$set": bson.M{"stars": 5, "comment": "great"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := RecordChange(f.history, f.uid, &added, &edited, SOURCE_WEB); err != nil {
+		t.Fatal(err)
+	}
+	// Tags aren't versioned
+	tagged := edited
+	tagged.Tags = []string{"rewatch"}
+	if err := RecordChange(f.history, f.uid, &edited, &tagged, SOURCE_WEB); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := f.history.Count(bson.M{}); n != 2 {
+		t.Fatalf("Expected 2 versions, got %d", n)
+	}
+	if err := f.history.FindOne(bson.M{"old": bson.M{"$exists": false}}, &f.first); err != nil {
+		t.Fatal(err)
+	}
+	f.unit = edited
+	return f
+}
+
+func (f *historyFixture) stored(t *testing.T) general.ContentUnit {
+	var unit general.ContentUnit
+	if err := f.units.FindOne(bson.M{"_id": f.unit.Id}, &unit); err != nil {
+		t.Fatal(err)
+	}
+	return unit
+}
+
+func TestRestoreVersion(t *testing.T) {
+	f := newHistoryFixture(t)
+	unit, err := RestoreVersion(f.history, f.units, f.uid, f.first.Id, SOURCE_API_KEY)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored := f.stored(t)
+	for _, u := range []general.ContentUnit{*unit, stored} {
+		if u.Stars != 3 || u.Comment != "good" || u.Status != general.STATUS_FINISHED || !u.Finished.Equal(*f.first.New.Finished) {
+			t.Fatalf("The version isn't restored: %+v", u)
+		}
+	}
+	var restore HistoryEntry
+	if err := f.history.FindOne(bson.M{"restored": f.first.Id}, &restore); err != nil {
+		t.Fatalf("The restore isn't recorded: %v", err)
+	}
+	if restore.Source != SOURCE_API_KEY || restore.Old == nil || restore.Old.Stars != 5 || restore.New.Stars != 3 {
+		t.Fatalf("Unexpected restore entry %+v", restore)
+	}
+
+	// The current version is restored again without a new entry
+	if _, err := RestoreVersion(f.history, f.units, f.uid, f.first.Id, SOURCE_WEB); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := f.history.Count(bson.M{}); n != 3 {
+		t.Fatalf("Expected 3 versions, got %d", n)
+	}
+}
+
+func TestRestoreVersionErrors(t *testing.T) {
+	for _, c := range []struct {
+		name string
+		// change returns id of the restored entry
+		change func(f *historyFixture) bson.ObjectId
+		err error
+	}{
+		{
+			name: "entry of another user",
+			change: func(f *historyFixture) bson.ObjectId {
+				f.history.UpdateAll(bson.M{}, bson.M{"$set": bson.M{"uid": bson.NewObjectId()}})
+				return f.first.Id
+			},
+			err: ErrorHistoryEntryNotFound{},
+		},
+		{
+			name: "removed unit",
+			change: func(f *historyFixture) bson.ObjectId {
+				f.units.RemoveAll(bson.M{})
+				return f.first.Id
+			},
+			err: ErrorUnitNotFound{},
+		},
+		{
+			name: "wanted version with stars",
+			change: func(f *historyFixture) bson.ObjectId {
+				f.history.UpdateAll(bson.M{"_id": f.first.Id}, bson.M{"$set": bson.M{"new": UnitVersion{Stars: 4, Status: general.STATUS_WANT}}})
+				return f.first.Id
+			},
+			err: ErrorInvalidUnit{"Units the user wants can't be rated"},
+		},
+		{
+			name: "history failure",
+			change: func(f *historyFixture) bson.ObjectId {
+				f.history.fail = true
+				return f.first.Id
+			},
+		},
+		{
+			name: "unit failure",
+			change: func(f *historyFixture) bson.ObjectId {
+				f.units.fail = true
+				return f.first.Id
+			},
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			f := newHistoryFixture(t)
+			_, err := RestoreVersion(f.history, f.units, f.uid, c.change(f), SOURCE_WEB)
+			if err == nil || (c.err != nil && err != c.err) {
+				t.Fatalf("Expected error %#v, got %#v", c.err, err)
+			}
+			f.units.fail = false
+			if n, _ := f.units.Count(bson.M{}); n > 0 {
+				if unit := f.stored(t); unit.Stars != 5 || unit.Comment != "great" {
+					t.Fatalf("The unit is changed: %+v", unit)
+				}
+			}
+			// Versions are kept only for changes which happened
+			if n, _ := f.history.Count(bson.M{"restored": bson.M{"$exists": true}}); n != 0 {
+				t.Fatalf("The failed restore is recorded")
+			}
+		})
+	}
+}
@@ -0,0 +1,288 @@
+package content
+
+import (
+	"gopkg.in/mgo.v2/bson"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dzendmitry/rating-service/lib/general"
+)
+
+const (
+	PRIVACY_PRIVATE = "private"
+	PRIVACY_PUBLIC = "public"
+
+	// Limits are reported by ErrorTooManyLists and ErrorListFull, the entries one is also returned as max_size
+	LISTS_PER_USER = 100
+	ENTRIES_PER_LIST = 1000
+)
+
+type IListDataSource interface {
+	Insert(query interface{}) error
+	FindOne(query interface{}, result interface{}) error
+	FindAll(query interface{}, result interface{}) error
+	Update(selector, update interface{}) error
+	UpdateAll(selector, update interface{}) error
+	Remove(selector interface{}) error
+	Count(query interface{}) (int, error)
+}
+
+// IOwnerStatus tells whether owners of public lists are active, lists are hidden while their owners
+// are disabled or scheduled for deletion. Statuses are kept by auth-service.
+type IOwnerStatus interface {
+	UserActive(uid bson.ObjectId) (bool, error)
+}
+
+type IUnitDataSource interface {
+	FindOne(query interface{}, result interface{}) error
+	FindAll(query interface{}, result interface{}) error
+	Update(selector, update interface{}) error
+}
+
+func checkPrivacy(privacy string) (string, error) {
+	switch privacy {
+	case "":
+		return PRIVACY_PRIVATE, nil
+	case PRIVACY_PRIVATE, PRIVACY_PUBLIC:
+		return privacy, nil
+	}
+	return "", ErrorInvalidQuery{"privacy must be " + PRIVACY_PRIVATE + " or " + PRIVACY_PUBLIC}
+}
+
+// CreateList creates an empty list of the user
+func CreateList(lists IListDataSource, uid bson.ObjectId, data *ListData) (*ListInfo, error) {
+	if data.Name == nil || strings.TrimSpace(*data.Name) == "" {
+		return nil, ErrorInvalidQuery{"name is required"}
+	}
+	privacy := ""
+	if data.Privacy != nil {
+		privacy = *data.Privacy
+	}
+	privacy, err := checkPrivacy(privacy)
+	if err != nil {
+		return nil, err
+	}
+	n, err := lists.Count(bson.M{"uid": uid})
+	if err != nil {
+		return nil, err
+	}
+	if n >= LISTS_PER_USER {
+		return nil, ErrorTooManyLists{}
+	}
+	now := time.Now()
+	list := &List{
+		Id: bson.NewObjectId(),
+		Uid: uid,
+		Name: strings.TrimSpace(*data.Name),
+		Privacy: privacy,
+		Entries: make([]ListEntry, 0),
+		Created: now,
+		Edited: now,
+	}
+	if data.Description != nil {
+		list.Description = *data.Description
+	}
+	if err := lists.Insert(list); err != nil {
+		return nil, err
+	}
+	info := list.info()
+	return &info, nil
+}
+
+// Lists returns lists of the user without entries
+func Lists(lists IListDataSource, uid bson.ObjectId) ([]ListInfo, error) {
+	var all []List
+	if err := lists.FindAll(bson.M{"uid": uid}, &all); err != nil {
+		return nil, err
+	}
+	infos := make([]ListInfo, 0, len(all))
+	for i := range all {
+		infos = append(infos, all[i].info())
+	}
+	return infos, nil
+}
+
+func (l *List) info() ListInfo {
+	return ListInfo{
+		Id: l.Id,
+		Name: l.Name,
+		Description: l.Description,
+		Privacy: l.Privacy,
+		Size: len(l.Entries),
+		MaxSize: ENTRIES_PER_LIST,
+		Created: l.Created,
+		Edited: l.Edited,
+	}
+}
+
+// UpdateList changes only fields present in the data
+func UpdateList(lists IListDataSource, uid bson.ObjectId, data *ListData) error {
+	set := bson.M{"edited": time.Now()}
+	if data.Name != nil {
+		name := strings.TrimSpace(*data.Name)
+		if name == "" {
+			return ErrorInvalidQuery{"name must not be empty"}
+		}
+		set["name"] = name
+	}
+	if data.Description != nil {
+		set["description"] = *data.Description
+	}
+	if data.Privacy != nil {
+		privacy, err := checkPrivacy(*data.Privacy)
+		if err != nil {
+			return err
+		}
+		set["privacy"] = privacy
+	}
+	return listNotFound(lists.Update(bson.M{"_id": data.Id, "uid": uid}, bson.M{"$set": set}))
+}
+
+func RemoveList(lists IListDataSource, uid bson.ObjectId, id bson.ObjectId) error {
+	return listNotFound(lists.Remove(bson.M{"_id": id, "uid": uid}))
+}
+
+func listNotFound(err error) error {
+	if err != nil && err.Error() == "not found" {
+		return ErrorListNotFound{}
+	}
+	return err
+}
+
+// GetList returns the list with its units in the list order. Public lists are visible to everyone
+// while their owners are active, uid is empty for anonymous requests.
+func GetList(lists IListDataSource, units IUnitDataSource, owners IOwnerStatus, uid bson.ObjectId,
+	id bson.ObjectId) (*ListWithUnits, error) {
+	var list List
+	if err := lists.FindOne(bson.M{"_id": id}, &list); err != nil {
+		return nil, listNotFound(err)
+	}
+	if list.Uid != uid {
+		if list.Privacy != PRIVACY_PUBLIC {
+			return nil, ErrorListNotFound{}
+		}
+		active, err := owners.UserActive(list.Uid)
+		if err != nil {
+			return nil, err
+		}
+		if !active {
+			return nil, ErrorListNotFound{}
+		}
+	}
+	ids := make([]bson.ObjectId, 0, len(list.Entries))
+	for _, e := range list.Entries {
+		ids = append(ids, e.Unit)
+	}
+	var found []general.ContentUnit
+	if err := units.FindAll(bson.M{"_id": bson.M{"$in": ids}, "uid": list.Uid}, &found); err != nil {
+		return nil, err
+	}
+	byId := make(map[bson.ObjectId]general.ContentUnit, len(found))
+	for _, u := range found {
+		byId[u.Id] = u
+	}
+	result := &ListWithUnits{ListInfo: list.info(), Units: make([]ListUnit, 0, len(list.Entries))}
+	for _, e := range list.Entries {
+		// Entries of removed units are skipped until the unit removal cleans them up
+		if u, ok := byId[e.Unit]; ok {
+			result.Units = append(result.Units, ListUnit{ContentUnit: u, Added: e.Added})
+		}
+	}
+	result.Size = len(result.Units)
+	return result, nil
+}
+
+// AddListEntry adds the unit of the user to the list at the position, the end of the list by default
+func AddListEntry(lists IListDataSource, units IUnitDataSource, uid bson.ObjectId, data *ListEntryData) error {
+	var unit general.ContentUnit
+	if err := units.FindOne(bson.M{"_id": data.Unit, "uid": uid}, &unit); err != nil {
+		if err.Error() == "not found" {
+			return ErrorUnitNotFound{}
+		}
+		return err
+	}
+	push := bson.M{"$each": []ListEntry{{Unit: data.Unit, Added: time.Now()}}}
+	if data.Position != nil {
+		if *data.Position < 0 {
+			return ErrorInvalidQuery{"position must be non-negative"}
+		}
+		push["$position"] = *data.Position
+	}
+	// The selector makes the check of the size and duplicates atomic with the push
+	selector := bson.M{
+		"_id": data.Id,
+		"uid": uid,
+		"entries.unit": bson.M{"$ne": data.Unit},
+		"entries." + strconv.Itoa(ENTRIES_PER_LIST - 1): bson.M{"$exists": false},
+	}
+	err := lists.Update(selector, bson.M{"$push": bson.M{"entries": push}, "$set": bson.M{"edited": time.Now()}})
+	if err == nil || err.Error() != "not found" {
+		return err
+	}
+	var list List
+	if err := lists.FindOne(bson.M{"_id": data.Id, "uid": uid}, &list); err != nil {
+		return listNotFound(err)
+	}
+	for _, e := range list.Entries {
+		if e.Unit == data.Unit {
+			return ErrorAlreadyInList{}
+		}
+	}
+	return ErrorListFull{}
+}
+
+func RemoveListEntry(lists IListDataSource, uid bson.ObjectId, data *ListEntryData) error {
+	err := lists.Update(bson.M{"_id": data.Id, "uid": uid, "entries.unit": data.Unit},
+		bson.M{"$pull": bson.M{"entries": bson.M{"unit": data.Unit}}, "$set": bson.M{"edited": time.Now()}})
+	if err == nil || err.Error() != "not found" {
+		return err
+	}
+	var list List
+	if err := lists.FindOne(bson.M{"_id": data.Id, "uid": uid}, &list); err != nil {
+		return listNotFound(err)
+	}
+	return ErrorUnitNotFound{}
+}
+
+// ReorderList sets the order of entries, units must be exactly the entries of the list
+func ReorderList(lists IListDataSource, uid bson.ObjectId, data *ListOrderData) error {
+	var list List
+	if err := lists.FindOne(bson.M{"_id": data.Id, "uid": uid}, &list); err != nil {
+		return listNotFound(err)
+	}
+	if len(data.Units) != len(list.Entries) {
+		return ErrorInvalidQuery{"units must contain all entries of the list"}
+	}
+	added := make(map[bson.ObjectId]time.Time, len(list.Entries))
+	for _, e := range list.Entries {
+		added[e.Unit] = e.Added
+	}
+	entries := make([]ListEntry, 0, len(data.Units))
+	for _, id := range data.Units {
+		t, ok := added[id]
+		if !ok {
+			return ErrorInvalidQuery{"units must contain all entries of the list"}
+		}
+		delete(added, id)
+		entries = append(entries, ListEntry{Unit: id, Added: t})
+	}
+	// Edited guards against concurrent changes of entries since the list was read
+	if err := lists.Update(bson.M{"_id": data.Id, "uid": uid, "edited": list.Edited},
+		bson.M{"$set": bson.M{"entries": entries, "edited": time.Now()}}); err != nil {
+		if err.Error() == "not found" {
+			return ErrorListChanged{}
+		}
+		return err
+	}
+	return nil
+}
+
+// RemoveUnitFromLists removes entries of the removed unit from all lists of the user
+func RemoveUnitFromLists(lists IListDataSource, uid bson.ObjectId, unit bson.ObjectId) error {
+	err := lists.UpdateAll(bson.M{"uid": uid, "entries.unit": unit}, bson.M{"$pull": bson.M{"entries": bson.M{"unit": unit}}})
+	if err != nil && err.Error() != "not found" {
+		return err
+	}
+	return nil
+}
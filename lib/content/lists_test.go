@@ -0,0 +1,270 @@
+package content
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+	"github.com/dzendmitry/rating-service/lib/general"
+)
+
+type listsFixture struct {
+	t *testing.T
+	lists, units *memSource
+	uid bson.ObjectId
+}
+
+func newListsFixture(t *testing.T) *listsFixture {
+	return &listsFixture{t: t, lists: newMemSource(), units: newMemSource(), uid: bson.NewObjectId()}
+}
+
+func (f *listsFixture) unit(title string) bson.ObjectId {
+	id := bson.NewObjectId()
+	if err := f.units.Insert(general.ContentUnit{Id: id, Uid: f.uid, Title: title}); err != nil {
+		f.t.Fatal(err)
+	}
+	return id
+}
+
+func (f *listsFixture) create(privacy string) bson.ObjectId {
+	name := "favourites"
+	list, err := CreateList(f.lists, f.uid, &ListData{Name: &name, Privacy: &privacy})
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	return list.Id
+}
+
+func (f *listsFixture) add(list, unit bson.ObjectId, position *int) error {
+	return AddListEntry(f.lists, f.units, f.uid, &ListEntryData{Id: list, Unit: unit, Position: position})
+}
+
+func (f *listsFixture) entries(id bson.ObjectId) []bson.ObjectId {
+	var list List
+	if err := f.lists.FindOne(bson.M{"_id": id}, &list); err != nil {
+		f.t.Fatal(err)
+	}
+	ids := make([]bson.ObjectId, 0, len(list.Entries))
+	for _, e := range list.Entries {
+		ids = append(ids, e.Unit)
+	}
+	return ids
+}
+
+func equalIds(a, b []bson.ObjectId) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestCreateListLimit(t *testing.T) {
+	f := newListsFixture(t)
+	for i := 0; i < LISTS_PER_USER; i++ {
+		f.create(PRIVACY_PRIVATE)
+	}
+	name := "one more"
+	if _, err := CreateList(f.lists, f.uid, &ListData{Name: &name}); err != (ErrorTooManyLists{}) {
+		t.Fatalf("Expected ErrorTooManyLists, got %#v", err)
+	}
+	if _, err := CreateList(f.lists, bson.NewObjectId(), &ListData{Name: &name}); err != nil {
+		t.Fatalf("Lists of another user are counted: %v", err)
+	}
+}
+
+func TestAddListEntry(t *testing.T) {
+	f := newListsFixture(t)
+	list := f.create(PRIVACY_PRIVATE)
+	a, b, c := f.unit("a"), f.unit("b"), f.unit("c")
+	first, far := 0, 10
+	for _, e := range []struct {
+		unit bson.ObjectId
+		position *int
+	}{{a, nil}, {b, &far}, {c, &first}} {
+		if err := f.add(list, e.unit, e.position); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if entries := f.entries(list); !equalIds(entries, []bson.ObjectId{c, a, b}) {
+		t.Fatalf("Unexpected order %v", entries)
+	}
+
+	foreign := bson.NewObjectId()
+	if err := f.units.Insert(general.ContentUnit{Id: foreign, Uid: bson.NewObjectId()}); err != nil {
+		t.Fatal(err)
+	}
+	negative := -1
+	for _, e := range []struct {
+		name string
+		list, unit bson.ObjectId
+		position *int
+		err error
+	}{
+		{"duplicate", list, a, nil, ErrorAlreadyInList{}},
+		{"unit of another user", list, foreign, nil, ErrorUnitNotFound{}},
+		{"unknown list", bson.NewObjectId(), a, nil, ErrorListNotFound{}},
+		{"negative position", list, f.unit("d"), &negative, ErrorInvalidQuery{"position must be non-negative"}},
+	} {
+		t.Run(e.name, func(t *testing.T) {
+			if err := f.add(e.list, e.unit, e.position); err != e.err {
+				t.Fatalf("Expected %#v, got %#v", e.err, err)
+			}
+		})
+	}
+}
+
+func TestAddListEntryFull(t *testing.T) {
+	f := newListsFixture(t)
+	full := List{Id: bson.NewObjectId(), Uid: f.uid, Name: "full", Privacy: PRIVACY_PRIVATE}
+	for i := 0; i < ENTRIES_PER_LIST; i++ {
+		full.Entries = append(full.Entries, ListEntry{Unit: bson.NewObjectId(), Added: time.Now()})
+	}
+	if err := f.lists.Insert(full); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.add(full.Id, f.unit("a"), nil); err != (ErrorListFull{}) {
+		t.Fatalf("Expected ErrorListFull, got %#v", err)
+	}
+	// A duplicate is reported even when the list is full
+	if err := f.units.Insert(general.ContentUnit{Id: full.Entries[0].Unit, Uid: f.uid}); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.add(full.Id, full.Entries[0].Unit, nil); err != (ErrorAlreadyInList{}) {
+		t.Fatalf("Expected ErrorAlreadyInList, got %#v", err)
+	}
+}
+
+// racingSource changes the data after the first read, as a concurrent request would
+type racingSource struct {
+	*memSource
+	race func()
+}
+
+func (s *racingSource) FindOne(query interface{}, result interface{}) error {
+	err := s.memSource.FindOne(query, result)
+	if s.race != nil {
+		race := s.race
+		s.race = nil
+		race()
+	}
+	return err
+}
+
+func TestReorderList(t *testing.T) {
+	f := newListsFixture(t)
+	list := f.create(PRIVACY_PRIVATE)
+	a, b, c := f.unit("a"), f.unit("b"), f.unit("c")
+	for _, u := range []bson.ObjectId{a, b, c} {
+		if err := f.add(list, u, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ReorderList(f.lists, f.uid, &ListOrderData{Id: list, Units: []bson.ObjectId{c, a, b}}); err != nil {
+		t.Fatal(err)
+	}
+	if entries := f.entries(list); !equalIds(entries, []bson.ObjectId{c, a, b}) {
+		t.Fatalf("Unexpected order %v", entries)
+	}
+
+	invalid := ErrorInvalidQuery{"units must contain all entries of the list"}
+	for _, e := range []struct {
+		name string
+		units []bson.ObjectId
+	}{
+		{"missing entry", []bson.ObjectId{a, b}},
+		{"duplicate entry", []bson.ObjectId{a, b, b}},
+		{"unknown unit", []bson.ObjectId{a, b, bson.NewObjectId()}},
+	} {
+		t.Run(e.name, func(t *testing.T) {
+			if err := ReorderList(f.lists, f.uid, &ListOrderData{Id: list, Units: e.units}); err != invalid {
+				t.Fatalf("Expected %#v, got %#v", invalid, err)
+			}
+		})
+	}
+	if err := ReorderList(f.lists, bson.NewObjectId(), &ListOrderData{Id: list, Units: []bson.ObjectId{a, b, c}}); err != (ErrorListNotFound{}) {
+		t.Fatalf("Expected ErrorListNotFound, got %#v", err)
+	}
+
+	// The entry added after the list was read isn't lost
+	d := f.unit("d")
+	racing := &racingSource{memSource: f.lists, race: func() {
+		// Edited times are stored in milliseconds
+		time.Sleep(2 * time.Millisecond)
+		if err := f.add(list, d, nil); err != nil {
+			t.Fatal(err)
+		}
+	}}
+	if err := ReorderList(racing, f.uid, &ListOrderData{Id: list, Units: []bson.ObjectId{a, b, c}}); err != (ErrorListChanged{}) {
+		t.Fatalf("Expected ErrorListChanged, got %#v", err)
+	}
+	if entries := f.entries(list); !equalIds(entries, []bson.ObjectId{c, a, b, d}) {
+		t.Fatalf("Unexpected entries %v", entries)
+	}
+}
+
+// owners are active when they're true in the map
+type owners map[bson.ObjectId]bool
+
+func (o owners) UserActive(uid bson.ObjectId) (bool, error) {
+	active, ok := o[uid]
+	if !ok {
+		return false, errors.New("auth-service is unavailable")
+	}
+	return active, nil
+}
+
+func TestGetList(t *testing.T) {
+	f := newListsFixture(t)
+	private, public := f.create(PRIVACY_PRIVATE), f.create(PRIVACY_PUBLIC)
+	a, b, removed := f.unit("a"), f.unit("b"), f.unit("removed")
+	for _, u := range []bson.ObjectId{b, removed, a} {
+		for _, list := range []bson.ObjectId{private, public} {
+			if err := f.add(list, u, nil); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := f.units.Remove(bson.M{"_id": removed}); err != nil {
+		t.Fatal(err)
+	}
+
+	other := bson.NewObjectId()
+	for _, c := range []struct {
+		name string
+		list, uid bson.ObjectId
+		owners owners
+		err error
+	}{
+		{"own private", private, f.uid, owners{}, nil},
+		{"private of another user", private, other, owners{f.uid: true}, ErrorListNotFound{}},
+		{"private for anonymous", private, "", owners{f.uid: true}, ErrorListNotFound{}},
+		{"public of active owner", public, other, owners{f.uid: true}, nil},
+		{"public for anonymous", public, "", owners{f.uid: true}, nil},
+		{"public of inactive owner", public, "", owners{f.uid: false}, ErrorListNotFound{}},
+		{"own public of inactive owner", public, f.uid, owners{f.uid: false}, nil},
+		{"unknown", bson.NewObjectId(), f.uid, owners{}, ErrorListNotFound{}},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			list, err := GetList(f.lists, f.units, c.owners, c.uid, c.list)
+			if err != c.err {
+				t.Fatalf("Expected %#v, got %#v", c.err, err)
+			}
+			if err != nil {
+				return
+			}
+			// The removed unit is skipped
+			if list.Size != 2 || len(list.Units) != 2 || list.Units[0].Id != b || list.Units[1].Id != a {
+				t.Fatalf("Unexpected list %+v", list)
+			}
+		})
+	}
+	if _, err := GetList(f.lists, f.units, owners{}, "", public); err == nil {
+		t.Fatal("The list is shown without the owner status")
+	}
+}
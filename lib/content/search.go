@@ -0,0 +1,226 @@
+package content
+
+import (
+	"bytes"
+	"gopkg.in/mgo.v2/bson"
+	"html"
+	"net/url"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/dzendmitry/rating-service/lib/general"
+)
+
+const (
+	SEARCH_LIMIT = 20
+	SEARCH_MAX_LIMIT = 100
+	SEARCH_MAX_QUERY = 200
+
+	// Runes of context around matches in highlighted fragments
+	FRAGMENT_CONTEXT = 60
+	FRAGMENTS_PER_FIELD = 3
+	HIGHLIGHT_PRE = "<em>"
+	HIGHLIGHT_POST = "</em>"
+)
+
+// Fields matched by the search, the text index weights them in the same order
+var searchFields = []string{"title", "author", "comment", "desc"}
+
+// ISearcher finds units of the user by text, more relevant ones go first
+type ISearcher interface {
+	Search(uid bson.ObjectId, q *SearchQuery) ([]SearchResult, error)
+}
+
+type IContentTextSource interface {
+	FindProjected(query, projection interface{}, sort []string, skip, limit int, result interface{}) error
+}
+
+type SearchQuery struct {
+	Text string
+	Type string
+	Skip int
+	Limit int
+}
+
+type SearchResult struct {
+	Unit general.ContentUnit       `json:"unit"`
+	Score float64                  `json:"score"`
+	// Escaped html fragments of matched fields with matches wrapped in <em>
+	Highlights map[string][]string `json:"highlights,omitempty"`
+}
+
+// ParseSearchQuery reads the search text, the unit type and the page from request parameters
+func ParseSearchQuery(form url.Values) (*SearchQuery, error) {
+	q := &SearchQuery{Text: strings.TrimSpace(form.Get("q")), Type: form.Get("type"), Limit: SEARCH_LIMIT}
+	if q.Text == "" {
+		return nil, ErrorInvalidQuery{"q is required"}
+	}
+	if utf8.RuneCountInString(q.Text) > SEARCH_MAX_QUERY {
+		return nil, ErrorInvalidQuery{"q is too long"}
+	}
+	if q.Type != "" && !general.ParserTypes[q.Type] {
+		return nil, ErrorInvalidQuery{"Unknown type: " + q.Type}
+	}
+	for _, p := range []struct {
+		name string
+		v *int
+	}{{"skip", &q.Skip}, {"limit", &q.Limit}} {
+		if v := form.Get(p.name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				return nil, ErrorInvalidQuery{p.name + " must be a non-negative integer"}
+			}
+			*p.v = n
+		}
+	}
+	if q.Limit == 0 {
+		q.Limit = SEARCH_LIMIT
+	}
+	if q.Limit > SEARCH_MAX_LIMIT {
+		q.Limit = SEARCH_MAX_LIMIT
+	}
+	return q, nil
+}
+
+// TextIndex searches with the mongo text index of units, see mongo-base
+type TextIndex struct {
+	units IContentTextSource
+}
+
+func NewTextIndex(units IContentTextSource) *TextIndex {
+	return &TextIndex{units: units}
+}
+
+type scoredUnit struct {
+	general.ContentUnit `bson:",inline"`
+	Score float64 `bson:"score"`
+}
+
+func (t *TextIndex) Search(uid bson.ObjectId, q *SearchQuery) ([]SearchResult, error) {
+	// The index is prefixed by uid, so the equality on it keeps the search within the user's library
+	selector := bson.M{"uid": uid, "$text": bson.M{"$search": q.Text}}
+	if q.Type != "" {
+		selector["type"] = q.Type
+	}
+	var found []scoredUnit
+	if err := t.units.FindProjected(selector, bson.M{"score": bson.M{"$meta": "textScore"}},
+		[]string{"$textScore:score"}, q.Skip, q.Limit, &found); err != nil {
+		return nil, err
+	}
+	terms := searchTerms(q.Text)
+	results := make([]SearchResult, 0, len(found))
+	for _, f := range found {
+		results = append(results, SearchResult{
+			Unit: f.ContentUnit,
+			Score: f.Score,
+			Highlights: highlights(&f.ContentUnit, terms),
+		})
+	}
+	return results, nil
+}
+
+// searchTerms returns lower case words of the query, excluded "-words" aren't highlighted
+func searchTerms(text string) []string {
+	var terms []string
+	for _, w := range strings.Fields(text) {
+		if strings.HasPrefix(w, "-") {
+			continue
+		}
+		for _, t := range strings.FieldsFunc(strings.ToLower(w), isNotWordRune) {
+			terms = append(terms, t)
+		}
+	}
+	return terms
+}
+
+func isNotWordRune(r rune) bool {
+	return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+}
+
+func highlights(u *general.ContentUnit, terms []string) map[string][]string {
+	result := make(map[string][]string)
+	values := map[string]string{"title": u.Title, "author": u.Author, "comment": u.Comment, "desc": u.Desc}
+	for _, field := range searchFields {
+		if fragments := highlight(values[field], terms); len(fragments) > 0 {
+			result[field] = fragments
+		}
+	}
+	return result
+}
+
+type span struct {
+	start, end int
+}
+
+// matchWord compares with prefixes both ways to tolerate word forms, e.g. "narrator" and "narrators"
+func matchWord(word string, terms []string) bool {
+	for _, t := range terms {
+		if strings.HasPrefix(word, t) || (utf8.RuneCountInString(word) >= 3 && strings.HasPrefix(t, word)) {
+			return true
+		}
+	}
+	return false
+}
+
+// highlight returns fragments of the text around matched words, close matches share a fragment
+func highlight(text string, terms []string) []string {
+	if text == "" || len(terms) == 0 {
+		return nil
+	}
+	runes := []rune(text)
+	var matches []span
+	for i := 0; i < len(runes); {
+		if isNotWordRune(runes[i]) {
+			i++
+			continue
+		}
+		j := i
+		for j < len(runes) && !isNotWordRune(runes[j]) {
+			j++
+		}
+		if matchWord(strings.ToLower(string(runes[i:j])), terms) {
+			matches = append(matches, span{i, j})
+		}
+		i = j
+	}
+
+	var fragments []string
+	for i := 0; i < len(matches) && len(fragments) < FRAGMENTS_PER_FIELD; {
+		start := matches[i].start - FRAGMENT_CONTEXT
+		if start < 0 {
+			start = 0
+		}
+		end := matches[i].end + FRAGMENT_CONTEXT
+		j := i + 1
+		for j < len(matches) && matches[j].start < end {
+			if e := matches[j].end + FRAGMENT_CONTEXT; e > end {
+				end = e
+			}
+			j++
+		}
+		if end > len(runes) {
+			end = len(runes)
+		}
+		var b bytes.Buffer
+		if start > 0 {
+			b.WriteString("…")
+		}
+		pos := start
+		for _, m := range matches[i:j] {
+			b.WriteString(html.EscapeString(string(runes[pos:m.start])))
+			b.WriteString(HIGHLIGHT_PRE)
+			b.WriteString(html.EscapeString(string(runes[m.start:m.end])))
+			b.WriteString(HIGHLIGHT_POST)
+			pos = m.end
+		}
+		b.WriteString(html.EscapeString(string(runes[pos:end])))
+		if end < len(runes) {
+			b.WriteString("…")
+		}
+		fragments = append(fragments, b.String())
+		i = j
+	}
+	return fragments
+}
@@ -0,0 +1,106 @@
+package content
+
+import (
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gopkg.in/mgo.v2/bson"
+	"github.com/dzendmitry/rating-service/lib/general"
+)
+
+func TestParseSearchQuery(t *testing.T) {
+	q, err := ParseSearchQuery(url.Values{"q": {"  dune "}, "type": {general.TYPE_BOOK}, "limit": {"1000"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q.Text != "dune" || q.Type != general.TYPE_BOOK || q.Limit != SEARCH_MAX_LIMIT {
+		t.Fatalf("Unexpected query %+v", q)
+	}
+	for _, form := range []url.Values{
+		{},
+		{"q": {" "}},
+		{"q": {strings.Repeat("a", SEARCH_MAX_QUERY + 1)}},
+		{"q": {"dune"}, "type": {"game"}},
+		{"q": {"dune"}, "skip": {"-1"}},
+		{"q": {"dune"}, "limit": {"ten"}},
+	} {
+		if _, err := ParseSearchQuery(form); err == nil {
+			t.Fatalf("The query %v is accepted", form)
+		}
+	}
+}
+
+func TestSearchTerms(t *testing.T) {
+	terms := searchTerms("Star -wars Empire's  ")
+	if expected := []string{"star", "empire", "s"}; !reflect.DeepEqual(terms, expected) {
+		t.Fatalf("Expected %v, got %v", expected, terms)
+	}
+}
+
+func TestHighlight(t *testing.T) {
+	for _, c := range []struct {
+		name string
+		text string
+		terms []string
+		fragments []string
+	}{
+		{"escaped", "Tom & Jerry <cartoon>", []string{"jerry"}, []string{"Tom &amp; <em>Jerry</em> &lt;cartoon&gt;"}},
+		{"escaped match", "a<b", []string{"a", "b"}, []string{"<em>a</em>&lt;<em>b</em>"}},
+		{"longer word", "The narrators speak", []string{"narrator"}, []string{"The <em>narrators</em> speak"}},
+		{"shorter word", "The narrator speaks", []string{"narrators"}, []string{"The <em>narrator</em> speaks"}},
+		{"short word isn't a prefix", "a narrator", []string{"apple"}, nil},
+		{"close matches share a fragment", "cat and cat", []string{"cat"}, []string{"<em>cat</em> and <em>cat</em>"}},
+		{
+			"context",
+			strings.Repeat("x ", 50) + "match" + strings.Repeat(" y", 50),
+			[]string{"match"},
+			[]string{"…" + strings.Repeat("x ", 30) + "<em>match</em>" + strings.Repeat(" y", 30) + "…"},
+		},
+		{"no match", "Dune", []string{"arrakis"}, nil},
+		{"no terms", "Dune", nil, nil},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			if fragments := highlight(c.text, c.terms); !reflect.DeepEqual(fragments, c.fragments) {
+				t.Fatalf("Expected %q, got %q", c.fragments, fragments)
+			}
+		})
+	}
+
+	far := strings.Repeat("match" + strings.Repeat(" x", FRAGMENT_CONTEXT) + " ", FRAGMENTS_PER_FIELD + 1)
+	if fragments := highlight(far, []string{"match"}); len(fragments) != FRAGMENTS_PER_FIELD {
+		t.Fatalf("Expected %d fragments, got %d", FRAGMENTS_PER_FIELD, len(fragments))
+	}
+}
+
+// textSource returns the found units and keeps the query
+type textSource struct {
+	query interface{}
+	found []scoredUnit
+}
+
+func (s *textSource) FindProjected(query, projection interface{}, sort []string, skip, limit int, result interface{}) error {
+	s.query = query
+	*result.(*[]scoredUnit) = s.found
+	return nil
+}
+
+func TestTextIndexSearch(t *testing.T) {
+	uid := bson.NewObjectId()
+	unit := general.ContentUnit{Id: bson.NewObjectId(), Title: "Dune", Author: "Frank Herbert", Desc: "Arrakis, the desert planet"}
+	source := &textSource{found: []scoredUnit{{ContentUnit: unit, Score: 1.5}}}
+	results, err := NewTextIndex(source).Search(uid, &SearchQuery{Text: "dune -herbert desert", Type: general.TYPE_BOOK, Limit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	selector := source.query.(bson.M)
+	if selector["uid"] != uid || selector["type"] != general.TYPE_BOOK {
+		t.Fatalf("The search isn't limited to the user's books: %v", selector)
+	}
+	expected := map[string][]string{"title": {"<em>Dune</em>"}, "desc": {"Arrakis, the <em>desert</em> planet"}}
+	if len(results) != 1 || results[0].Unit.Id != unit.Id || results[0].Score != 1.5 ||
+		!reflect.DeepEqual(results[0].Highlights, expected) {
+		t.Fatalf("Unexpected results %+v", results)
+	}
+}
@@ -0,0 +1,71 @@
+package content
+
+import (
+	"time"
+
+	"github.com/dzendmitry/rating-service/lib/general"
+)
+
+// SetStatus applies the status and dates of the request to the unit, stars of the unit must be already set.
+// Current is the saved unit on edit, absent status and dates are kept from it, rating of a wanted unit
+// finishes it like a rated diary entry does. Units saved before statuses were introduced have no status
+// and are treated as finished. Units added or moved to progress or finished must be rated.
+func SetStatus(unit *general.ContentUnit, req *general.ContentUnit, current *general.ContentUnit) error {
+	status, started, finished := req.Status, req.Started, req.Finished
+	// The status is chosen by the request, kept statuses aren't checked for the rating
+	chosen := current == nil || status != ""
+	if current != nil {
+		if status == "" {
+			status = current.Status
+			if status == general.STATUS_WANT && unit.Stars > 0 {
+				status = general.STATUS_FINISHED
+			}
+		}
+		if started == nil {
+			started = current.Started
+		}
+		if finished == nil {
+			finished = current.Finished
+		}
+	} else if status == "" {
+		status = general.STATUS_FINISHED
+	}
+
+	now := time.Now()
+	switch status {
+	case general.STATUS_WANT:
+		if unit.Stars > 0 {
+			return ErrorInvalidUnit{"Units the user wants can't be rated"}
+		}
+		if req.Started != nil || req.Finished != nil {
+			return ErrorInvalidUnit{"Units the user wants have no dates"}
+		}
+		started, finished = nil, nil
+	case general.STATUS_IN_PROGRESS:
+		if chosen && unit.Stars == 0 {
+			return ErrorInvalidUnit{"Units in progress must be rated, only wanted and abandoned units may have no stars"}
+		}
+		if req.Finished != nil {
+			return ErrorInvalidUnit{"Units in progress have no finish date"}
+		}
+		finished = nil
+		if started == nil {
+			started = &now
+		}
+	case general.STATUS_FINISHED, general.STATUS_ABANDONED:
+		if chosen && status == general.STATUS_FINISHED && unit.Stars == 0 {
+			return ErrorInvalidUnit{"Finished units must be rated, only wanted and abandoned units may have no stars"}
+		}
+		if finished == nil {
+			finished = &now
+		}
+	case "":
+	default:
+		return ErrorInvalidUnit{"Unknown status: " + status}
+	}
+	if started != nil && finished != nil && finished.Before(*started) {
+		return ErrorInvalidUnit{"Finish date is before start date"}
+	}
+	unit.Status, unit.Started, unit.Finished = status, started, finished
+	return nil
+}
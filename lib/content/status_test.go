@@ -0,0 +1,79 @@
+package content
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dzendmitry/rating-service/lib/general"
+)
+
+func TestSetStatus(t *testing.T) {
+	started, finished := daysAgo(3), daysAgo(1)
+	for _, c := range []struct {
+		name string
+		stars int
+		req general.ContentUnit
+		// nil on add
+		current *general.ContentUnit
+		status string
+		// Dates are expected to be set, the exact ones are checked when given in the request
+		started, finished bool
+		ok bool
+	}{
+		{name: "rated without status", stars: 4, status: general.STATUS_FINISHED, finished: true, ok: true},
+		{name: "unrated without status"},
+		{name: "wanted", req: general.ContentUnit{Status: general.STATUS_WANT}, status: general.STATUS_WANT, ok: true},
+		{name: "wanted and rated", stars: 4, req: general.ContentUnit{Status: general.STATUS_WANT}},
+		{name: "wanted with dates", req: general.ContentUnit{Status: general.STATUS_WANT, Started: started}},
+		{name: "abandoned unrated", req: general.ContentUnit{Status: general.STATUS_ABANDONED},
+			status: general.STATUS_ABANDONED, finished: true, ok: true},
+		{name: "in progress", stars: 3, req: general.ContentUnit{Status: general.STATUS_IN_PROGRESS},
+			status: general.STATUS_IN_PROGRESS, started: true, ok: true},
+		{name: "in progress unrated", req: general.ContentUnit{Status: general.STATUS_IN_PROGRESS}},
+		{name: "in progress with finish date", stars: 3,
+			req: general.ContentUnit{Status: general.STATUS_IN_PROGRESS, Finished: finished}},
+		{name: "finished with dates", stars: 3,
+			req: general.ContentUnit{Status: general.STATUS_FINISHED, Started: started, Finished: finished},
+			status: general.STATUS_FINISHED, started: true, finished: true, ok: true},
+		{name: "finished before started", stars: 3,
+			req: general.ContentUnit{Status: general.STATUS_FINISHED, Started: finished, Finished: started}},
+		{name: "unknown status", stars: 3, req: general.ContentUnit{Status: "watched"}},
+
+		{name: "wanted rated on edit", stars: 4, current: &general.ContentUnit{Status: general.STATUS_WANT},
+			status: general.STATUS_FINISHED, finished: true, ok: true},
+		{name: "wanted edited unrated", current: &general.ContentUnit{Status: general.STATUS_WANT},
+			status: general.STATUS_WANT, ok: true},
+		{name: "wanted moved to progress unrated", current: &general.ContentUnit{Status: general.STATUS_WANT},
+			req: general.ContentUnit{Status: general.STATUS_IN_PROGRESS}},
+		{name: "status and dates kept", current: &general.ContentUnit{Status: general.STATUS_FINISHED, Started: started, Finished: finished},
+			status: general.STATUS_FINISHED, started: true, finished: true, ok: true},
+		{name: "unit without status", stars: 3, current: &general.ContentUnit{}, ok: true},
+		{name: "finish date before the kept start", stars: 3,
+			current: &general.ContentUnit{Status: general.STATUS_IN_PROGRESS, Started: finished},
+			req: general.ContentUnit{Status: general.STATUS_FINISHED, Finished: started}},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			unit := general.ContentUnit{Stars: c.stars}
+			err := SetStatus(&unit, &c.req, c.current)
+			if !c.ok {
+				if _, ok := err.(ErrorInvalidUnit); !ok {
+					t.Fatalf("Expected ErrorInvalidUnit, got %#v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error %+v", err)
+			}
+			if unit.Status != c.status || (unit.Started != nil) != c.started || (unit.Finished != nil) != c.finished {
+				t.Fatalf("Unexpected status %q started %v finished %v", unit.Status, unit.Started, unit.Finished)
+			}
+			for _, d := range []struct {
+				req, set *time.Time
+			}{{c.req.Started, unit.Started}, {c.req.Finished, unit.Finished}} {
+				if d.req != nil && !d.req.Equal(*d.set) {
+					t.Fatalf("The date %s isn't kept: %s", d.req, d.set)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,64 @@
+package content
+
+import (
+	"gopkg.in/mgo.v2/bson"
+	"strings"
+)
+
+// NormalizeTags lower cases and trims tags, duplicates and empty ones are dropped keeping the order
+func NormalizeTags(tags []string) []string {
+	result := make([]string, 0, len(tags))
+	seen := make(map[string]bool, len(tags))
+	for _, t := range tags {
+		t = strings.ToLower(strings.Join(strings.Fields(t), " "))
+		if t == "" || seen[t] {
+			continue
+		}
+		seen[t] = true
+		result = append(result, t)
+	}
+	return result
+}
+
+// Tags returns tags of the user's units with the number of units, the most used go first
+func Tags(units IContentDataSource, uid bson.ObjectId, unitType string) ([]TagCount, error) {
+	match := bson.M{"uid": uid, "tags": bson.M{"$exists": true}}
+	if unitType != "" {
+		match["type"] = unitType
+	}
+	tags := make([]TagCount, 0)
+	if err := units.Aggregate([]bson.M{
+		{"$match": match},
+		{"$unwind": "$tags"},
+		{"$group": bson.M{"_id": "$tags", "count": bson.M{"$sum": 1}}},
+		{"$sort": bson.D{{Name: "count", Value: -1}, {Name: "_id", Value: 1}}},
+	}, &tags); err != nil {
+		return nil, err
+	}
+	return tags, nil
+}
+
+// RenameTag renames the tag in all units of the user. Renaming to an existing tag merges them.
+func RenameTag(units IContentDataSource, uid bson.ObjectId, data *TagRenameData) error {
+	from, to := NormalizeTags([]string{data.From}), NormalizeTags([]string{data.To})
+	if len(from) == 0 || len(to) == 0 {
+		return ErrorInvalidQuery{"Tags must not be empty"}
+	}
+	if from[0] == to[0] {
+		return ErrorInvalidQuery{"Tags must differ"}
+	}
+	var unit bson.M
+	if err := units.FindOne(bson.M{"uid": uid, "tags": from[0]}, &unit); err != nil {
+		if err.Error() == "not found" {
+			return ErrorTagNotFound{}
+		}
+		return err
+	}
+	// A field can't be added to and pulled from in one update. Units with both tags after
+	// the first step are consistent, so an interrupted rename can be repeated.
+	selector := bson.M{"uid": uid, "tags": from[0]}
+	if err := units.UpdateAll(selector, bson.M{"$addToSet": bson.M{"tags": to[0]}}); err != nil {
+		return err
+	}
+	return units.UpdateAll(selector, bson.M{"$pull": bson.M{"tags": from[0]}})
+}
@@ -0,0 +1,68 @@
+package content
+
+import (
+	"reflect"
+	"testing"
+
+	"gopkg.in/mgo.v2/bson"
+	"github.com/dzendmitry/rating-service/lib/general"
+)
+
+func TestNormalizeTags(t *testing.T) {
+	tags := NormalizeTags([]string{" Sci  Fi ", "drama", "sci fi", "", "  ", "DRAMA", "noir"})
+	if expected := []string{"sci fi", "drama", "noir"}; !reflect.DeepEqual(tags, expected) {
+		t.Fatalf("Expected %q, got %q", expected, tags)
+	}
+}
+
+func TestRenameTag(t *testing.T) {
+	units := newMemSource()
+	uid, other := bson.NewObjectId(), bson.NewObjectId()
+	insert := func(uid bson.ObjectId, tags ...string) bson.ObjectId {
+		id := bson.NewObjectId()
+		if err := units.Insert(general.ContentUnit{Id: id, Uid: uid, Tags: tags}); err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+	renamed := insert(uid, "scifi", "drama")
+	merged := insert(uid, "sci fi", "scifi")
+	kept := insert(uid, "drama")
+	foreign := insert(other, "scifi")
+
+	if err := RenameTag(units, uid, &TagRenameData{From: "SciFi", To: " Sci  Fi"}); err != nil {
+		t.Fatal(err)
+	}
+	for id, expected := range map[bson.ObjectId][]string{
+		renamed: {"drama", "sci fi"},
+		// Both tags become one
+		merged: {"sci fi"},
+		kept: {"drama"},
+		foreign: {"scifi"},
+	} {
+		var unit general.ContentUnit
+		if err := units.FindOne(bson.M{"_id": id}, &unit); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(unit.Tags, expected) {
+			t.Fatalf("Expected %q, got %q", expected, unit.Tags)
+		}
+	}
+
+	for _, c := range []struct {
+		name string
+		data TagRenameData
+		err error
+	}{
+		{"empty", TagRenameData{From: " ", To: "drama"}, ErrorInvalidQuery{"Tags must not be empty"}},
+		{"same", TagRenameData{From: "Drama", To: "drama "}, ErrorInvalidQuery{"Tags must differ"}},
+		{"renamed", TagRenameData{From: "scifi", To: "drama"}, ErrorTagNotFound{}},
+		{"tag of another user", TagRenameData{From: "scifi", To: "sci fi"}, ErrorTagNotFound{}},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			if err := RenameTag(units, uid, &c.data); err != c.err {
+				t.Fatalf("Expected %#v, got %#v", c.err, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,190 @@
+package content
+
+import (
+	"gopkg.in/mgo.v2/bson"
+	"time"
+
+	"github.com/dzendmitry/rating-service/lib/general"
+)
+
+// Query describes a page of the user's library, zero values of the filters are not applied
+type Query struct {
+	Type string
+	Sort string
+	Desc bool
+	Limit int
+	StarsMin int
+	StarsMax int
+	YearMin int
+	YearMax int
+	EditedSince time.Time
+	// nil doesn't filter by comment
+	HasComment *bool
+	// Units must have all of the tags
+	Tags []string
+	// Units must have one of the statuses
+	Statuses []string
+	Cursor *Cursor
+}
+
+// Cursor points to the last unit of the previous page, it's bound to the sort of the query
+type Cursor struct {
+	Sort string        `json:"s"`
+	Desc bool          `json:"d,omitempty"`
+	Time time.Time     `json:"t,omitempty"`
+	Int int            `json:"i,omitempty"`
+	Str string         `json:"v,omitempty"`
+	Id bson.ObjectId   `json:"id"`
+}
+
+type Page struct {
+	Units []general.ContentUnit `json:"units"`
+	// Continuation token of the next page, it's empty on the last one
+	Next string                 `json:"next,omitempty"`
+}
+
+type TagCount struct {
+	Tag string `json:"tag"   bson:"_id"`
+	Count int  `json:"count" bson:"count"`
+}
+
+type TagRenameData struct {
+	From string `json:"from"`
+	To string   `json:"to"`
+}
+
+type List struct {
+	Id bson.ObjectId    `bson:"_id"`
+	Uid bson.ObjectId   `bson:"uid"`
+	Name string         `bson:"name"`
+	Description string  `bson:"description"`
+	Privacy string      `bson:"privacy"`
+	Entries []ListEntry `bson:"entries"`
+	Created time.Time   `bson:"created"`
+	Edited time.Time    `bson:"edited"`
+}
+
+type ListEntry struct {
+	Unit bson.ObjectId `bson:"unit"`
+	Added time.Time    `bson:"added"`
+}
+
+type ListInfo struct {
+	Id bson.ObjectId   `json:"id"`
+	Name string        `json:"name"`
+	Description string `json:"description"`
+	Privacy string     `json:"privacy"`
+	Size int           `json:"size"`
+	// Limit of entries in the list
+	MaxSize int        `json:"max_size"`
+	Created time.Time  `json:"created"`
+	Edited time.Time   `json:"edited"`
+}
+
+type ListUnit struct {
+	general.ContentUnit
+	Added time.Time `json:"added"`
+}
+
+type ListWithUnits struct {
+	ListInfo
+	Units []ListUnit `json:"units"`
+}
+
+// ListData contains only changed fields on update, absent ones are kept
+type ListData struct {
+	Id bson.ObjectId     `json:"id"`
+	Name *string         `json:"name"`
+	Description *string  `json:"description"`
+	Privacy *string      `json:"privacy"`
+}
+
+type ListId struct {
+	Id bson.ObjectId `json:"id"`
+}
+
+type ListEntryData struct {
+	Id bson.ObjectId   `json:"id"`
+	Unit bson.ObjectId `json:"unit_id"`
+	// Position in the list, the unit is appended when it's absent
+	Position *int      `json:"position"`
+}
+
+type ListOrderData struct {
+	Id bson.ObjectId      `json:"id"`
+	Units []bson.ObjectId `json:"unit_ids"`
+}
+
+type DiaryEntry struct {
+	Id bson.ObjectId   `json:"id"      bson:"_id"`
+	Uid bson.ObjectId  `json:"-"       bson:"uid"`
+	Unit bson.ObjectId `json:"unit_id" bson:"unit"`
+	// Date of watching or reading
+	Date time.Time     `json:"date"    bson:"date"`
+	// Stars are absent when the entry isn't rated
+	Stars *int         `json:"stars,omitempty" bson:"stars,omitempty"`
+	Note string        `json:"note"    bson:"note"`
+	Created time.Time  `json:"created" bson:"created"`
+}
+
+// DiaryEntryData contains only changed fields on edit, absent ones are kept
+type DiaryEntryData struct {
+	Id bson.ObjectId   `json:"id"`
+	Unit bson.ObjectId `json:"unit_id"`
+	Date *time.Time    `json:"date"`
+	Stars *int         `json:"stars"`
+	Note *string       `json:"note"`
+}
+
+type DiaryEntryId struct {
+	Id bson.ObjectId `json:"id"`
+}
+
+type DiaryQuery struct {
+	Unit bson.ObjectId
+	From time.Time
+	To time.Time
+	Desc bool
+	Skip int
+	Limit int
+}
+
+type DiaryItem struct {
+	DiaryEntry
+	Type string   `json:"type"`
+	Title string  `json:"title"`
+	Year string   `json:"year"`
+	PicUrl string `json:"pic_url"`
+}
+
+// UnitVersion contains versioned fields of the unit
+type UnitVersion struct {
+	Stars int           `json:"stars"              bson:"stars"`
+	Comment string      `json:"comment"            bson:"comment"`
+	Status string       `json:"status,omitempty"   bson:"status,omitempty"`
+	Started *time.Time  `json:"started,omitempty"  bson:"started,omitempty"`
+	Finished *time.Time `json:"finished,omitempty" bson:"finished,omitempty"`
+}
+
+type HistoryEntry struct {
+	Id bson.ObjectId   `json:"id"            bson:"_id"`
+	Uid bson.ObjectId  `json:"-"             bson:"uid"`
+	Unit bson.ObjectId `json:"unit_id"       bson:"unit"`
+	// Old is absent for the added unit
+	Old *UnitVersion   `json:"old,omitempty" bson:"old,omitempty"`
+	New UnitVersion    `json:"new"           bson:"new"`
+	Source string      `json:"source"        bson:"source"`
+	// Id of the entry which version is restored by the change
+	Restored bson.ObjectId `json:"restored,omitempty" bson:"restored,omitempty"`
+	Created time.Time  `json:"created"       bson:"created"`
+}
+
+type HistoryQuery struct {
+	Unit bson.ObjectId
+	Skip int
+	Limit int
+}
+
+type HistoryEntryId struct {
+	Id bson.ObjectId `json:"id"`
+}
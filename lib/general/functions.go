@@ -2,6 +2,8 @@ package general
 
 import (
 	"crypto/md5"
+	"crypto/rand"
+	"encoding/hex"
 	"io"
 	"fmt"
 	"strings"
@@ -94,4 +96,11 @@ func ValidateRequest(req *http.Request, method string, checkJson bool) ([]byte,
 		return nil, errors.New(fmt.Sprintf("Error while closing data request %s: %s", req.RequestURI, err.Error())), http.StatusInternalServerError
 	}
 	return body, nil, 0
-}
\ No newline at end of file
+}
+func GetRandomToken(size int) (string, error) {
+	buf := make([]byte, size)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
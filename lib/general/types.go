@@ -9,8 +9,20 @@ import (
 const (
 	TYPE_MOVIE = "movie"
 	TYPE_BOOK = "book"
+
+	STATUS_WANT = "want"
+	STATUS_IN_PROGRESS = "in_progress"
+	STATUS_FINISHED = "finished"
+	STATUS_ABANDONED = "abandoned"
 )
 
+var Statuses map[string]bool = map[string]bool{
+	STATUS_WANT: true,
+	STATUS_IN_PROGRESS: true,
+	STATUS_FINISHED: true,
+	STATUS_ABANDONED: true,
+}
+
 var ParserTypes map[string]bool = map[string]bool{
 	TYPE_MOVIE: true,
 	TYPE_BOOK : true,
@@ -37,6 +49,10 @@ type ContentUnit struct {
 	Year string       `json:"year"     bson:"year"`
 	Author string     `json:"author"   bson:"author"`
 	Isbn string       `json:"isbn"     bson:"isbn"`
+	Tags []string     `json:"tags"     bson:"tags,omitempty"`
+	Status string     `json:"status"   bson:"status,omitempty"`
+	Started *time.Time  `json:"started,omitempty"  bson:"started,omitempty"`
+	Finished *time.Time `json:"finished,omitempty" bson:"finished,omitempty"`
 }
 
 type ContentResp []ContentUnit
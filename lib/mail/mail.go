@@ -0,0 +1,78 @@
+package mail
+
+import (
+	"errors"
+	"fmt"
+	"net/smtp"
+	"strings"
+
+	"github.com/dzendmitry/logger"
+)
+
+const (
+	MAILER_SMTP = "smtp"
+	MAILER_LOG  = "log"
+)
+
+type IMailer interface {
+	Send(to, subject, body string) error
+}
+
+func New(mailerType, host, port, user, pass, from string, log logger.ILogger) (IMailer, error) {
+	switch mailerType {
+	case MAILER_SMTP:
+		if host == "" || port == "" || from == "" {
+			return nil, errors.New("Smtp mailer needs host, port and from address")
+		}
+		return NewSmtpMailer(host, port, user, pass, from), nil
+	case MAILER_LOG, "":
+		return NewLogMailer(log), nil
+	default:
+		return nil, errors.New(fmt.Sprintf("Unknown mailer type: %s", mailerType))
+	}
+}
+
+type SmtpMailer struct {
+	addr string
+	auth smtp.Auth
+	from string
+}
+
+func NewSmtpMailer(host, port, user, pass, from string) *SmtpMailer {
+	var auth smtp.Auth
+	if user != "" {
+		auth = smtp.PlainAuth("", user, pass, host)
+	}
+	return &SmtpMailer{
+		addr: host + ":" + port,
+		auth: auth,
+		from: from,
+	}
+}
+
+func (m *SmtpMailer) Send(to, subject, body string) error {
+	msg := strings.Join([]string{
+		"From: " + m.from,
+		"To: " + to,
+		"Subject: " + subject,
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=\"utf-8\"",
+		"",
+		body,
+	}, "\r\n")
+	return smtp.SendMail(m.addr, m.auth, m.from, []string{to}, []byte(msg))
+}
+
+// LogMailer writes messages to the log instead of sending them. It's used for testing and development.
+type LogMailer struct {
+	log logger.ILogger
+}
+
+func NewLogMailer(log logger.ILogger) *LogMailer {
+	return &LogMailer{log: log}
+}
+
+func (m *LogMailer) Send(to, subject, body string) error {
+	m.log.Infof("Mail to: %s, subject: %s\n%s", to, subject, body)
+	return nil
+}
@@ -5,6 +5,16 @@ var (
 	Sessions = &DefaultCollection{"sessions"}
 	Answers = &DefaultCollection{"answers"}
 	Units = &DefaultCollection{"units"}
+	ResetTokens = &DefaultCollection{"resettokens"}
+	VerifyTokens = &DefaultCollection{"verifytokens"}
+	LockEvents = &DefaultCollection{"lockevents"}
+	ApiKeys = &DefaultCollection{"apikeys"}
+	OidcStates = &DefaultCollection{"oidcstates"}
+	AuditEvents = &DefaultCollection{"auditevents"}
+	Invites = &DefaultCollection{"invites"}
+	Lists = &DefaultCollection{"lists"}
+	Diary = &DefaultCollection{"diary"}
+	History = &DefaultCollection{"history"}
 )
 
 type DefaultCollection struct {
@@ -29,6 +39,12 @@ func (d *DefaultCollection) Remove(selector interface{}) error {
 	return s.Remove(d.CName, selector)
 }
 
+func (d *DefaultCollection) RemoveAll(selector interface{}) error {
+	s := GetSessionCopy()
+	defer s.Close()
+	return s.RemoveAll(d.CName, selector)
+}
+
 func (d *DefaultCollection) FindAll(query interface{}, result interface{}) error {
 	s := GetSessionCopy()
 	err := s.Find(d.CName, query).All(result)
@@ -36,6 +52,33 @@ func (d *DefaultCollection) FindAll(query interface{}, result interface{}) error
 	return err
 }
 
+func (d *DefaultCollection) FindRange(query interface{}, sort []string, skip, limit int, result interface{}) error {
+	s := GetSessionCopy()
+	defer s.Close()
+	q := s.Find(d.CName, query)
+	if len(sort) > 0 {
+		q = q.Sort(sort...)
+	}
+	return q.Skip(skip).Limit(limit).All(result)
+}
+
+// FindProjected is FindRange which returns only the projected fields, it's used for text search scores
+func (d *DefaultCollection) FindProjected(query, projection interface{}, sort []string, skip, limit int, result interface{}) error {
+	s := GetSessionCopy()
+	defer s.Close()
+	q := s.Find(d.CName, query).Select(projection)
+	if len(sort) > 0 {
+		q = q.Sort(sort...)
+	}
+	return q.Skip(skip).Limit(limit).All(result)
+}
+
+func (d *DefaultCollection) Aggregate(pipeline interface{}, result interface{}) error {
+	s := GetSessionCopy()
+	defer s.Close()
+	return s.Pipe(d.CName, pipeline).All(result)
+}
+
 func (d *DefaultCollection) FindOne(query interface{}, result interface{}) error {
 	s := GetSessionCopy()
 	defer s.Close()
@@ -48,6 +91,12 @@ func (d *DefaultCollection) Update(selector, update interface{}) error {
 	return s.Update(d.CName, selector, update)
 }
 
+func (d *DefaultCollection) UpdateAll(selector, update interface{}) error {
+	s := GetSessionCopy()
+	defer s.Close()
+	return s.UpdateAll(d.CName, selector, update)
+}
+
 func (d *DefaultCollection) Upsert(selector, update interface{}) error {
 	s := GetSessionCopy()
 	defer s.Close()
@@ -191,6 +191,11 @@ func (s *Session) Find(cName string, query interface{}) *mgo.Query {
 	return mgoQuery
 }
 
+func (s *Session) Pipe(cName string, pipeline interface{}) *mgo.Pipe {
+	log.Infof("Executing in %s pipeline %#v", cName, pipeline)
+	return s.collection(cName).Pipe(pipeline)
+}
+
 func (s *Session) Insert(cName string, docs ...interface{}) error {
 	log.Infof("inserting to %s documents %#v", cName, docs)
 
@@ -231,6 +236,26 @@ func (s *Session) Remove(cName string, selector interface{}) error {
 	return err
 }
 
+func (s *Session) RemoveAll(cName string, selector interface{}) error {
+	log.Infof("removing documents from %s %#v", cName, selector)
+
+	c := s.collection(cName)
+
+	_, err := c.RemoveAll(selector)
+	if err != nil {
+		log.Infof("error removing documents from %s: %s (%#v)", cName, err.Error(), selector)
+		if worthRefresh(err) {
+			s.Refresh()
+			_, err = c.RemoveAll(selector)
+			if err != nil {
+				log.Fatalf("retry attempt: error removing documents from %s: %s (%#v)", cName, err.Error(), selector)
+				disconnectDetected()
+			}
+		}
+	}
+	return err
+}
+
 func (s *Session) Update(cName string, selector, update interface{}) error {
 	log.Infof("updating document in %s %#v with %#v", cName, selector, update)
 
@@ -251,6 +276,26 @@ func (s *Session) Update(cName string, selector, update interface{}) error {
 	return err
 }
 
+func (s *Session) UpdateAll(cName string, selector, update interface{}) error {
+	log.Infof("updating documents in %s %#v with %#v", cName, selector, update)
+
+	c := s.collection(cName)
+
+	_, err := c.UpdateAll(selector, update)
+	if err != nil {
+		log.Infof("error updating documents in %s: %s (%#v, %#v)", cName, err.Error(), selector, update)
+		if worthRefresh(err) {
+			s.Refresh()
+			_, err = c.UpdateAll(selector, update)
+			if err != nil {
+				log.Fatalf("retry attempt: error updating documents in %s: %s (%#v, %#v)", cName, err.Error(), selector, update)
+				disconnectDetected()
+			}
+		}
+	}
+	return err
+}
+
 func (s *Session) Upsert(cName string, selector, update interface{}) error {
 	log.Infof("upserting document into %s %#v with %#v", cName, selector, update)
 
@@ -192,4 +192,84 @@ func GetSentiel(master, key string) ([]byte, error) {
 	}
 	c.PutMaster(master, conn)
 	return []byte(str), nil
-}
\ No newline at end of file
+}
+func getMaster(master string) (*sentinel.Client, *redis.Client, error) {
+	c := getClient()
+	if c == nil {
+		disconnectDetected()
+		return nil, nil, errors.New("Redis cache disconnected")
+	}
+	conn, err := c.GetMaster(master)
+	if err != nil {
+		disconnectDetected()
+		time.Sleep(GET_MASTER_TIMEOUT * time.Millisecond)
+		if conn, err = c.GetMaster(master); err != nil {
+			return nil, nil, err
+		}
+	}
+	return c, conn, nil
+}
+
+// IncrExSentiel increments the counter and sets its expiration when the counter is created
+func IncrExSentiel(master, key string, ex int) (int, error) {
+	c, conn, err := getMaster(master)
+	if err != nil {
+		return 0, err
+	}
+	n, err := conn.Cmd("INCR", key).Int()
+	if err != nil {
+		return 0, err
+	}
+	if n == 1 {
+		if err = conn.Cmd("EXPIRE", key, ex).Err; err != nil {
+			return 0, err
+		}
+	}
+	c.PutMaster(master, conn)
+	return n, nil
+}
+
+// GetIntSentiel returns 0 for absent keys
+func GetIntSentiel(master, key string) (int, error) {
+	c, conn, err := getMaster(master)
+	if err != nil {
+		return 0, err
+	}
+	resp := conn.Cmd("GET", key)
+	if resp.Err != nil {
+		return 0, resp.Err
+	}
+	c.PutMaster(master, conn)
+	if resp.IsType(redis.Nil) {
+		return 0, nil
+	}
+	return resp.Int()
+}
+
+func SetIntExSentiel(master, key string, value, ex int) error {
+	c, conn, err := getMaster(master)
+	if err != nil {
+		return err
+	}
+	if err = conn.Cmd("SET", key, value, "EX", ex).Err; err != nil {
+		return err
+	}
+	c.PutMaster(master, conn)
+	return nil
+}
+
+func DelSentiel(master string, keys ...string) error {
+	c, conn, err := getMaster(master)
+	if err != nil {
+		return err
+	}
+	args := make([]interface{}, len(keys))
+	for i, k := range keys {
+		args[i] = k
+	}
+	if err = conn.Cmd("DEL", args...).Err; err != nil {
+		return err
+	}
+	c.PutMaster(master, conn)
+	return nil
+}
@@ -16,22 +16,36 @@ import (
 	"github.com/dzendmitry/rating-service/lib/general"
 	"github.com/dzendmitry/rating-service/lib/auth"
 	"github.com/dzendmitry/rating-service/lib/mongo"
+	"github.com/dzendmitry/rating-service/lib/content"
 )
 
 const (
 	CONTENT_USER_PART_VALIDATE = "content-user-part"
+	TAG_RENAME_VALIDATE = "tag-rename"
+	LIST_VALIDATE = "list"
+	LIST_ID_VALIDATE = "list-id"
+	LIST_ENTRY_VALIDATE = "list-entry"
+	LIST_ORDER_VALIDATE = "list-order"
+	DIARY_ENTRY_VALIDATE = "diary-entry"
+	DIARY_ID_VALIDATE = "diary-id"
+	HISTORY_ID_VALIDATE = "history-id"
 )
 
 type Handlers struct {
 	plTypeC chan udp.GetParsersCmd
 	validator *general.Validator
+	auth *auth.Client
+	searcher content.ISearcher
 	log logger.ILogger
 }
 
-func NewHandlers(plTypeC chan udp.GetParsersCmd, validator *general.Validator, log logger.ILogger) *Handlers {
+func NewHandlers(plTypeC chan udp.GetParsersCmd, validator *general.Validator, authClient *auth.Client,
+	searcher content.ISearcher, log logger.ILogger) *Handlers {
 	return &Handlers{
 		plTypeC: plTypeC,
 		validator: validator,
+		auth: authClient,
+		searcher: searcher,
 		log: log,
 	}
 }
@@ -111,7 +125,7 @@ func (h *Handlers) findHandler(w http.ResponseWriter, req *http.Request) {
 		w.WriteHeader(http.StatusMethodNotAllowed)
 		return
 	}
-	a, session := auth.Is(req, mongo.Sessions, h.log)
+	a, session := h.auth.Is(req)
 	if !a {
 		h.log.Warnf("Non authorized request: %+v from ip: %+v", req.RequestURI, req.RemoteAddr)
 		w.WriteHeader(http.StatusNotAcceptable)
@@ -217,41 +231,15 @@ func (h *Handlers) findHandler(w http.ResponseWriter, req *http.Request) {
 }
 
 func (h *Handlers) addHandler(w http.ResponseWriter, req *http.Request) {
-	a, session := auth.Is(req, mongo.Sessions, h.log)
-	if !a {
-		h.log.Warnf("Non authorized request: %+v", req.RequestURI)
-		w.WriteHeader(http.StatusNotAcceptable)
-		return
-	}
-
-	body, err, status := general.ValidateRequest(req, http.MethodPost, true)
-	if err != nil {
-		h.log.Warn(err.Error())
-		w.WriteHeader(status)
+	var cont general.ContentUnit
+	session, ok := h.writeRequest(w, req, CONTENT_USER_PART_VALIDATE, &cont)
+	if !ok {
 		return
 	}
-
-	err, errs := h.validator.Validate(body, CONTENT_USER_PART_VALIDATE)
-	if errs != nil {
-		if err != nil {
-			h.log.Warnf("%+v", err.Error())
-		}
-		h.log.Warnf("The document is not valid. see errors :\n")
-		h.log.Warnf("%+v", errs)
-		w.WriteHeader(http.StatusBadRequest)
-		w.Header().Set("Content-Type", "application/json")
-		if err := json.NewEncoder(w).Encode(errs); err != nil {
-			h.log.Warn("Error while encoding validation errors: %s", err.Error())
-			w.WriteHeader(http.StatusInternalServerError)
-			w.Header().Set("Content-Type", "text/plain")
-		}
-		return
-	}
-
-	var cont general.ContentUnit
-	if err := json.Unmarshal(body, &cont); err != nil {
-		h.log.Warnf("Error during unmarshall: %+v", err.Error())
-		w.WriteHeader(http.StatusExpectationFailed)
+	if session.Unverified {
+		h.log.Warnf("Request from user with unverified email: %+v", req.RequestURI)
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(auth.ErrorEmailNotVerified{}.Error()))
 		return
 	}
 	cont.Edited = time.Now()
@@ -265,13 +253,25 @@ func (h *Handlers) addHandler(w http.ResponseWriter, req *http.Request) {
 	cu.Edited = time.Now()
 	cu.Stars = cont.Stars
 	cu.Comment = cont.Comment
+	cu.Tags = content.NormalizeTags(cont.Tags)
 	cu.Uid = session.Uid
+	if err := content.SetStatus(&cu, &cont, nil); err != nil {
+		h.log.Warnf("Invalid unit %s: %s", cont.Id.Hex(), err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
 
 	if err := mongo.Units.Insert(cu); err != nil {
 		h.log.Warnf("Error updateing users content: %+v", err.Error())
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
+	if err := content.RecordChange(mongo.History, session.Uid, nil, &cu, source(session)); err != nil {
+		h.log.Warnf("Error recording history of unit %s: %s", cu.Id.Hex(), err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
 }
 
 func (h *Handlers) getContent(w http.ResponseWriter, req *http.Request) {
@@ -280,84 +280,178 @@ func (h *Handlers) getContent(w http.ResponseWriter, req *http.Request) {
 		w.WriteHeader(http.StatusMethodNotAllowed)
 		return
 	}
-	a, session := auth.Is(req, mongo.Sessions, h.log)
+	a, session := h.auth.Is(req)
 	if !a {
 		h.log.Warnf("Non authorized request: %+v", req.RequestURI)
 		w.WriteHeader(http.StatusNotAcceptable)
 		return
 	}
 
-	var cu []general.ContentUnit
+	var unitType string
 	switch {
 	case strings.HasPrefix(req.RequestURI, getMoviesUrl()):
-		if err := mongo.Units.FindAll(bson.M{"uid": session.Uid, "type": general.TYPE_MOVIE}, &cu); err != nil {
-			h.log.Warnf("Error getting data from mongo req %s: %s", req.RequestURI, err.Error())
-			w.WriteHeader(http.StatusInternalServerError)
-			return
-		}
+		unitType = general.TYPE_MOVIE
 	case strings.HasPrefix(req.RequestURI, getBooksUrl()):
-		if err := mongo.Units.FindAll(bson.M{"uid": session.Uid, "type": general.TYPE_BOOK}, &cu); err != nil {
-			h.log.Warnf("Error getting data from mongo req %s: %s", req.RequestURI, err.Error())
-			w.WriteHeader(http.StatusInternalServerError)
-			return
-		}
+		unitType = general.TYPE_BOOK
 	case strings.HasPrefix(req.RequestURI, getAllContentUrl()):
-		if err := mongo.Units.FindAll(bson.M{"uid": session.Uid}, &cu); err != nil {
-			h.log.Warnf("Error getting data from mongo req %s: %s", req.RequestURI, err.Error())
-			w.WriteHeader(http.StatusInternalServerError)
-			return
-		}
 	default:
 		h.log.Warnf("Unknown request type: %s", req.RequestURI)
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(cu); err != nil {
-		h.log.Warn("Error while encoding content units: %s", err.Error())
+	query, err := content.ParseQuery(req.URL.Query(), unitType)
+	if err != nil {
+		h.log.Warnf("Invalid content query %s: %s", req.RequestURI, err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	page, err := content.FindUnits(mongo.Units, session.Uid, query)
+	if err != nil {
+		h.log.Warnf("Error getting data from mongo req %s: %s", req.RequestURI, err.Error())
 		w.WriteHeader(http.StatusInternalServerError)
-		w.Header().Set("Content-Type", "text/plain")
+		return
 	}
+
+	h.writeJson(w, page)
 }
 
-func (h *Handlers) editHandler(w http.ResponseWriter, req *http.Request) {
-	a, session := auth.Is(req, mongo.Sessions, h.log)
+func (h *Handlers) searchHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		h.log.Warnf("Wrong http search request method: %s", req.Method)
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	a, session := h.auth.Is(req)
 	if !a {
 		h.log.Warnf("Non authorized request: %+v", req.RequestURI)
 		w.WriteHeader(http.StatusNotAcceptable)
 		return
 	}
+
+	query, err := content.ParseSearchQuery(req.URL.Query())
+	if err != nil {
+		h.log.Warnf("Invalid search query %s: %s", req.RequestURI, err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	results, err := h.searcher.Search(session.Uid, query)
+	if err != nil {
+		h.log.Warnf("Error searching content req %s: %s", req.RequestURI, err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	h.writeJson(w, results)
+}
+
+func (h *Handlers) tagsHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		h.log.Warnf("Wrong http tags request method: %s", req.Method)
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	a, session := h.auth.Is(req)
+	if !a {
+		h.log.Warnf("Non authorized request: %+v", req.RequestURI)
+		w.WriteHeader(http.StatusNotAcceptable)
+		return
+	}
+	unitType := req.FormValue("type")
+	if unitType != "" && !general.ParserTypes[unitType] {
+		h.log.Warnf("Wrong type in tags request: %s", unitType)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	tags, err := content.Tags(mongo.Units, session.Uid, unitType)
+	if err != nil {
+		h.log.Warnf("Error getting tags req %s: %s", req.RequestURI, err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	h.writeJson(w, tags)
+}
+
+func (h *Handlers) renameTagHandler(w http.ResponseWriter, req *http.Request) {
+	a, session := h.auth.Is(req)
+	if !a {
+		h.log.Warnf("Non authorized request: %+v", req.RequestURI)
+		w.WriteHeader(http.StatusNotAcceptable)
+		return
+	}
+	if !session.CanWrite() {
+		h.log.Warnf("Write request with read-only credentials: %+v", req.RequestURI)
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(auth.ErrorReadOnly{}.Error()))
+		return
+	}
 	body, err, status := general.ValidateRequest(req, http.MethodPost, true)
 	if err != nil {
 		h.log.Warn(err.Error())
 		w.WriteHeader(status)
 		return
 	}
+	if !h.validate(w, body, TAG_RENAME_VALIDATE) {
+		return
+	}
 
-	err, errs := h.validator.Validate(body, CONTENT_USER_PART_VALIDATE)
-	if errs != nil {
-		if err != nil {
-			h.log.Warnf("%+v", err.Error())
-		}
-		h.log.Warnf("The document is not valid. see errors :\n")
-		h.log.Warnf("%+v", errs)
-		w.WriteHeader(http.StatusBadRequest)
-		w.Header().Set("Content-Type", "application/json")
-		if err := json.NewEncoder(w).Encode(errs); err != nil {
-			h.log.Warn("Error while encoding validation errors: %s", err.Error())
+	var data content.TagRenameData
+	if err := json.Unmarshal(body, &data); err != nil {
+		h.log.Warnf("Error during unmarshall: %+v", err.Error())
+		w.WriteHeader(http.StatusExpectationFailed)
+		return
+	}
+
+	if err := content.RenameTag(mongo.Units, session.Uid, &data); err != nil {
+		h.log.Warnf("Error renaming tag %s: %s", data.From, err.Error())
+		switch err.(type) {
+		case content.ErrorInvalidQuery:
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(err.Error()))
+		case content.ErrorTagNotFound:
+			w.WriteHeader(http.StatusNotFound)
+		default:
 			w.WriteHeader(http.StatusInternalServerError)
-			w.Header().Set("Content-Type", "text/plain")
 		}
 		return
 	}
+}
 
-	var cont general.ContentUnit
-	if err := json.Unmarshal(body, &cont); err != nil {
-		h.log.Warnf("Error during unmarshall: %+v", err.Error())
-		w.WriteHeader(http.StatusExpectationFailed)
+// validate writes schema errors to the response, it returns false when the body isn't valid
+func (h *Handlers) validate(w http.ResponseWriter, body []byte, name string) bool {
+	err, errs := h.validator.Validate(body, name)
+	if errs == nil {
+		return true
+	}
+	if err != nil {
+		h.log.Warnf("%+v", err.Error())
+	}
+	h.log.Warnf("The document is not valid. see errors :\n")
+	h.log.Warnf("%+v", errs)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	if err := json.NewEncoder(w).Encode(errs); err != nil {
+		h.log.Warnf("Error while encoding validation errors: %s", err.Error())
+	}
+	return false
+}
+
+func (h *Handlers) editHandler(w http.ResponseWriter, req *http.Request) {
+	// Stars and comment aren't required, absent ones are kept
+	var data struct {
+		general.ContentUnit
+		Stars *int       `json:"stars"`
+		Comment *string  `json:"comment"`
+	}
+	session, ok := h.writeRequest(w, req, CONTENT_USER_PART_VALIDATE, &data)
+	if !ok {
 		return
 	}
+	cont := data.ContentUnit
 
 	var cu general.ContentUnit
 	if err := mongo.Answers.FindOne(bson.M{"_id": cont.Id, auth.SidKey: session.Sid}, &cu); err != nil {
@@ -365,58 +459,381 @@ func (h *Handlers) editHandler(w http.ResponseWriter, req *http.Request) {
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
+	// The unit is replaced by the cached answer, so fields absent in the request are copied from the current one
+	var current general.ContentUnit
+	if err := mongo.Units.FindOne(bson.M{"_id": cont.Id, "uid": session.Uid}, &current); err != nil {
+		if err.Error() == "not found" {
+			h.log.Warnf("There is no unit %s to edit", cont.Id.Hex())
+			h.writeContentError(w, content.ErrorUnitNotFound{})
+			return
+		}
+		h.log.Warnf("Error getting users content: %+v", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
 	cu.Edited = time.Now()
-	cu.Stars = cont.Stars
-	cu.Comment = cont.Comment
+	cu.Stars = current.Stars
+	if data.Stars != nil {
+		cu.Stars = *data.Stars
+	}
+	cu.Comment = current.Comment
+	if data.Comment != nil {
+		cu.Comment = *data.Comment
+	}
+	cu.Tags = current.Tags
+	if cont.Tags != nil {
+		cu.Tags = content.NormalizeTags(cont.Tags)
+	}
+	if err := content.SetStatus(&cu, &cont, &current); err != nil {
+		h.log.Warnf("Invalid unit %s: %s", cont.Id.Hex(), err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
 
 	if err := mongo.Units.Update(bson.M{"_id": cont.Id, "uid": session.Uid}, cu); err != nil {
 		h.log.Warnf("Error updateing users content: %+v", err.Error())
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
+	if err := content.RecordChange(mongo.History, session.Uid, &current, &cu, source(session)); err != nil {
+		h.log.Warnf("Error recording history of unit %s: %s", cu.Id.Hex(), err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
 }
 
 func (h *Handlers) removeHandler(w http.ResponseWriter, req *http.Request) {
-	a, session := auth.Is(req, mongo.Sessions, h.log)
+	var cont general.ContentUnit
+	session, ok := h.writeRequest(w, req, CONTENT_USER_PART_VALIDATE, &cont)
+	if !ok {
+		return
+	}
+
+	if err := mongo.Units.Remove(bson.M{"_id": cont.Id, "uid": session.Uid}); err != nil {
+		h.log.Warnf("Error during removing: %+v", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if err := content.RemoveUnitFromLists(mongo.Lists, session.Uid, cont.Id); err != nil {
+		// Lists skip entries of removed units, so the unit removal isn't failed
+		h.log.Warnf("Error removing unit %s from lists: %s", cont.Id.Hex(), err.Error())
+	}
+	if err := content.RemoveUnitDiary(mongo.Diary, session.Uid, cont.Id); err != nil {
+		h.log.Warnf("Error removing diary of unit %s: %s", cont.Id.Hex(), err.Error())
+	}
+	if err := content.RemoveUnitHistory(mongo.History, session.Uid, cont.Id); err != nil {
+		h.log.Warnf("Error removing history of unit %s: %s", cont.Id.Hex(), err.Error())
+	}
+}
+
+// source tells the history where the change comes from
+func source(session *auth.Session) string {
+	if session.Scope != "" {
+		return content.SOURCE_API_KEY
+	}
+	return content.SOURCE_WEB
+}
+
+func (h *Handlers) writeJson(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		h.log.Warnf("Error while encoding response: %s", err.Error())
+	}
+}
+
+// writeContentError maps errors of list, diary and history operations to response statuses
+func (h *Handlers) writeContentError(w http.ResponseWriter, err error) {
+	switch err.(type) {
+	case content.ErrorInvalidQuery, content.ErrorInvalidUnit:
+		w.WriteHeader(http.StatusBadRequest)
+	case content.ErrorListNotFound, content.ErrorUnitNotFound, content.ErrorDiaryEntryNotFound,
+		content.ErrorHistoryEntryNotFound:
+		w.WriteHeader(http.StatusNotFound)
+	case content.ErrorAlreadyInList, content.ErrorListChanged:
+		w.WriteHeader(http.StatusConflict)
+	case content.ErrorTooManyLists, content.ErrorListFull:
+		w.WriteHeader(http.StatusForbidden)
+	default:
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Write([]byte(err.Error()))
+}
+
+// writeRequest authorizes and validates mutating requests, it returns false when the response is written
+func (h *Handlers) writeRequest(w http.ResponseWriter, req *http.Request, name string, data interface{}) (*auth.Session, bool) {
+	a, session := h.auth.Is(req)
 	if !a {
 		h.log.Warnf("Non authorized request: %+v", req.RequestURI)
 		w.WriteHeader(http.StatusNotAcceptable)
-		return
+		return nil, false
+	}
+	if !session.CanWrite() {
+		h.log.Warnf("Write request with read-only credentials: %+v", req.RequestURI)
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(auth.ErrorReadOnly{}.Error()))
+		return nil, false
 	}
 	body, err, status := general.ValidateRequest(req, http.MethodPost, true)
 	if err != nil {
 		h.log.Warn(err.Error())
 		w.WriteHeader(status)
+		return nil, false
+	}
+	if !h.validate(w, body, name) {
+		return nil, false
+	}
+	if err := json.Unmarshal(body, data); err != nil {
+		h.log.Warnf("Error during unmarshall: %+v", err.Error())
+		w.WriteHeader(http.StatusExpectationFailed)
+		return nil, false
+	}
+	return session, true
+}
+
+func (h *Handlers) listsHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		h.log.Warnf("Wrong http lists request method: %s", req.Method)
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	a, session := h.auth.Is(req)
+	if !a {
+		h.log.Warnf("Non authorized request: %+v", req.RequestURI)
+		w.WriteHeader(http.StatusNotAcceptable)
 		return
 	}
 
-	err, errs := h.validator.Validate(body, CONTENT_USER_PART_VALIDATE)
-	if errs != nil {
-		if err != nil {
-			h.log.Warnf("%+v", err.Error())
-		}
-		h.log.Warnf("The document is not valid. see errors :\n")
-		h.log.Warnf("%+v", errs)
+	lists, err := content.Lists(mongo.Lists, session.Uid)
+	if err != nil {
+		h.log.Warnf("Error getting lists req %s: %s", req.RequestURI, err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	h.writeJson(w, lists)
+}
+
+// getListHandler returns the list with units, public lists are available without authorization
+func (h *Handlers) getListHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		h.log.Warnf("Wrong http get list request method: %s", req.Method)
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	id := req.FormValue("id")
+	if !bson.IsObjectIdHex(id) {
 		w.WriteHeader(http.StatusBadRequest)
-		w.Header().Set("Content-Type", "application/json")
-		if err := json.NewEncoder(w).Encode(errs); err != nil {
-			h.log.Warn("Error while encoding validation errors: %s", err.Error())
-			w.WriteHeader(http.StatusInternalServerError)
-			w.Header().Set("Content-Type", "text/plain")
-		}
+		w.Write([]byte("Invalid id"))
 		return
 	}
+	var uid bson.ObjectId
+	if a, session := h.auth.Is(req); a {
+		uid = session.Uid
+	}
 
-	var cont general.ContentUnit
-	if err := json.Unmarshal(body, &cont); err != nil {
-		h.log.Warnf("Error during unmarshall: %+v", err.Error())
-		w.WriteHeader(http.StatusExpectationFailed)
+	list, err := content.GetList(mongo.Lists, mongo.Units, h.auth, uid, bson.ObjectIdHex(id))
+	if err != nil {
+		h.log.Warnf("Error getting list %s: %s", id, err.Error())
+		h.writeContentError(w, err)
 		return
 	}
+	h.writeJson(w, list)
+}
 
-	if err := mongo.Units.Remove(bson.M{"_id": cont.Id, "uid": session.Uid}); err != nil {
-		h.log.Warnf("Error during removing: %+v", err.Error())
+func (h *Handlers) createListHandler(w http.ResponseWriter, req *http.Request) {
+	var data content.ListData
+	session, ok := h.writeRequest(w, req, LIST_VALIDATE, &data)
+	if !ok {
+		return
+	}
+	list, err := content.CreateList(mongo.Lists, session.Uid, &data)
+	if err != nil {
+		h.log.Warnf("Error creating list: %s", err.Error())
+		h.writeContentError(w, err)
+		return
+	}
+	h.writeJson(w, list)
+}
+
+func (h *Handlers) updateListHandler(w http.ResponseWriter, req *http.Request) {
+	var data content.ListData
+	session, ok := h.writeRequest(w, req, LIST_VALIDATE, &data)
+	if !ok {
+		return
+	}
+	if !data.Id.Valid() {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("id is required"))
+		return
+	}
+	if err := content.UpdateList(mongo.Lists, session.Uid, &data); err != nil {
+		h.log.Warnf("Error updating list %s: %s", data.Id.Hex(), err.Error())
+		h.writeContentError(w, err)
+	}
+}
+
+func (h *Handlers) removeListHandler(w http.ResponseWriter, req *http.Request) {
+	var data content.ListId
+	session, ok := h.writeRequest(w, req, LIST_ID_VALIDATE, &data)
+	if !ok {
+		return
+	}
+	if err := content.RemoveList(mongo.Lists, session.Uid, data.Id); err != nil {
+		h.log.Warnf("Error removing list %s: %s", data.Id.Hex(), err.Error())
+		h.writeContentError(w, err)
+	}
+}
+
+func (h *Handlers) addListEntryHandler(w http.ResponseWriter, req *http.Request) {
+	var data content.ListEntryData
+	session, ok := h.writeRequest(w, req, LIST_ENTRY_VALIDATE, &data)
+	if !ok {
+		return
+	}
+	if err := content.AddListEntry(mongo.Lists, mongo.Units, session.Uid, &data); err != nil {
+		h.log.Warnf("Error adding unit %s to list %s: %s", data.Unit.Hex(), data.Id.Hex(), err.Error())
+		h.writeContentError(w, err)
+	}
+}
+
+func (h *Handlers) removeListEntryHandler(w http.ResponseWriter, req *http.Request) {
+	var data content.ListEntryData
+	session, ok := h.writeRequest(w, req, LIST_ENTRY_VALIDATE, &data)
+	if !ok {
+		return
+	}
+	if err := content.RemoveListEntry(mongo.Lists, session.Uid, &data); err != nil {
+		h.log.Warnf("Error removing unit %s from list %s: %s", data.Unit.Hex(), data.Id.Hex(), err.Error())
+		h.writeContentError(w, err)
+	}
+}
+
+func (h *Handlers) reorderListHandler(w http.ResponseWriter, req *http.Request) {
+	var data content.ListOrderData
+	session, ok := h.writeRequest(w, req, LIST_ORDER_VALIDATE, &data)
+	if !ok {
+		return
+	}
+	if err := content.ReorderList(mongo.Lists, session.Uid, &data); err != nil {
+		h.log.Warnf("Error reordering list %s: %s", data.Id.Hex(), err.Error())
+		h.writeContentError(w, err)
+	}
+}
+
+func (h *Handlers) diaryHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		h.log.Warnf("Wrong http diary request method: %s", req.Method)
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	a, session := h.auth.Is(req)
+	if !a {
+		h.log.Warnf("Non authorized request: %+v", req.RequestURI)
+		w.WriteHeader(http.StatusNotAcceptable)
+		return
+	}
+
+	query, err := content.ParseDiaryQuery(req.URL.Query())
+	if err != nil {
+		h.log.Warnf("Invalid diary query %s: %s", req.RequestURI, err.Error())
+		h.writeContentError(w, err)
+		return
+	}
+	items, err := content.Diary(mongo.Diary, mongo.Units, session.Uid, query)
+	if err != nil {
+		h.log.Warnf("Error getting diary req %s: %s", req.RequestURI, err.Error())
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
-}
\ No newline at end of file
+	h.writeJson(w, items)
+}
+
+func (h *Handlers) addDiaryEntryHandler(w http.ResponseWriter, req *http.Request) {
+	var data content.DiaryEntryData
+	session, ok := h.writeRequest(w, req, DIARY_ENTRY_VALIDATE, &data)
+	if !ok {
+		return
+	}
+	entry, err := content.AddDiaryEntry(mongo.Diary, mongo.Units, mongo.History, session.Uid, &data, source(session))
+	if err != nil {
+		h.log.Warnf("Error adding diary entry of unit %s: %s", data.Unit.Hex(), err.Error())
+		h.writeContentError(w, err)
+		return
+	}
+	h.writeJson(w, entry)
+}
+
+func (h *Handlers) editDiaryEntryHandler(w http.ResponseWriter, req *http.Request) {
+	var data content.DiaryEntryData
+	session, ok := h.writeRequest(w, req, DIARY_ENTRY_VALIDATE, &data)
+	if !ok {
+		return
+	}
+	if !data.Id.Valid() {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("id is required"))
+		return
+	}
+	if err := content.EditDiaryEntry(mongo.Diary, mongo.Units, mongo.History, session.Uid, &data,
+		source(session)); err != nil {
+		h.log.Warnf("Error editing diary entry %s: %s", data.Id.Hex(), err.Error())
+		h.writeContentError(w, err)
+	}
+}
+
+func (h *Handlers) removeDiaryEntryHandler(w http.ResponseWriter, req *http.Request) {
+	var data content.DiaryEntryId
+	session, ok := h.writeRequest(w, req, DIARY_ID_VALIDATE, &data)
+	if !ok {
+		return
+	}
+	if err := content.RemoveDiaryEntry(mongo.Diary, mongo.Units, mongo.History, session.Uid, data.Id,
+		source(session)); err != nil {
+		h.log.Warnf("Error removing diary entry %s: %s", data.Id.Hex(), err.Error())
+		h.writeContentError(w, err)
+	}
+}
+
+func (h *Handlers) historyHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		h.log.Warnf("Wrong http history request method: %s", req.Method)
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	a, session := h.auth.Is(req)
+	if !a {
+		h.log.Warnf("Non authorized request: %+v", req.RequestURI)
+		w.WriteHeader(http.StatusNotAcceptable)
+		return
+	}
+
+	query, err := content.ParseHistoryQuery(req.URL.Query())
+	if err != nil {
+		h.log.Warnf("Invalid history query %s: %s", req.RequestURI, err.Error())
+		h.writeContentError(w, err)
+		return
+	}
+	entries, err := content.History(mongo.History, session.Uid, query)
+	if err != nil {
+		h.log.Warnf("Error getting history req %s: %s", req.RequestURI, err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	h.writeJson(w, entries)
+}
+
+func (h *Handlers) restoreHandler(w http.ResponseWriter, req *http.Request) {
+	var data content.HistoryEntryId
+	session, ok := h.writeRequest(w, req, HISTORY_ID_VALIDATE, &data)
+	if !ok {
+		return
+	}
+	unit, err := content.RestoreVersion(mongo.History, mongo.Units, session.Uid, data.Id, source(session))
+	if err != nil {
+		h.log.Warnf("Error restoring version %s: %s", data.Id.Hex(), err.Error())
+		h.writeContentError(w, err)
+		return
+	}
+	h.writeJson(w, unit)
+}
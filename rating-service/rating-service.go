@@ -11,16 +11,31 @@ import (
 	"github.com/xeipuuv/gojsonschema"
 	"github.com/dzendmitry/rating-service/lib/general"
 	"os"
+	"github.com/dzendmitry/rating-service/lib/auth"
+	"github.com/dzendmitry/rating-service/lib/content"
 )
 
 var (
 	mongoUrl       = os.Getenv("MONGO_URL")
 	mongoDb        = os.Getenv("MONGO_DB")
 	ucpJsonSchema  = os.Getenv("UCP_JSON_SCHEMA")
+	tagRenameJsonSchema = os.Getenv("TAG_RENAME_JSON_SCHEMA")
+	listJsonSchema      = os.Getenv("LIST_JSON_SCHEMA")
+	listIdJsonSchema    = os.Getenv("LIST_ID_JSON_SCHEMA")
+	listEntryJsonSchema = os.Getenv("LIST_ENTRY_JSON_SCHEMA")
+	listOrderJsonSchema = os.Getenv("LIST_ORDER_JSON_SCHEMA")
+	diaryEntryJsonSchema = os.Getenv("DIARY_ENTRY_JSON_SCHEMA")
+	diaryIdJsonSchema    = os.Getenv("DIARY_ID_JSON_SCHEMA")
+	historyIdJsonSchema  = os.Getenv("HISTORY_ID_JSON_SCHEMA")
 	ifis           = os.Getenv("INTERFACE")
 	sentinel1       = os.Getenv("REDIS_SENTINEL_1")
 	sentinel2       = os.Getenv("REDIS_SENTINEL_2")
 	sentinel3       = os.Getenv("REDIS_SENTINEL_3")
+	authUrl         = os.Getenv("AUTH_URL")
+	serviceSecret   = os.Getenv("SERVICE_SECRET")
+	authMode        = os.Getenv("AUTH_MODE")
+	tokenKeys       = os.Getenv("TOKEN_KEYS")
+	tokenKeyId      = os.Getenv("TOKEN_KEY_ID")
 )
 
 func init() {
@@ -33,6 +48,30 @@ func init() {
 	if ucpJsonSchema == "" {
 		panic("env REG_JSON_SCHEMA is empty")
 	}
+	if tagRenameJsonSchema == "" {
+		panic("env TAG_RENAME_JSON_SCHEMA is empty")
+	}
+	if listJsonSchema == "" {
+		panic("env LIST_JSON_SCHEMA is empty")
+	}
+	if listIdJsonSchema == "" {
+		panic("env LIST_ID_JSON_SCHEMA is empty")
+	}
+	if listEntryJsonSchema == "" {
+		panic("env LIST_ENTRY_JSON_SCHEMA is empty")
+	}
+	if listOrderJsonSchema == "" {
+		panic("env LIST_ORDER_JSON_SCHEMA is empty")
+	}
+	if diaryEntryJsonSchema == "" {
+		panic("env DIARY_ENTRY_JSON_SCHEMA is empty")
+	}
+	if diaryIdJsonSchema == "" {
+		panic("env DIARY_ID_JSON_SCHEMA is empty")
+	}
+	if historyIdJsonSchema == "" {
+		panic("env HISTORY_ID_JSON_SCHEMA is empty")
+	}
 	if ifis == "" {
 		panic("env INTERFACE is empty")
 	}
@@ -45,6 +84,28 @@ func init() {
 	if sentinel3 == "" {
 		panic("env REDIS_SENTINEL_3 is empty")
 	}
+	if authUrl == "" {
+		panic("env AUTH_URL is empty")
+	}
+	if serviceSecret == "" {
+		panic("env SERVICE_SECRET is empty")
+	}
+	switch authMode {
+	case "", auth.AUTH_MODE_COOKIE:
+	case auth.AUTH_MODE_TOKEN:
+		if tokenKeys == "" {
+			panic("env TOKEN_KEYS is empty")
+		}
+		keys, err := auth.ParseTokenKeys(tokenKeys)
+		if err != nil {
+			panic(fmt.Sprintf("env TOKEN_KEYS is invalid: %+v", err))
+		}
+		if err := auth.InitTokens(keys, tokenKeyId); err != nil {
+			panic(fmt.Sprintf("env TOKEN_KEY_ID is invalid: %+v", err))
+		}
+	default:
+		panic(fmt.Sprintf("env AUTH_MODE must be %s or %s", auth.AUTH_MODE_COOKIE, auth.AUTH_MODE_TOKEN))
+	}
 }
 
 func main() {
@@ -68,13 +129,42 @@ func main() {
 	ucp := gojsonschema.NewReferenceLoader(ucpJsonSchema)
 	schemaLoaders := map[string]gojsonschema.JSONLoader{
 		CONTENT_USER_PART_VALIDATE: ucp,
+		TAG_RENAME_VALIDATE: gojsonschema.NewReferenceLoader(tagRenameJsonSchema),
+		LIST_VALIDATE: gojsonschema.NewReferenceLoader(listJsonSchema),
+		LIST_ID_VALIDATE: gojsonschema.NewReferenceLoader(listIdJsonSchema),
+		LIST_ENTRY_VALIDATE: gojsonschema.NewReferenceLoader(listEntryJsonSchema),
+		LIST_ORDER_VALIDATE: gojsonschema.NewReferenceLoader(listOrderJsonSchema),
+		DIARY_ENTRY_VALIDATE: gojsonschema.NewReferenceLoader(diaryEntryJsonSchema),
+		DIARY_ID_VALIDATE: gojsonschema.NewReferenceLoader(diaryIdJsonSchema),
+		HISTORY_ID_VALIDATE: gojsonschema.NewReferenceLoader(historyIdJsonSchema),
 	}
 
-	h := NewHandlers(pl.GetTypeC(), general.NewValidator(schemaLoaders, log), log)
+	h := NewHandlers(pl.GetTypeC(), general.NewValidator(schemaLoaders, log), auth.NewClient(authUrl, serviceSecret, log),
+		content.NewTextIndex(mongo.Units), log)
 
 	http.HandleFunc(getMoviesUrl(), h.getContent)
 	http.HandleFunc(getBooksUrl(), h.getContent)
 	http.HandleFunc(getAllContentUrl(), h.getContent)
+	http.HandleFunc(searchUrl(), h.searchHandler)
+	http.HandleFunc(tagsUrl(), h.tagsHandler)
+	http.HandleFunc(renameTagUrl(), h.renameTagHandler)
+
+	http.HandleFunc(listsUrl(), h.listsHandler)
+	http.HandleFunc(getListUrl(), h.getListHandler)
+	http.HandleFunc(createListUrl(), h.createListHandler)
+	http.HandleFunc(updateListUrl(), h.updateListHandler)
+	http.HandleFunc(removeListUrl(), h.removeListHandler)
+	http.HandleFunc(addListEntryUrl(), h.addListEntryHandler)
+	http.HandleFunc(removeListEntryUrl(), h.removeListEntryHandler)
+	http.HandleFunc(reorderListUrl(), h.reorderListHandler)
+
+	http.HandleFunc(diaryUrl(), h.diaryHandler)
+	http.HandleFunc(addDiaryEntryUrl(), h.addDiaryEntryHandler)
+	http.HandleFunc(editDiaryEntryUrl(), h.editDiaryEntryHandler)
+	http.HandleFunc(removeDiaryEntryUrl(), h.removeDiaryEntryHandler)
+
+	http.HandleFunc(historyUrl(), h.historyHandler)
+	http.HandleFunc(restoreUrl(), h.restoreHandler)
 
 	http.HandleFunc(addMovieUrl(), h.addHandler)
 	http.HandleFunc(addBookUrl(), h.addHandler)
@@ -10,8 +10,91 @@ const (
 	EDIT_URL = "edit"
 	GET_URL = "get"
 	REMOVE_URL = "remove"
+	SEARCH_URL = "search"
+	TAGS_URL = "tags"
+	RENAME_URL = "rename"
+	LISTS_URL = "lists"
+	CREATE_URL = "create"
+	UPDATE_URL = "update"
+	ENTRIES_URL = "entries"
+	REORDER_URL = "reorder"
+	DIARY_URL = "diary"
+	HISTORY_URL = "history"
+	RESTORE_URL = "restore"
 )
 
+func historyUrl() string {
+	return general.BASE_URL_V1 + HISTORY_URL
+}
+
+func restoreUrl() string {
+	return historyUrl() + "/" + RESTORE_URL
+}
+
+func diaryUrl() string {
+	return general.BASE_URL_V1 + DIARY_URL
+}
+
+func addDiaryEntryUrl() string {
+	return diaryUrl() + "/" + ADD_URL
+}
+
+func editDiaryEntryUrl() string {
+	return diaryUrl() + "/" + EDIT_URL
+}
+
+func removeDiaryEntryUrl() string {
+	return diaryUrl() + "/" + REMOVE_URL
+}
+
+func listsUrl() string {
+	return general.BASE_URL_V1 + LISTS_URL
+}
+
+func getListUrl() string {
+	return listsUrl() + "/" + GET_URL
+}
+
+func createListUrl() string {
+	return listsUrl() + "/" + CREATE_URL
+}
+
+func updateListUrl() string {
+	return listsUrl() + "/" + UPDATE_URL
+}
+
+func removeListUrl() string {
+	return listsUrl() + "/" + REMOVE_URL
+}
+
+func listEntriesUrl() string {
+	return listsUrl() + "/" + ENTRIES_URL
+}
+
+func addListEntryUrl() string {
+	return listEntriesUrl() + "/" + ADD_URL
+}
+
+func removeListEntryUrl() string {
+	return listEntriesUrl() + "/" + REMOVE_URL
+}
+
+func reorderListUrl() string {
+	return listEntriesUrl() + "/" + REORDER_URL
+}
+
+func tagsUrl() string {
+	return general.BASE_URL_V1 + TAGS_URL
+}
+
+func renameTagUrl() string {
+	return tagsUrl() + "/" + RENAME_URL
+}
+
+func searchUrl() string {
+	return general.BASE_URL_V1 + SEARCH_URL
+}
+
 func getAllContentUrl() string {
 	return general.BASE_URL_V1 + GET_URL
 }